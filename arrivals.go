@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ArrivalStep is one point of a demand profile, the arrival rate at Time
+type ArrivalStep struct {
+	Time float64 `json:"time"`
+	Rate float64 `json:"rate"`
+}
+
+// validateOpenSystem checks that an open system has a way to stop
+func (config *GeneralLaneSimulationConfig) validateOpenSystem() error {
+	if config.horizon < 0 || config.maxExits < 0 {
+		return errors.New("the horizon and maxExits cannot be negative")
+	}
+	if !config.openSystem {
+		return nil
+	}
+	if config.ringRoad {
+		return errors.New("a ring road is closed, it cannot also be an open system")
+	}
+	if config.horizon == 0 && config.maxExits == 0 {
+		return errors.New("an open system never runs out of cars, it needs a horizon or maxExits")
+	}
+	return nil
+}
+
+// endTime is when the run stops even with events left, infinite when only running out of cars stops it
+func (sim *GeneralLaneSimulation) endTime() float64 {
+	if sim.config.ringRoad {
+		return sim.config.ringDuration
+	}
+	if sim.config.horizon > 0 {
+		return sim.config.horizon
+	}
+	return math.Inf(1)
+}
+
+// carArrived creates a car at the back of the street's entry queue and lets the front car in if there is room
+func (sim *GeneralLaneSimulation) carArrived(street *Street) {
+	config := sim.config
+	id := fmt.Sprintf("%s %d", street.carPrefix(), street.numArrived)
+	street.numArrived++
+	speed, _ := getNewCarSpeed(sim.random.carClock, config.CarDistributionType, config.carSpeedUniformEndRange, config.carClock,
+		config.removeUnlikelyEvents, config.unlikelyCutoff)
+	car := newSmartCar(id, street.Direction, speed, config.carMovementP)
+	car.trip.ArrivalTime = sim.scheduler.Now()
+	sim.assignDestination(car, street)
+	street.InRoot.addCar(car)
+	sim.traceCar(traceCarArrived, car, nil, street.InRoot, "")
+	sim.moveCarIn(street)
+}
+
+// firstArrived is the car that has waited longest in an entry queue
+func (loc *StatefulLocation) firstArrived() *SmartCar {
+	cars := loc.sortedCars()
+	if len(cars) == 0 {
+		return nil
+	}
+	first := cars[0]
+	for _, car := range cars[1:] {
+		if car.trip.ArrivalTime < first.trip.ArrivalTime {
+			first = car
+		}
+	}
+	loc.removeCar(first)
+	return first
+}
+
+// admitQueued lets the next queued car of an open system in once a car moved off the first cell of its street
+func (sim *GeneralLaneSimulation) admitQueued(loc *StatefulLocation) {
+	if !sim.config.openSystem {
+		return
+	}
+	for _, street := range sim.streets {
+		if street.entry && street.hasLane(loc) && indexAlong(street.Direction, loc.X, loc.Y) == street.First && !street.InRoot.noCars() {
+			sim.moveCarIn(street)
+		}
+	}
+}
+
+// countArrived gives the streets of a restored open system back their count of cars, so new ids stay unique
+func (sim *GeneralLaneSimulation) countArrived() {
+	for _, street := range sim.streets {
+		street.numArrived = 0
+		prefix := street.carPrefix() + " "
+		for _, loc := range sim.allLocations() {
+			for _, car := range loc.sortedCars() {
+				if strings.HasPrefix(car.ID, prefix) {
+					street.numArrived++
+				}
+			}
+		}
+	}
+}
+
+// isOpenSystemDone tells whether enough cars left
+func (sim *GeneralLaneSimulation) isOpenSystemDone() bool {
+	if sim.config.maxExits == 0 {
+		return false
+	}
+	numOut := 0
+	for _, street := range sim.streets {
+		street.OutRoot.locationLock.Lock()
+		numOut += len(street.OutRoot.Cars)
+		street.OutRoot.locationLock.Unlock()
+	}
+	return numOut-sim.emergencyOut >= sim.config.maxExits
+}
+
+// ThroughputMetrics counts the cars through the grid after the warm up
+type ThroughputMetrics struct {
+	NumArrived int     `json:"numArrived"` // cars that reached an entry, or started in the pool
+	NumEntered int     `json:"numEntered"`
+	NumExited  int     `json:"numExited"`
+	Throughput float64 `json:"throughput"` // exits per unit of time
+}
+
+// throughputMetrics is safe to call once the simulation stopped
+func (sim *GeneralLaneSimulation) throughputMetrics() ThroughputMetrics {
+	metrics := ThroughputMetrics{}
+	warmUp := sim.config.warmUp
+	for _, record := range sim.tripRecords() {
+		trip := record.Trip
+		if record.Emergency {
+			continue
+		}
+		if trip.ArrivalTime >= warmUp || !sim.config.openSystem {
+			metrics.NumArrived++
+		}
+		if trip.Entered && trip.EntryTime >= warmUp {
+			metrics.NumEntered++
+		}
+		if trip.Exited && trip.ExitTime >= warmUp {
+			metrics.NumExited++
+		}
+	}
+	sim.runningSimulationLock.Lock()
+	measured := sim.simulatedTime - warmUp
+	sim.runningSimulationLock.Unlock()
+	if measured > 0 {
+		metrics.Throughput = float64(metrics.NumExited) / measured
+	}
+	return metrics
+}
+
+// parseArrivalProfile reads steps written as "time:rate,time:rate"
+func parseArrivalProfile(s string) ([]ArrivalStep, error) {
+	profile := make([]ArrivalStep, 0)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Split(item, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("arrival step %q is not time:rate", item)
+		}
+		time, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		if err != nil {
+			return nil, err
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return nil, err
+		}
+		profile = append(profile, ArrivalStep{Time: time, Rate: rate})
+	}
+	return profile, nil
+}
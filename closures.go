@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Closure shuts cells for a while, like road works. It closes the listed cells, or lanes of a street from one cell
+// along it to another
+type Closure struct {
+	Start  float64  `json:"start"`
+	End    float64  `json:"end"`             // 0 keeps them closed to the end of the run
+	Cells  [][2]int `json:"cells,omitempty"` // row and column
+	Street string   `json:"street,omitempty"`
+	Lanes  []int    `json:"lanes,omitempty"` // positions among the lanes of the street in grid order, all of them when empty
+	From   int      `json:"from"`
+	To     int      `json:"to"`
+}
+
+// closedCell is a cell shut by at least one closure
+type closedCell struct {
+	prev     LocationState // what it goes back to once every closure of it ended
+	closures int
+}
+
+// ClosureMetrics compares the delay of the cars that had to merge around or wait at a closure with the others
+type ClosureMetrics struct {
+	Merges          int     `json:"merges"`
+	BlockedMoves    int     `json:"blockedMoves"`
+	AffectedDelay   Summary `json:"affectedDelay"`
+	UnaffectedDelay Summary `json:"unaffectedDelay"`
+}
+
+// loadClosures checks the closures and finds their cells
+func (sim *GeneralLaneSimulation) loadClosures() error {
+	sim.closedCells = map[*StatefulLocation]*closedCell{}
+	sim.closureCells = make([][]*StatefulLocation, 0, len(sim.config.closures))
+	if sim.config.mergeDistance < 0 {
+		return errors.New("the merge distance cannot be negative")
+	}
+	for i, closure := range sim.config.closures {
+		cells, err := sim.closureLocations(closure)
+		if err != nil {
+			return errors.Wrapf(err, "closure %d", i)
+		}
+		sim.closureCells = append(sim.closureCells, cells)
+	}
+	return nil
+}
+
+func (sim *GeneralLaneSimulation) closureLocations(closure Closure) ([]*StatefulLocation, error) {
+	if closure.Start < 0 || closure.End != 0 && closure.End <= closure.Start {
+		return nil, errors.New("a closure has to start at 0 or later and end after it starts")
+	}
+	if (len(closure.Cells) > 0) == (closure.Street != "") {
+		return nil, errors.New("give either cells or a street")
+	}
+	cells := make([]*StatefulLocation, 0)
+	for _, cell := range closure.Cells {
+		if !sim.inGrid(cell[0], cell[1]) {
+			return nil, fmt.Errorf("cell %d,%d is off the grid", cell[0], cell[1])
+		}
+		cells = append(cells, sim.Locations[cell[0]][cell[1]])
+	}
+	if closure.Street != "" {
+		street := sim.streetNamed(closure.Street)
+		if street == nil {
+			return nil, fmt.Errorf("there is no street %s", closure.Street)
+		}
+		if !street.contains(closure.From) || !street.contains(closure.To) {
+			return nil, fmt.Errorf("cells %d to %d are not all on street %s", closure.From, closure.To, closure.Street)
+		}
+		lanes := closure.Lanes
+		if len(lanes) == 0 {
+			lanes = indexRange(0, len(street.Lanes)-1)
+		}
+		low, high := closure.From, closure.To
+		if low > high {
+			low, high = high, low
+		}
+		for _, lane := range lanes {
+			if lane < 0 || lane >= len(street.Lanes) {
+				return nil, fmt.Errorf("street %s has no lane %d", closure.Street, lane)
+			}
+			for index := low; index <= high; index++ {
+				if street.Direction.isHorizontal() {
+					cells = append(cells, sim.Locations[street.Lanes[lane]][index])
+				} else {
+					cells = append(cells, sim.Locations[index][street.Lanes[lane]])
+				}
+			}
+		}
+	}
+	for _, loc := range cells {
+		if state := loc.getLocationState(); state != LaneLoc && state != Intersection {
+			return nil, fmt.Errorf("cell %d,%d is not on a lane", loc.X, loc.Y)
+		}
+	}
+	return cells, nil
+}
+
+// scheduleClosures schedules the start of every closure
+func (sim *GeneralLaneSimulation) scheduleClosures() {
+	for i, closure := range sim.config.closures {
+		delay := closure.Start - sim.scheduler.Now()
+		if delay < 0 {
+			delay = 0
+		}
+		sim.scheduler.schedule(delay, &SimEvent{Type: closureStartEvent, closure: i})
+	}
+}
+
+// startClosure shuts the cells of closure i. A cell with an accident on it closes once the accident is cleared
+func (sim *GeneralLaneSimulation) startClosure(i int) {
+	for _, loc := range sim.closureCells[i] {
+		cell := sim.closedCells[loc]
+		if cell == nil {
+			cell = &closedCell{prev: loc.getLocationState()}
+			sim.closedCells[loc] = cell
+			if cell.prev != AccidentLocationState {
+				sim.changeLocationState(loc, ClosedLocationState)
+			}
+		}
+		cell.closures++
+	}
+	if end := sim.config.closures[i].End; end > 0 {
+		sim.scheduler.schedule(end-sim.scheduler.Now(), &SimEvent{Type: closureEndEvent, closure: i})
+	}
+	sim.notifyDraw()
+}
+
+// endClosure opens the cells of closure i again, unless another closure still shuts them
+func (sim *GeneralLaneSimulation) endClosure(i int) {
+	for _, loc := range sim.closureCells[i] {
+		cell := sim.closedCells[loc]
+		if cell == nil {
+			continue
+		}
+		cell.closures--
+		if cell.closures > 0 {
+			continue
+		}
+		delete(sim.closedCells, loc)
+		if loc.getLocationState() == ClosedLocationState {
+			sim.changeLocationState(loc, cell.prev)
+		}
+	}
+	sim.notifyDraw()
+}
+
+// afterAccident is the state of loc once its accident is cleared, closed if a closure started meanwhile
+func (sim *GeneralLaneSimulation) afterAccident(loc *StatefulLocation, prev LocationState) LocationState {
+	if cell := sim.closedCells[loc]; cell != nil {
+		cell.prev = prev
+		return ClosedLocationState
+	}
+	return prev
+}
+
+// closedAhead tells whether the lane of loc is closed at loc or within mergeDistance cells after it
+func (sim *GeneralLaneSimulation) closedAhead(loc *StatefulLocation, direction Direction) bool {
+	if len(sim.closedCells) == 0 {
+		return false
+	}
+	dx, dy := direction.step()
+	x, y := loc.X, loc.Y
+	for i := 0; i <= sim.config.mergeDistance && sim.inGrid(x, y); i++ {
+		if sim.Locations[x][y].getLocationState() == ClosedLocationState {
+			return true
+		}
+		x, y = x+dx, y+dy
+	}
+	return false
+}
+
+// mergesBeforeClosure tells whether a car going to loc leaves its lane because of a closure. It has to when loc is
+// closed, and switches lanes like any other car when the closure is further ahead
+func (sim *GeneralLaneSimulation) mergesBeforeClosure(loc *StatefulLocation, direction Direction) bool {
+	if !sim.closedAhead(loc, direction) {
+		return false
+	}
+	return loc.getLocationState() == ClosedLocationState || sim.random.laneChoice.UniformRand() < sim.config.probSwitchingLanes
+}
+
+// avoidClosures keeps the lanes that are not closed ahead, or at least not closed here. Every lane is kept when all
+// of them are closed, the car then waits
+func (sim *GeneralLaneSimulation) avoidClosures(lanes []*StatefulLocation, direction Direction) []*StatefulLocation {
+	if len(sim.closedCells) == 0 {
+		return lanes
+	}
+	clear := make([]*StatefulLocation, 0, len(lanes))
+	open := make([]*StatefulLocation, 0, len(lanes))
+	for _, loc := range lanes {
+		if loc.getLocationState() == ClosedLocationState {
+			continue
+		}
+		open = append(open, loc)
+		if !sim.closedAhead(loc, direction) {
+			clear = append(clear, loc)
+		}
+	}
+	if len(clear) > 0 {
+		return clear
+	}
+	if len(open) > 0 {
+		return open
+	}
+	return lanes
+}
+
+// tripMerged counts a lane change forced by a closure
+func (sim *GeneralLaneSimulation) tripMerged(car *SmartCar) {
+	car.smartCarLock.Lock()
+	defer car.smartCarLock.Unlock()
+	car.trip.ClosureMerges++
+}
+
+// tripClosureBlocked counts a move a closure stopped, on top of the blocked moves
+func (sim *GeneralLaneSimulation) tripClosureBlocked(car *SmartCar) {
+	sim.tripBlocked(car)
+	car.smartCarLock.Lock()
+	defer car.smartCarLock.Unlock()
+	car.trip.ClosureBlocks++
+}
+
+// closureMetrics is safe to call once the simulation stopped
+func (sim *GeneralLaneSimulation) closureMetrics() ClosureMetrics {
+	metrics := ClosureMetrics{}
+	var affected, unaffected []float64
+	for _, record := range sim.tripRecords() {
+		trip := record.Trip
+		metrics.Merges += trip.ClosureMerges
+		metrics.BlockedMoves += trip.ClosureBlocks
+		if !trip.Exited || trip.Removed {
+			continue
+		}
+		if trip.ClosureMerges > 0 || trip.ClosureBlocks > 0 {
+			affected = append(affected, trip.delay())
+		} else {
+			unaffected = append(unaffected, trip.delay())
+		}
+	}
+	metrics.AffectedDelay = summarize(affected)
+	metrics.UnaffectedDelay = summarize(unaffected)
+	return metrics
+}
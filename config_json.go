@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// jsonGeneralLaneConfig mirrors GeneralLaneSimulationConfig with the same keys the websocket client uses
+type jsonGeneralLaneConfig struct {
+	SizeOfLane         int `json:"sizeOfLane"`
+	NumVerticalLanes   int `json:"numVerticalLanes"`
+	NumHorizontalLanes int `json:"numHorizontalLanes"`
+
+	InAlpha      float64 `json:"inAlpha"`
+	OutBeta      float64 `json:"outBeta"`
+	CarMovementP float64 `json:"carMovementP"`
+
+	NumHorizontalCars int `json:"numHorizontalCars"`
+	NumVerticalCars   int `json:"numVerticalCars"`
+
+	InLaneChoice  int `json:"inLaneChoice"`
+	OutLaneChoice int `json:"outLaneChoice"`
+
+	ProbSwitchingLanes float64 `json:"probSwitchingLanes"`
+	LaneSwitchChoice   int     `json:"laneSwitchChoice"`
+
+	AccidentProb float64 `json:"accidentProb"`
+
+	CarRemovalRate float64 `json:"carRemovalRate"`
+	CarRestartProb float64 `json:"carRestartProb"`
+
+	CarClock                float64 `json:"carClock"`
+	CarSpeedUniformEndRange float64 `json:"carSpeedUniformEndRange"`
+	CarDistributionType     int     `json:"CarDistributionType"`
+	ReSampleSpeedEveryClk   bool    `json:"reSampleSpeedEveryClk"`
+
+	ProbPolicePullOverProb float64 `json:"probPolicePullOverProb"`
+	SpeedBasedPullOver     bool    `json:"speedBasedPullOver"`
+
+	ParkingEnabled  bool    `json:"parkingEnabled"`
+	DistractionRate float64 `json:"distractionRate"`
+	ParkingTimeRate float64 `json:"parkingTimeRate"`
+	CrossWalkCutoff int     `json:"crossWalkCutoff"`
+
+	CrossWalkEnabled      bool    `json:"crossWalkEnabled"`
+	CrossWalkSlowDownRate float64 `json:"crossWalkSlowDownRate"`
+
+	PedestrianDeathAccidentProb float64 `json:"pedestrianDeathAccidentProb"`
+
+	ProbEnteringIntersection float64 `json:"probEnteringIntersection"`
+	IntersectionAccidentProb float64 `json:"intersectionAccidentProb"`
+	AccidentScaling          bool    `json:"accidentScaling"`
+	SlowDownSpeed            float64 `json:"slowDownSpeed"`
+	RemoveUnlikelyEvents     bool    `json:"removeUnlikelyEvents"`
+	UnlikelyCutoff           float64 `json:"unlikelyCutoff"`
+
+	Seed           int64   `json:"seed"`
+	WallClockSpeed float64 `json:"wallClockSpeed"`
+	TraceFile      string  `json:"traceFile,omitempty"`
+
+	Detectors      []LocationRef `json:"detectors,omitempty"`
+	DetectorWindow float64       `json:"detectorWindow"`
+
+	HorizontalStreets []int `json:"horizontalStreets,omitempty"`
+	VerticalStreets   []int `json:"verticalStreets,omitempty"`
+	BlockLength       int   `json:"blockLength"`
+
+	NumWestboundLanes  int   `json:"numWestboundLanes"`
+	NumNorthboundLanes int   `json:"numNorthboundLanes"`
+	WestboundStreets   []int `json:"westboundStreets,omitempty"`
+	NorthboundStreets  []int `json:"northboundStreets,omitempty"`
+
+	HorizontalTurnRatios []float64 `json:"horizontalTurnRatios,omitempty"`
+	VerticalTurnRatios   []float64 `json:"verticalTurnRatios,omitempty"`
+	WestboundTurnRatios  []float64 `json:"westboundTurnRatios,omitempty"`
+	NorthboundTurnRatios []float64 `json:"northboundTurnRatios,omitempty"`
+
+	NetworkFile   string  `json:"networkFile,omitempty"`
+	OsmCellLength float64 `json:"osmCellLength"`
+
+	RingRoad     bool    `json:"ringRoad"`
+	RingDuration float64 `json:"ringDuration"`
+	WarmUp       float64 `json:"warmUp"`
+
+	OpenSystem     bool          `json:"openSystem"`
+	ArrivalProfile []ArrivalStep `json:"arrivalProfile,omitempty"`
+	Horizon        float64       `json:"horizon"`
+	MaxExits       int           `json:"maxExits"`
+
+	DemandProfiles []DemandProfile `json:"demandProfiles,omitempty"`
+
+	OdMatrix       map[string]map[string]float64 `json:"odMatrix,omitempty"`
+	DynamicRouting bool                          `json:"dynamicRouting"`
+	CongestionCost float64                       `json:"congestionCost"`
+
+	Closures      []Closure `json:"closures,omitempty"`
+	MergeDistance int       `json:"mergeDistance"`
+
+	SignalControl string       `json:"signalControl,omitempty"`
+	SignalPlans   []SignalPlan `json:"signalPlans,omitempty"`
+
+	IntersectionControl string `json:"intersectionControl,omitempty"`
+	MajorRoad           string `json:"majorRoad,omitempty"`
+	YieldGap            int    `json:"yieldGap"`
+
+	EmergencyVehicles []EmergencyDispatch `json:"emergencyVehicles,omitempty"`
+	EmergencySpeed    float64             `json:"emergencySpeed"`
+	PreemptDistance   int                 `json:"preemptDistance"`
+
+	Responders        int      `json:"responders"`
+	ResponderStations [][2]int `json:"responderStations,omitempty"`
+	ResponderSpeed    float64  `json:"responderSpeed"`
+}
+
+// laneChoiceToInt is the inverse of convertIntLaneChoice
+func laneChoiceToInt(choice LaneChoice) int {
+	if choice == trafficBasedChoice {
+		return 1
+	}
+	return 0
+}
+
+func (config *GeneralLaneSimulationConfig) toJson() jsonGeneralLaneConfig {
+	return jsonGeneralLaneConfig{
+		SizeOfLane:                  config.sizeOfLane,
+		NumVerticalLanes:            config.numVerticalLanes,
+		NumHorizontalLanes:          config.numHorizontalLanes,
+		InAlpha:                     config.inAlpha,
+		OutBeta:                     config.outBeta,
+		CarMovementP:                config.carMovementP,
+		NumHorizontalCars:           config.numHorizontalCars,
+		NumVerticalCars:             config.numVerticalCars,
+		InLaneChoice:                laneChoiceToInt(config.inLaneChoice),
+		OutLaneChoice:               laneChoiceToInt(config.outLaneChoice),
+		ProbSwitchingLanes:          config.probSwitchingLanes,
+		LaneSwitchChoice:            laneChoiceToInt(config.laneSwitchChoice),
+		AccidentProb:                config.accidentProb,
+		CarRemovalRate:              config.carRemovalRate,
+		CarRestartProb:              config.carRestartProb,
+		CarClock:                    config.carClock,
+		CarSpeedUniformEndRange:     config.carSpeedUniformEndRange,
+		CarDistributionType:         int(config.CarDistributionType),
+		ReSampleSpeedEveryClk:       config.reSampleSpeedEveryClk,
+		ProbPolicePullOverProb:      config.probPolicePullOverProb,
+		SpeedBasedPullOver:          config.speedBasedPullOver,
+		ParkingEnabled:              config.parkingEnabled,
+		DistractionRate:             config.distractionRate,
+		ParkingTimeRate:             config.parkingTimeRate,
+		CrossWalkCutoff:             config.crossWalkCutoff,
+		CrossWalkEnabled:            config.crossWalkEnabled,
+		CrossWalkSlowDownRate:       config.crossWalkSlowDownRate,
+		PedestrianDeathAccidentProb: config.pedestrianDeathAccidentProb,
+		ProbEnteringIntersection:    config.probEnteringIntersection,
+		IntersectionAccidentProb:    config.intersectionAccidentProb,
+		AccidentScaling:             config.accidentScaling,
+		SlowDownSpeed:               config.slowDownSpeed,
+		RemoveUnlikelyEvents:        config.removeUnlikelyEvents,
+		UnlikelyCutoff:              config.unlikelyCutoff,
+		Seed:                        config.seed,
+		WallClockSpeed:              config.wallClockSpeed,
+		TraceFile:                   config.traceFile,
+		Detectors:                   config.detectors,
+		DetectorWindow:              config.detectorWindow,
+		HorizontalStreets:           config.horizontalStreets,
+		VerticalStreets:             config.verticalStreets,
+		BlockLength:                 config.blockLength,
+		NumWestboundLanes:           config.numWestboundLanes,
+		NumNorthboundLanes:          config.numNorthboundLanes,
+		WestboundStreets:            config.westboundStreets,
+		NorthboundStreets:           config.northboundStreets,
+		HorizontalTurnRatios:        config.horizontalTurnRatios,
+		VerticalTurnRatios:          config.verticalTurnRatios,
+		WestboundTurnRatios:         config.westboundTurnRatios,
+		NorthboundTurnRatios:        config.northboundTurnRatios,
+		NetworkFile:                 config.networkFile,
+		OsmCellLength:               config.osmCellLength,
+		RingRoad:                    config.ringRoad,
+		RingDuration:                config.ringDuration,
+		WarmUp:                      config.warmUp,
+		OpenSystem:                  config.openSystem,
+		ArrivalProfile:              config.arrivalProfile,
+		DemandProfiles:              config.demandProfiles,
+		OdMatrix:                    config.odMatrix,
+		DynamicRouting:              config.dynamicRouting,
+		CongestionCost:              config.congestionCost,
+		Closures:                    config.closures,
+		MergeDistance:               config.mergeDistance,
+		SignalControl:               config.signalControl,
+		SignalPlans:                 config.signalPlans,
+		IntersectionControl:         config.intersectionControl,
+		MajorRoad:                   config.majorRoad,
+		YieldGap:                    config.yieldGap,
+		EmergencyVehicles:           config.emergencyVehicles,
+		EmergencySpeed:              config.emergencySpeed,
+		PreemptDistance:             config.preemptDistance,
+		Responders:                  config.responders,
+		ResponderStations:           config.responderStations,
+		ResponderSpeed:              config.responderSpeed,
+		Horizon:                     config.horizon,
+		MaxExits:                    config.maxExits,
+	}
+}
+
+func (config *GeneralLaneSimulationConfig) fromJson(jsonConfig jsonGeneralLaneConfig) {
+	config.sizeOfLane = jsonConfig.SizeOfLane
+	config.numVerticalLanes = jsonConfig.NumVerticalLanes
+	config.numHorizontalLanes = jsonConfig.NumHorizontalLanes
+	config.inAlpha = jsonConfig.InAlpha
+	config.outBeta = jsonConfig.OutBeta
+	config.carMovementP = jsonConfig.CarMovementP
+	config.numHorizontalCars = jsonConfig.NumHorizontalCars
+	config.numVerticalCars = jsonConfig.NumVerticalCars
+	config.inLaneChoice = convertIntLaneChoice(jsonConfig.InLaneChoice)
+	config.outLaneChoice = convertIntLaneChoice(jsonConfig.OutLaneChoice)
+	config.probSwitchingLanes = jsonConfig.ProbSwitchingLanes
+	config.laneSwitchChoice = convertIntLaneChoice(jsonConfig.LaneSwitchChoice)
+	config.accidentProb = jsonConfig.AccidentProb
+	config.carRemovalRate = jsonConfig.CarRemovalRate
+	config.carRestartProb = jsonConfig.CarRestartProb
+	config.carClock = jsonConfig.CarClock
+	config.carSpeedUniformEndRange = jsonConfig.CarSpeedUniformEndRange
+	config.CarDistributionType = convertToCarDistributionType(jsonConfig.CarDistributionType)
+	config.reSampleSpeedEveryClk = jsonConfig.ReSampleSpeedEveryClk
+	config.probPolicePullOverProb = jsonConfig.ProbPolicePullOverProb
+	config.speedBasedPullOver = jsonConfig.SpeedBasedPullOver
+	config.parkingEnabled = jsonConfig.ParkingEnabled
+	config.distractionRate = jsonConfig.DistractionRate
+	config.parkingTimeRate = jsonConfig.ParkingTimeRate
+	config.crossWalkCutoff = jsonConfig.CrossWalkCutoff
+	config.crossWalkEnabled = jsonConfig.CrossWalkEnabled
+	config.crossWalkSlowDownRate = jsonConfig.CrossWalkSlowDownRate
+	config.pedestrianDeathAccidentProb = jsonConfig.PedestrianDeathAccidentProb
+	config.probEnteringIntersection = jsonConfig.ProbEnteringIntersection
+	config.intersectionAccidentProb = jsonConfig.IntersectionAccidentProb
+	config.accidentScaling = jsonConfig.AccidentScaling
+	config.slowDownSpeed = jsonConfig.SlowDownSpeed
+	config.removeUnlikelyEvents = jsonConfig.RemoveUnlikelyEvents
+	config.unlikelyCutoff = jsonConfig.UnlikelyCutoff
+	config.seed = jsonConfig.Seed
+	config.wallClockSpeed = jsonConfig.WallClockSpeed
+	config.traceFile = jsonConfig.TraceFile
+	config.detectors = jsonConfig.Detectors
+	config.detectorWindow = jsonConfig.DetectorWindow
+	config.horizontalStreets = jsonConfig.HorizontalStreets
+	config.verticalStreets = jsonConfig.VerticalStreets
+	config.blockLength = jsonConfig.BlockLength
+	config.numWestboundLanes = jsonConfig.NumWestboundLanes
+	config.numNorthboundLanes = jsonConfig.NumNorthboundLanes
+	config.westboundStreets = jsonConfig.WestboundStreets
+	config.northboundStreets = jsonConfig.NorthboundStreets
+	config.horizontalTurnRatios = jsonConfig.HorizontalTurnRatios
+	config.verticalTurnRatios = jsonConfig.VerticalTurnRatios
+	config.westboundTurnRatios = jsonConfig.WestboundTurnRatios
+	config.northboundTurnRatios = jsonConfig.NorthboundTurnRatios
+	config.networkFile = jsonConfig.NetworkFile
+	config.osmCellLength = jsonConfig.OsmCellLength
+	config.ringRoad = jsonConfig.RingRoad
+	config.ringDuration = jsonConfig.RingDuration
+	config.warmUp = jsonConfig.WarmUp
+	config.openSystem = jsonConfig.OpenSystem
+	config.arrivalProfile = jsonConfig.ArrivalProfile
+	config.demandProfiles = jsonConfig.DemandProfiles
+	config.odMatrix = jsonConfig.OdMatrix
+	config.dynamicRouting = jsonConfig.DynamicRouting
+	config.congestionCost = jsonConfig.CongestionCost
+	config.closures = jsonConfig.Closures
+	config.mergeDistance = jsonConfig.MergeDistance
+	config.signalControl = jsonConfig.SignalControl
+	config.signalPlans = jsonConfig.SignalPlans
+	config.intersectionControl = jsonConfig.IntersectionControl
+	config.majorRoad = jsonConfig.MajorRoad
+	config.yieldGap = jsonConfig.YieldGap
+	config.emergencyVehicles = jsonConfig.EmergencyVehicles
+	config.emergencySpeed = jsonConfig.EmergencySpeed
+	config.preemptDistance = jsonConfig.PreemptDistance
+	config.responders = jsonConfig.Responders
+	config.responderStations = jsonConfig.ResponderStations
+	config.responderSpeed = jsonConfig.ResponderSpeed
+	config.horizon = jsonConfig.Horizon
+	config.maxExits = jsonConfig.MaxExits
+}
+
+// MarshalJSON writes every setting of the config
+func (config *GeneralLaneSimulationConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(config.toJson())
+}
+
+// UnmarshalJSON only overwrites the settings present in data, the rest keep their current values
+func (config *GeneralLaneSimulationConfig) UnmarshalJSON(data []byte) error {
+	jsonConfig := config.toJson()
+	if err := json.Unmarshal(data, &jsonConfig); err != nil {
+		return err
+	}
+	config.fromJson(jsonConfig)
+	return nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	constantDemand = "constant" // the rate of a point holds until the next one
+	linearDemand   = "linear"   // the rate goes in a straight line from one point to the next
+)
+
+// DemandProfile is how the arrival rate at an entry changes over a run, like the peaks of a rush hour.
+// The rate is 0 before the first point and stays at the last point's rate after it
+type DemandProfile struct {
+	Entry  string        `json:"entry,omitempty"` // street name like horizontal1, every other entry when empty
+	Shape  string        `json:"shape,omitempty"` // constant or linear, constant when empty
+	Points []ArrivalStep `json:"points,omitempty"`
+	File   string        `json:"file,omitempty"` // csv of time,rate rows read instead of points
+}
+
+// demandCurve is a loaded demand profile
+type demandCurve struct {
+	linear bool
+	points []ArrivalStep
+}
+
+func (profile DemandProfile) name() string {
+	if profile.Entry == "" {
+		return "for every entry"
+	}
+	return "of " + profile.Entry
+}
+
+// curve checks the profile and reads its points
+func (profile DemandProfile) curve() (*demandCurve, error) {
+	curve := &demandCurve{points: profile.Points}
+	switch profile.Shape {
+	case "", constantDemand:
+	case linearDemand:
+		curve.linear = true
+	default:
+		return nil, fmt.Errorf("unknown shape %q, use %s or %s", profile.Shape, constantDemand, linearDemand)
+	}
+	if profile.File != "" {
+		if len(profile.Points) > 0 {
+			return nil, errors.New("give either points or a file, not both")
+		}
+		points, err := readDemandFile(profile.File)
+		if err != nil {
+			return nil, err
+		}
+		curve.points = points
+	}
+	if err := validateDemandPoints(curve.points); err != nil {
+		return nil, err
+	}
+	return curve, nil
+}
+
+func validateDemandPoints(points []ArrivalStep) error {
+	if len(points) == 0 {
+		return errors.New("a demand profile needs at least one point")
+	}
+	for i, point := range points {
+		if point.Rate < 0 {
+			return fmt.Errorf("arrival rate %g at time %g is negative", point.Rate, point.Time)
+		}
+		if i > 0 && point.Time <= points[i-1].Time {
+			return errors.New("the points have to be in order of time")
+		}
+	}
+	return nil
+}
+
+// readDemandFile reads time,rate rows, a first row that is not numbers is taken as the header
+func readDemandFile(path string) ([]ArrivalStep, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening the demand file")
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	points := make([]ArrivalStep, 0)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", path)
+		}
+		time, timeErr := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
+		rate, rateErr := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if timeErr != nil || rateErr != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("%s line %d is not a time and a rate", path, line)
+		}
+		points = append(points, ArrivalStep{Time: time, Rate: rate})
+	}
+	return points, nil
+}
+
+// rate is the arrival rate at time t
+func (curve *demandCurve) rate(t float64) float64 {
+	points := curve.points
+	next := sort.Search(len(points), func(i int) bool { return points[i].Time > t })
+	if next == 0 {
+		return 0
+	}
+	if !curve.linear || next == len(points) {
+		return points[next-1].Rate
+	}
+	from, to := points[next-1], points[next]
+	return from.Rate + (to.Rate-from.Rate)*(t-from.Time)/(to.Time-from.Time)
+}
+
+// maxRate bounds the rate from time t on
+func (curve *demandCurve) maxRate(t float64) float64 {
+	max := curve.rate(t)
+	for _, point := range curve.points {
+		if point.Time > t && point.Rate > max {
+			max = point.Rate
+		}
+	}
+	return max
+}
+
+// loadDemand gives every entry its demand profile. An entry takes the profile naming it, else the one without an
+// entry, else the stepped arrivalProfile, else it keeps arriving at inAlpha
+func (sim *GeneralLaneSimulation) loadDemand() error {
+	config := sim.config
+	sim.demand = map[*Street]*demandCurve{}
+	byEntry := map[string]*demandCurve{}
+	if len(config.arrivalProfile) > 0 {
+		if err := validateDemandPoints(config.arrivalProfile); err != nil {
+			return errors.Wrap(err, "arrival profile")
+		}
+		byEntry[""] = &demandCurve{points: config.arrivalProfile}
+	}
+	seen := map[string]bool{}
+	for _, profile := range config.demandProfiles {
+		if seen[profile.Entry] {
+			return fmt.Errorf("there are two demand profiles %s", profile.name())
+		}
+		seen[profile.Entry] = true
+		curve, err := profile.curve()
+		if err != nil {
+			return errors.Wrapf(err, "demand profile %s", profile.name())
+		}
+		byEntry[profile.Entry] = curve
+	}
+
+	for _, street := range sim.streets {
+		if !street.entry {
+			continue
+		}
+		curve, ok := byEntry[street.name()]
+		if !ok {
+			curve = byEntry[""]
+		}
+		delete(seen, street.name())
+		if curve == nil {
+			continue
+		}
+		if !config.openSystem && !config.ringRoad && curve.points[len(curve.points)-1].Rate == 0 {
+			return fmt.Errorf("the demand of %s ends at rate 0, so the cars left in its pool never get in", street.name())
+		}
+		sim.demand[street] = curve
+	}
+	delete(seen, "")
+	for entry := range seen {
+		return fmt.Errorf("there is no entry street %s for its demand profile", entry)
+	}
+	return nil
+}
+
+// scheduleEntry schedules the next car to reach the street's entry. Entries following a demand profile draw their
+// arrivals as a non-homogeneous Poisson process, by thinning one at the highest rate still to come
+func (sim *GeneralLaneSimulation) scheduleEntry(street *Street) {
+	curve := sim.demand[street]
+	if curve == nil {
+		sim.moveCarsThroughBinsDirection(carInEvent, street, sim.config.inAlpha)
+		return
+	}
+	now := sim.scheduler.Now()
+	end := sim.endTime()
+	for t := now; ; {
+		bound := curve.maxRate(t)
+		if bound <= 0 {
+			return
+		}
+		// thinning needs the plain exponential, dropping unlikely gaps would bend the curve
+		t += sim.random.arrivals.ExpFloat64() / bound
+		if t > end {
+			return
+		}
+		if sim.random.arrivals.Float64()*bound <= curve.rate(t) {
+			sim.scheduler.schedule(t-now, &SimEvent{Type: carInEvent, street: street})
+			return
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DetectorWindow holds what a loop detector measured over one aggregation window
+type DetectorWindow struct {
+	Start          float64 `json:"start"`
+	End            float64 `json:"end"`
+	Count          int     `json:"count"`          // cars that entered the location
+	Flow           float64 `json:"flow"`           // cars per unit of time
+	Occupancy      float64 `json:"occupancy"`      // fraction of the window with at least one car on the location
+	Density        float64 `json:"density"`        // time averaged number of cars on the location
+	SpaceMeanSpeed float64 `json:"spaceMeanSpeed"` // locations per unit of time, over the cars that left during the window
+}
+
+// LoopDetector is a virtual detector watching cars come and go on one location
+type LoopDetector struct {
+	Location LocationRef
+	window   float64 // 0 keeps the whole run in one window
+	clock    *Scheduler
+
+	numCars    int
+	lastUpdate float64
+	arrivals   map[string]float64 // when each car on the location got there
+
+	current DetectorWindow
+	dwell   float64 // total time spent on the location by the cars that left in the current window
+	numLeft int
+	Windows []DetectorWindow
+}
+
+func newLoopDetector(loc *StatefulLocation, ref LocationRef, window float64, clock *Scheduler) *LoopDetector {
+	now := clock.Now()
+	detector := &LoopDetector{Location: ref, window: window, clock: clock, lastUpdate: now, arrivals: map[string]float64{}}
+	detector.current.Start = now
+	for _, car := range loc.sortedCars() {
+		detector.numCars++
+		detector.arrivals[car.ID] = now
+	}
+	return detector
+}
+
+// integrate accounts for the time since the last update, closing every window that ended on the way
+func (detector *LoopDetector) integrate(now float64) {
+	for detector.window > 0 && now >= detector.current.Start+detector.window {
+		end := detector.current.Start + detector.window
+		detector.accumulate(end)
+		detector.closeWindow(end)
+	}
+	detector.accumulate(now)
+}
+
+func (detector *LoopDetector) accumulate(until float64) {
+	elapsed := until - detector.lastUpdate
+	if elapsed <= 0 {
+		return
+	}
+	detector.current.Density += elapsed * float64(detector.numCars)
+	if detector.numCars > 0 {
+		detector.current.Occupancy += elapsed
+	}
+	detector.lastUpdate = until
+}
+
+// closeWindow turns the accumulated totals into rates and starts the next window at end
+func (detector *LoopDetector) closeWindow(end float64) {
+	window := detector.current
+	window.End = end
+	if length := end - window.Start; length > 0 {
+		window.Flow = float64(window.Count) / length
+		window.Occupancy /= length
+		window.Density /= length
+	}
+	if detector.dwell > 0 {
+		window.SpaceMeanSpeed = float64(detector.numLeft) / detector.dwell
+	}
+	detector.Windows = append(detector.Windows, window)
+
+	detector.current = DetectorWindow{Start: end}
+	detector.dwell = 0
+	detector.numLeft = 0
+}
+
+func (detector *LoopDetector) carArrived(car *SmartCar) {
+	now := detector.clock.Now()
+	detector.integrate(now)
+	detector.numCars++
+	detector.current.Count++
+	detector.arrivals[car.ID] = now
+}
+
+func (detector *LoopDetector) carLeft(car *SmartCar) {
+	now := detector.clock.Now()
+	detector.integrate(now)
+	detector.numCars--
+	if arrival, ok := detector.arrivals[car.ID]; ok {
+		detector.dwell += now - arrival
+		detector.numLeft++
+		delete(detector.arrivals, car.ID)
+	}
+}
+
+// finish closes the last, possibly shorter, window
+func (detector *LoopDetector) finish() {
+	now := detector.clock.Now()
+	detector.integrate(now)
+	if now > detector.current.Start {
+		detector.closeWindow(now)
+	}
+}
+
+// installDetectors places the detectors from the config. Measurements start at the current simulated time
+func (sim *GeneralLaneSimulation) installDetectors() {
+	sim.detectors = nil
+	for _, ref := range sim.config.detectors {
+		loc, err := sim.resolveLocationRef(ref)
+		if err != nil || ref.Root != "" {
+			fmt.Println("skipping detector at", ref, err)
+			continue
+		}
+		loc.detector = newLoopDetector(loc, ref, sim.config.detectorWindow, sim.scheduler)
+		sim.detectors = append(sim.detectors, loc.detector)
+	}
+}
+
+func (sim *GeneralLaneSimulation) finishDetectors() {
+	for _, detector := range sim.detectors {
+		detector.finish()
+	}
+}
+
+// parseDetectorLocations reads locations written as "x,y;x,y"
+func parseDetectorLocations(s string) ([]LocationRef, error) {
+	refs := make([]LocationRef, 0)
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		coordinates := strings.Split(item, ",")
+		if len(coordinates) != 2 {
+			return nil, fmt.Errorf("detector location %q is not x,y", item)
+		}
+		x, err := strconv.Atoi(strings.TrimSpace(coordinates[0]))
+		if err != nil {
+			return nil, err
+		}
+		y, err := strconv.Atoi(strings.TrimSpace(coordinates[1]))
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, LocationRef{X: x, Y: y})
+	}
+	return refs, nil
+}
+
+// DetectorReport has every window of one detector
+type DetectorReport struct {
+	Location LocationRef      `json:"location"`
+	Windows  []DetectorWindow `json:"windows"`
+}
+
+// detectorReports is safe to call once the simulation stopped
+func (sim *GeneralLaneSimulation) detectorReports() []DetectorReport {
+	reports := make([]DetectorReport, 0, len(sim.detectors))
+	for _, detector := range sim.detectors {
+		reports = append(reports, DetectorReport{Location: detector.Location, Windows: detector.Windows})
+	}
+	return reports
+}
+
+func (report DetectorReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "detector %s\n", report.Location)
+	for _, window := range report.Windows {
+		speed := "-"
+		if window.SpaceMeanSpeed > 0 {
+			speed = fmt.Sprintf("%.3f", window.SpaceMeanSpeed)
+		}
+		fmt.Fprintf(&b, "  %.2f-%.2f flow %.3f occupancy %.3f density %.3f speed %s\n",
+			window.Start, window.End, window.Flow, window.Occupancy, window.Density, speed)
+	}
+	return b.String()
+}
+
+// tasepFlow is the steady state flow of a totally asymmetric exclusion process hopping at rate at the given density
+func tasepFlow(rate float64, density float64) float64 {
+	density = math.Min(math.Max(density, 0), 1)
+	return rate * density * (1 - density)
+}
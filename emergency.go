@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	emergencyRetryDelay   = 1.0 // seconds before an emergency vehicle tries again to get onto an entry with no free lane
+	emergencyWarningCells = 3   // cells ahead of an emergency vehicle whose cars move aside for it
+	emergencyCarPrefix    = "ecar"
+)
+
+// EmergencyDispatch sends emergency vehicles in through an entry street, the first at At and Count-1 more Every
+// seconds apart
+type EmergencyDispatch struct {
+	At    float64 `json:"at"`
+	Entry string  `json:"entry,omitempty"` // the name of the entry street, like horizontal or vertical1, the first one when empty
+	Every float64 `json:"every,omitempty"`
+	Count int     `json:"count,omitempty"` // one when zero
+}
+
+// emergencyDispatch is one emergency vehicle of the schedule
+type emergencyDispatch struct {
+	at     float64
+	street *Street
+}
+
+// EmergencyMetrics is how fast the emergency vehicles got through the grid
+type EmergencyMetrics struct {
+	Dispatched    int     `json:"dispatched"` // got onto the grid
+	Finished      int     `json:"finished"`   // drove out of it
+	Removed       int     `json:"removed"`    // taken off it after an accident
+	ResponseTime  Summary `json:"responseTime"`
+	TravelTime    Summary `json:"travelTime"`
+	AccidentDelay Summary `json:"accidentDelay"` // time stuck behind accident cells
+	Preemptions   int     `json:"preemptions"`   // times a signal turned green for one
+}
+
+// loadEmergencies lists the emergency vehicles of the schedule with the street each comes in through
+func (sim *GeneralLaneSimulation) loadEmergencies() error {
+	config := sim.config
+	sim.dispatches = nil
+	if len(config.emergencyVehicles) == 0 {
+		return nil
+	}
+	if config.emergencySpeed <= 0 {
+		return errors.New("the emergency speed has to be positive")
+	}
+	if config.preemptDistance < 0 {
+		return errors.New("the preempt distance cannot be negative")
+	}
+	for i, dispatch := range config.emergencyVehicles {
+		if dispatch.At < 0 || dispatch.Every < 0 || dispatch.Count < 0 {
+			return fmt.Errorf("emergency dispatch %d: times and count cannot be negative", i)
+		}
+		count := dispatch.Count
+		if count == 0 {
+			count = 1
+		}
+		if count > 1 && dispatch.Every == 0 {
+			return fmt.Errorf("emergency dispatch %d: several vehicles need a time between them", i)
+		}
+		var street *Street
+		for _, candidate := range sim.streets {
+			if candidate.entry && (dispatch.Entry == "" || candidate.name() == dispatch.Entry) {
+				street = candidate
+				break
+			}
+		}
+		if street == nil {
+			return fmt.Errorf("emergency dispatch %d: there is no entry street %q", i, dispatch.Entry)
+		}
+		for j := 0; j < count; j++ {
+			sim.dispatches = append(sim.dispatches, emergencyDispatch{at: dispatch.At + float64(j)*dispatch.Every, street: street})
+		}
+	}
+	return nil
+}
+
+// scheduleEmergencies schedules every emergency vehicle of the schedule
+func (sim *GeneralLaneSimulation) scheduleEmergencies() {
+	for i, dispatch := range sim.dispatches {
+		sim.scheduler.schedule(dispatch.at-sim.scheduler.Now(), &SimEvent{Type: emergencyEvent, dispatch: i})
+	}
+}
+
+// dispatchEmergency puts emergency vehicle i on the first cell of its entry street, ahead of the cars waiting there.
+// It tries again a moment later when every lane of the entry is taken
+func (sim *GeneralLaneSimulation) dispatchEmergency(i int) {
+	dispatch := sim.dispatches[i]
+	street := dispatch.street
+	openLanes := sim.streetLanesAtIndex(street, street.First, Open)
+	if len(openLanes) == 0 {
+		for _, loc := range sim.streetLanesAtIndex(street, street.First, AllLocationTypes) {
+			sim.clearWay(loc)
+		}
+		sim.scheduler.schedule(emergencyRetryDelay, &SimEvent{Type: emergencyEvent, dispatch: i})
+		return
+	}
+	loc := sim.RandomlyPickLocation(openLanes, street.Direction, sim.config.inLaneChoice)
+
+	car := newSmartCar(fmt.Sprintf("%s %d", emergencyCarPrefix, i), street.Direction, sim.config.emergencySpeed, 1)
+	car.Emergency = true
+	car.trip.ArrivalTime = dispatch.at
+	sim.assignDestination(car, street)
+	street.InRoot.addCar(car)
+	sim.traceCar(traceCarArrived, car, nil, street.InRoot, "")
+	street.InRoot.removeCar(car)
+	loc.addCar(car)
+	sim.emergencyPlaced++
+	sim.enterTrip(car, street)
+	sim.traceCar(traceCarPlaced, car, street.InRoot, loc, "")
+	sim.emergencyMoved(car, loc)
+	sim.MoveSmartCarInLane(car, loc)
+	sim.notifyDraw()
+}
+
+// emergencyMoved preempts the signals ahead of the emergency vehicle now at loc and warns the cars ahead of it
+func (sim *GeneralLaneSimulation) emergencyMoved(car *SmartCar, loc *StatefulLocation) {
+	sim.preemptSignals(car, loc)
+	dx, dy := car.Direction.step()
+	for k := 1; k <= emergencyWarningCells; k++ {
+		x, y := loc.X+k*dx, loc.Y+k*dy
+		if !sim.inGrid(x, y) {
+			return
+		}
+		for _, other := range sim.Locations[x][y].sortedCars() {
+			if !other.Emergency {
+				other.movingAside = true
+			}
+		}
+	}
+}
+
+// clearWay asks the cars at loc, in the way of an emergency vehicle, to move aside right away
+func (sim *GeneralLaneSimulation) clearWay(loc *StatefulLocation) {
+	for _, other := range loc.sortedCars() {
+		if other.Emergency || other.stalledLoc != nil {
+			continue
+		}
+		other.movingAside = true
+		sim.scheduler.cancel(other.clock)
+		other.clock = nil
+		clock := &SimEvent{Type: carClockEvent, car: other, carLoc: loc}
+		if sim.scheduler.schedule(0, clock) {
+			other.clock = clock
+		}
+	}
+}
+
+// asideLanes leaves out of lanes the one the car moving aside is on, unless that is the only one
+func asideLanes(lanes []*StatefulLocation, currLoc *StatefulLocation, direction Direction) []*StatefulLocation {
+	aside := make([]*StatefulLocation, 0, len(lanes))
+	for _, loc := range lanes {
+		if (direction.isHorizontal() && loc.X != currLoc.X) || (!direction.isHorizontal() && loc.Y != currLoc.Y) {
+			aside = append(aside, loc)
+		}
+	}
+	if len(aside) == 0 {
+		return lanes
+	}
+	return aside
+}
+
+// emergencyExited lets go of the signals held for an emergency vehicle that left the grid
+func (sim *GeneralLaneSimulation) emergencyExited(car *SmartCar) {
+	sim.emergencyOut++
+	sim.preemptSignals(car, nil)
+}
+
+// countEmergencies counts again the emergency vehicles that got on and off the grid, for a restored simulation
+func (sim *GeneralLaneSimulation) countEmergencies() {
+	sim.emergencyPlaced, sim.emergencyOut = 0, 0
+	outRoots := map[*StatefulLocation]bool{}
+	for _, street := range sim.streets {
+		outRoots[street.OutRoot] = true
+	}
+	for _, loc := range sim.allLocations() {
+		for _, car := range loc.sortedCars() {
+			if !car.Emergency {
+				continue
+			}
+			sim.emergencyPlaced++
+			if outRoots[loc] {
+				sim.emergencyOut++
+			}
+		}
+	}
+}
+
+// servingPhase is the phase that lets cars going direction go
+func servingPhase(direction Direction) int {
+	if direction.isHorizontal() {
+		return 0
+	}
+	return 1
+}
+
+// preemptSignals turns green for the emergency vehicle at loc the signals it is within preemptDistance cells of,
+// or inside of, and lets go of the ones it left. A signal serves one emergency vehicle at a time
+func (sim *GeneralLaneSimulation) preemptSignals(car *SmartCar, loc *StatefulLocation) {
+	for _, signal := range sim.signals {
+		near := loc != nil && sim.nearSignal(signal, loc, car.Direction)
+		if near && signal.preemptedBy == "" {
+			sim.preemptSignal(signal, car)
+		} else if !near && signal.preemptedBy == car.ID {
+			signal.preemptedBy = ""
+		}
+	}
+}
+
+func (sim *GeneralLaneSimulation) nearSignal(signal *Signal, loc *StatefulLocation, direction Direction) bool {
+	if sim.signalAt[loc] == signal {
+		return true
+	}
+	for distance := 1; distance <= sim.config.preemptDistance; distance++ {
+		for _, cell := range sim.approachCells(signal.cells, direction, -distance) {
+			if cell == loc {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// preemptSignal gives the signal's next green to the emergency vehicle's phase. A green for the other phase ends
+// right away, without waiting for its min green, while the yellow and all red still run their time
+func (sim *GeneralLaneSimulation) preemptSignal(signal *Signal, car *SmartCar) {
+	phase := servingPhase(car.Direction)
+	signal.preemptedBy = car.ID
+	signal.preempt = phase
+	signal.preemptions++
+	switch signal.state {
+	case signalGreen:
+		if signal.phase != phase && signal.event != nil {
+			sim.scheduler.cancel(signal.event)
+			sim.scheduleSignalChange(signal.ID, 0)
+		}
+	case signalYellow:
+		signal.next = phase
+		signal.nextGreen = signal.plan.Extension
+	default:
+		signal.signalLock.Lock()
+		signal.phase = phase
+		signal.signalLock.Unlock()
+		signal.nextGreen = signal.plan.Extension
+	}
+}
+
+// emergencyMetrics is safe to call once the simulation stopped
+func (sim *GeneralLaneSimulation) emergencyMetrics() EmergencyMetrics {
+	metrics := EmergencyMetrics{}
+	for _, signal := range sim.signals {
+		metrics.Preemptions += signal.preemptions
+	}
+	var responses, travels, delays []float64
+	for _, record := range sim.tripRecords() {
+		trip := record.Trip
+		if !record.Emergency || !trip.Entered {
+			continue
+		}
+		metrics.Dispatched++
+		if !trip.Exited {
+			continue
+		}
+		delays = append(delays, trip.AccidentAhead.Total)
+		if trip.Removed {
+			metrics.Removed++
+			continue
+		}
+		metrics.Finished++
+		responses = append(responses, trip.ExitTime-trip.ArrivalTime)
+		travels = append(travels, trip.travelTime())
+	}
+	metrics.ResponseTime = summarize(responses)
+	metrics.TravelTime = summarize(travels)
+	metrics.AccidentDelay = summarize(delays)
+	return metrics
+}
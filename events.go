@@ -4,4 +4,7 @@ const (
 	identify            = "identify"         // Sends identification message on connection
 	simulationUpdate    = "simulationUpdate" // Sends identification message on connection
 	completedSimulation = "completedSimulation"        // Sends identification message on connection
+	snapshotSaved       = "snapshotSaved"              // Sends where a requested snapshot was written
+	tripSummary         = "tripSummary"                // Sends the per direction trip metrics once a simulation completes
+	detectorReport      = "detectorReport"             // Sends the loop detector measurements once a simulation completes
 )
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gonum.org/v1/gonum/stat"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// ExperimentJob is one replication of one point
+type ExperimentJob struct {
+	point       ExperimentPoint
+	replication int
+	index       int // where the run goes in the results
+}
+
+// runExperimentBatch runs every replication of every point on a pool of workers, each simulation on its own.
+// workers <= 0 uses GOMAXPROCS. done is called from the workers as runs finish, it may be nil.
+// The runs come back ordered by point then replication whatever order they finished in
+func runExperimentBatch(spec *ExperimentSpec, workers int, done func(ExperimentRun)) []ExperimentRun {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	points := spec.points()
+	runs := make([]ExperimentRun, len(points)*spec.Replications)
+
+	jobs := make(chan ExperimentJob)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				run := spec.runExperimentJob(job)
+				runs[job.index] = run
+				if done != nil {
+					done(run)
+				}
+			}
+		}()
+	}
+	for _, point := range points {
+		for replication := 0; replication < spec.Replications; replication++ {
+			jobs <- ExperimentJob{point: point, replication: replication, index: point.Index*spec.Replications + replication}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return runs
+}
+
+// runExperimentJob runs one job. A simulation that panics fails its own run, the pool goes on with the others
+func (spec *ExperimentSpec) runExperimentJob(job ExperimentJob) (run ExperimentRun) {
+	defer func() {
+		if r := recover(); r != nil {
+			run = ExperimentRun{Point: job.point, Replication: job.replication, Seed: spec.seed(job.point, job.replication)}
+			run.Err = fmt.Sprintf("panic: %v", r)
+		}
+	}()
+	return spec.runExperimentPoint(job.point, job.replication)
+}
+
+// MetricEstimate is the mean of a metric over the replications with its 95% confidence interval
+type MetricEstimate struct {
+	N         int     `json:"n"`
+	Mean      float64 `json:"mean"`
+	StdDev    float64 `json:"stdDev"`
+	HalfWidth float64 `json:"halfWidth"` // 0 with a single replication
+	Low       float64 `json:"low"`
+	High      float64 `json:"high"`
+}
+
+func estimate(values []float64) MetricEstimate {
+	n := len(values)
+	if n == 0 {
+		return MetricEstimate{}
+	}
+	result := MetricEstimate{N: n, Mean: stat.Mean(values, nil)}
+	if n > 1 {
+		result.StdDev = stat.StdDev(values, nil)
+		t := distuv.StudentsT{Mu: 0, Sigma: 1, Nu: float64(n - 1)}.Quantile(0.975)
+		result.HalfWidth = t * result.StdDev / math.Sqrt(float64(n))
+	}
+	result.Low = result.Mean - result.HalfWidth
+	result.High = result.Mean + result.HalfWidth
+	return result
+}
+
+// PointSummary aggregates the replications of one point, failed runs are left out
+type PointSummary struct {
+	Point   ExperimentPoint           `json:"point"`
+	Runs    int                       `json:"runs"`
+	Failed  int                       `json:"failed"`
+	Metrics map[string]MetricEstimate `json:"metrics"`
+}
+
+// summarizeExperiment groups the runs by point, they have to be ordered like runExperimentBatch returns them
+func summarizeExperiment(spec *ExperimentSpec, runs []ExperimentRun) []PointSummary {
+	summaries := make([]PointSummary, 0)
+	for start := 0; start < len(runs); start += spec.Replications {
+		summary := PointSummary{Point: runs[start].Point, Metrics: map[string]MetricEstimate{}}
+		values := map[string][]float64{}
+		for _, run := range runs[start : start+spec.Replications] {
+			summary.Runs++
+			if run.Err != "" {
+				summary.Failed++
+				continue
+			}
+			for _, metric := range spec.Metrics {
+				values[metric] = append(values[metric], run.Metrics[metric])
+			}
+		}
+		for _, metric := range spec.Metrics {
+			summary.Metrics[metric] = estimate(values[metric])
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// RunExperimentFile runs the experiment on workers workers and prints the mean and 95% confidence interval of every
+// metric at every point as csv. With printRuns every run is printed first. The result also goes to the outputs of the
+// experiment file and to outputs
+func RunExperimentFile(path string, workers int, replications int, printRuns bool, outputs []OutputSpec) error {
+	spec, err := loadExperimentSpec(path)
+	if err != nil {
+		return err
+	}
+	if replications > 0 {
+		spec.Replications = replications
+	}
+	for i, output := range spec.Outputs {
+		if !filepath.IsAbs(output.Path) {
+			spec.Outputs[i].Path = filepath.Join(spec.dir, output.Path) // like baseFile, relative to the experiment file
+		}
+	}
+	spec.Outputs = append(spec.Outputs, outputs...)
+	sinks := make([]ResultSink, 0, len(spec.Outputs))
+	for _, output := range spec.Outputs {
+		sink, err := newResultSink(output)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, sink)
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	numRuns := len(spec.points()) * spec.Replications
+	fmt.Fprintf(os.Stderr, "experiment %s: %d points, %d replications, %d workers\n",
+		spec.Name, len(spec.points()), spec.Replications, workers)
+
+	var progressLock sync.Mutex
+	finished := 0
+	startedAt := time.Now()
+	runs := runExperimentBatch(spec, workers, func(run ExperimentRun) {
+		progressLock.Lock()
+		defer progressLock.Unlock()
+		finished++
+		if run.Err != "" {
+			fmt.Fprintf(os.Stderr, "run %d/%d failed at %s: %s\n", finished, numRuns, run.Point, run.Err)
+		}
+	})
+	fmt.Fprintf(os.Stderr, "finished %d runs\n", finished)
+
+	summary := summarizeExperiment(spec, runs)
+	if printRuns {
+		if err := writeRunsCsv(os.Stdout, spec, runs); err != nil {
+			return err
+		}
+		fmt.Println()
+	}
+	if err := writeSummaryCsv(os.Stdout, spec, summary); err != nil {
+		return err
+	}
+
+	result := &ExperimentResult{Spec: spec, StartedAt: startedAt, Runs: runs, Summary: summary}
+	for i, sink := range sinks {
+		if err := sink.write(result); err != nil {
+			return errors.Wrapf(err, "writing %s output %s", spec.Outputs[i].Type, spec.Outputs[i].Path)
+		}
+		fmt.Fprintf(os.Stderr, "wrote %s output %s\n", spec.Outputs[i].Type, spec.Outputs[i].Path)
+	}
+	return nil
+}
+
+func sweepHeader(spec *ExperimentSpec) []string {
+	header := []string{"point"}
+	for _, sweep := range spec.Sweep {
+		header = append(header, sweep.Param)
+	}
+	return header
+}
+
+func pointRow(point ExperimentPoint) []string {
+	row := []string{fmt.Sprint(point.Index)}
+	for _, param := range point.Params {
+		row = append(row, fmt.Sprint(param.Value))
+	}
+	return row
+}
+
+func writeRunsCsv(output io.Writer, spec *ExperimentSpec, runs []ExperimentRun) error {
+	writer := csv.NewWriter(output)
+	header := append(sweepHeader(spec), "replication", "seed")
+	header = append(header, spec.Metrics...)
+	writer.Write(append(header, "error"))
+	for _, run := range runs {
+		row := append(pointRow(run.Point), fmt.Sprint(run.Replication), fmt.Sprint(run.Seed))
+		for _, metric := range spec.Metrics {
+			row = append(row, fmt.Sprintf("%g", run.Metrics[metric]))
+		}
+		writer.Write(append(row, run.Err))
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeSummaryCsv(output io.Writer, spec *ExperimentSpec, summaries []PointSummary) error {
+	writer := csv.NewWriter(output)
+	header := append(sweepHeader(spec), "runs", "failed")
+	for _, metric := range spec.Metrics {
+		header = append(header, metric+".mean", metric+".ci95")
+	}
+	writer.Write(header)
+	for _, summary := range summaries {
+		row := append(pointRow(summary.Point), fmt.Sprint(summary.Runs), fmt.Sprint(summary.Failed))
+		for _, metric := range spec.Metrics {
+			estimate := summary.Metrics[metric]
+			row = append(row, fmt.Sprintf("%g", estimate.Mean), fmt.Sprintf("%g", estimate.HalfWidth))
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+	return writer.Error()
+}
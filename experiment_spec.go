@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Seed policies of an experiment
+const (
+	fixedSeedPolicy      = "fixed"      // every run uses the base seed
+	sequentialSeedPolicy = "sequential" // replication i uses base+i at every point, so the points share random numbers
+	perRunSeedPolicy     = "perRun"     // every run gets its own seed, base+run number
+	randomSeedPolicy     = "random"     // seeds come from the clock, the seeds used are still reported
+)
+
+// ExperimentSpec is an experiment file. Config keys are the ones used by the websocket client and the snapshots
+type ExperimentSpec struct {
+	Name         string                 `json:"name"`
+	BaseFile     string                 `json:"baseFile"` // json config applied before base, relative to the experiment file
+	Base         map[string]interface{} `json:"base"`
+	Sweep        []SweepParameter       `json:"sweep"`
+	Replications int                    `json:"replications"`
+	Seed         SeedPolicy             `json:"seed"`
+	Metrics      []string               `json:"metrics"`
+	TimeSeries   bool                   `json:"timeSeries"` // keep the loop detector windows of every run
+	Outputs      []OutputSpec           `json:"outputs"`
+
+	dir string
+}
+
+// OutputSpec names a result sink, see newResultSink
+type OutputSpec struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+// SweepParameter is one config key to sweep, over either a list of values or a range
+type SweepParameter struct {
+	Param  string        `json:"param"`
+	Values []interface{} `json:"values"`
+	Range  *SweepRange   `json:"range"`
+}
+
+// SweepRange goes from start to stop, stop included, in steps of step
+type SweepRange struct {
+	Start float64 `json:"start"`
+	Stop  float64 `json:"stop"`
+	Step  float64 `json:"step"`
+}
+
+// SeedPolicy decides the seed of every run, see the seed policy constants
+type SeedPolicy struct {
+	Policy string `json:"policy"`
+	Base   int64  `json:"base"`
+}
+
+// ParamValue is the value of a swept parameter at one point
+type ParamValue struct {
+	Param string      `json:"param"`
+	Value interface{} `json:"value"`
+}
+
+// ExperimentPoint is one combination of the swept values
+type ExperimentPoint struct {
+	Index  int          `json:"index"`
+	Params []ParamValue `json:"params"`
+}
+
+func (point ExperimentPoint) String() string {
+	parts := make([]string, 0, len(point.Params))
+	for _, param := range point.Params {
+		parts = append(parts, fmt.Sprintf("%s=%v", param.Param, param.Value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// ExperimentRun is the outcome of one replication at one point
+type ExperimentRun struct {
+	Point       ExperimentPoint    `json:"point"`
+	Replication int                `json:"replication"`
+	Seed        int64              `json:"seed"`
+	Metrics     map[string]float64 `json:"metrics"`
+	Err         string             `json:"error,omitempty"`
+
+	Config     *GeneralLaneSimulationConfig `json:"config,omitempty"`
+	TimeSeries []DetectorReport             `json:"timeSeries,omitempty"`
+}
+
+var defaultExperimentMetrics = []string{"numAccidents", "simulatedTime", "horizontal.travelTime.mean", "vertical.travelTime.mean"}
+
+// loadExperimentSpec reads an experiment from a .json, .yaml or .yml file
+func loadExperimentSpec(path string) (*ExperimentSpec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".yaml" || ext == ".yml" {
+		if data, err = yamlToJson(data); err != nil {
+			return nil, errors.Wrap(err, "reading experiment")
+		}
+	}
+	spec := &ExperimentSpec{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(spec); err != nil {
+		return nil, errors.Wrap(err, "reading experiment")
+	}
+	spec.dir = filepath.Dir(path)
+	if spec.Name == "" {
+		spec.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if err := spec.validate(); err != nil {
+		return nil, errors.Wrap(err, spec.Name)
+	}
+	return spec, nil
+}
+
+// yamlToJson lets the yaml files share the json field names
+func yamlToJson(data []byte) ([]byte, error) {
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	value, err := jsonCompatible(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// jsonCompatible turns the map[interface{}]interface{} yaml produces for non string keys into map[string]interface{}
+func jsonCompatible(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			converted, err := jsonCompatible(v)
+			if err != nil {
+				return nil, err
+			}
+			value[k] = converted
+		}
+		return value, nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v is not a string", k)
+			}
+			converted, err := jsonCompatible(v)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = converted
+		}
+		return m, nil
+	case []interface{}:
+		for i, v := range value {
+			converted, err := jsonCompatible(v)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = converted
+		}
+		return value, nil
+	}
+	return value, nil
+}
+
+// configKeys lists every key a config file may set
+func configKeys() map[string]bool {
+	keys := map[string]bool{}
+	configType := reflect.TypeOf(jsonGeneralLaneConfig{})
+	for i := 0; i < configType.NumField(); i++ {
+		name := strings.Split(configType.Field(i).Tag.Get("json"), ",")[0]
+		keys[name] = true
+	}
+	return keys
+}
+
+func (spec *ExperimentSpec) validate() error {
+	keys := configKeys()
+	for key := range spec.Base {
+		if !keys[key] {
+			return fmt.Errorf("unknown config key %s in base", key)
+		}
+	}
+	for _, sweep := range spec.Sweep {
+		if !keys[sweep.Param] {
+			return fmt.Errorf("unknown config key %s in sweep", sweep.Param)
+		}
+		if (sweep.Range == nil) == (len(sweep.Values) == 0) {
+			return fmt.Errorf("sweep of %s needs either values or a range", sweep.Param)
+		}
+		if sweep.Range != nil && (sweep.Range.Step <= 0 || sweep.Range.Stop < sweep.Range.Start) {
+			return fmt.Errorf("sweep of %s has an empty range", sweep.Param)
+		}
+	}
+	if spec.Replications < 0 {
+		return errors.New("replications cannot be negative")
+	}
+	if spec.Replications == 0 {
+		spec.Replications = 1
+	}
+	switch spec.Seed.Policy {
+	case "":
+		spec.Seed.Policy = sequentialSeedPolicy
+	case fixedSeedPolicy, sequentialSeedPolicy, perRunSeedPolicy, randomSeedPolicy:
+	default:
+		return fmt.Errorf("unknown seed policy %s", spec.Seed.Policy)
+	}
+	for _, output := range spec.Outputs {
+		if _, err := newResultSink(output); err != nil {
+			return err
+		}
+	}
+	if len(spec.Metrics) == 0 {
+		spec.Metrics = defaultExperimentMetrics
+	}
+	available := experimentMetricNames()
+	for _, metric := range spec.Metrics {
+		if !available[metric] {
+			return fmt.Errorf("unknown metric %s", metric)
+		}
+	}
+	return nil
+}
+
+// values lists what the parameter takes, a range is expanded
+func (sweep SweepParameter) values() []interface{} {
+	if sweep.Range == nil {
+		return sweep.Values
+	}
+	values := make([]interface{}, 0)
+	// the small slack keeps stop in the range despite rounding
+	for i := 0; ; i++ {
+		value := sweep.Range.Start + float64(i)*sweep.Range.Step
+		if value > sweep.Range.Stop+sweep.Range.Step*1e-9 {
+			break
+		}
+		values = append(values, math.Round(value*1e9)/1e9)
+	}
+	return values
+}
+
+// points is the cartesian product of the sweeps, the last sweep changes fastest
+func (spec *ExperimentSpec) points() []ExperimentPoint {
+	points := []ExperimentPoint{{Params: []ParamValue{}}}
+	for _, sweep := range spec.Sweep {
+		next := make([]ExperimentPoint, 0)
+		for _, point := range points {
+			for _, value := range sweep.values() {
+				params := append(append([]ParamValue{}, point.Params...), ParamValue{Param: sweep.Param, Value: value})
+				next = append(next, ExperimentPoint{Params: params})
+			}
+		}
+		points = next
+	}
+	for i := range points {
+		points[i].Index = i
+	}
+	return points
+}
+
+// config builds the simulation config of a point
+func (spec *ExperimentSpec) config(point ExperimentPoint) (*GeneralLaneSimulationConfig, error) {
+	config := DefaultGeneralLaneConfig()
+	if spec.BaseFile != "" {
+		path := spec.BaseFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(spec.dir, path)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, errors.Wrap(err, "reading base file")
+		}
+	}
+	if err := applyConfigValues(config, spec.Base); err != nil {
+		return nil, errors.Wrap(err, "applying base")
+	}
+	for _, param := range point.Params {
+		if err := applyConfigValues(config, map[string]interface{}{param.Param: param.Value}); err != nil {
+			return nil, errors.Wrapf(err, "setting %s", param.Param)
+		}
+	}
+	if config.networkFile != "" && !filepath.IsAbs(config.networkFile) {
+		config.networkFile = filepath.Join(spec.dir, config.networkFile)
+	}
+	if config.traceFile != "" && !filepath.IsAbs(config.traceFile) {
+		config.traceFile = filepath.Join(spec.dir, config.traceFile)
+	}
+	for i, profile := range config.demandProfiles {
+		if profile.File != "" && !filepath.IsAbs(profile.File) {
+			config.demandProfiles[i].File = filepath.Join(spec.dir, profile.File)
+		}
+	}
+	config.wallClockSpeed = 0 // experiments never wait on the wall clock
+	return config, nil
+}
+
+// applyConfigValues overwrites the given keys of the config
+func applyConfigValues(config *GeneralLaneSimulationConfig, values map[string]interface{}) error {
+	if len(values) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, config)
+}
+
+// seed picks the seed of a run following the seed policy
+func (spec *ExperimentSpec) seed(point ExperimentPoint, replication int) int64 {
+	switch spec.Seed.Policy {
+	case fixedSeedPolicy:
+		return spec.Seed.Base
+	case perRunSeedPolicy:
+		return spec.Seed.Base + int64(point.Index*spec.Replications+replication)
+	case randomSeedPolicy:
+		return time.Now().UnixNano()
+	}
+	return spec.Seed.Base + int64(replication)
+}
+
+// experimentMetrics has every metric a finished simulation can report
+func experimentMetrics(sim *GeneralLaneSimulation, wallTime time.Duration) map[string]float64 {
+	metrics := map[string]float64{}
+	sim.runningSimulationLock.Lock()
+	metrics["numAccidents"] = float64(sim.numAccidents)
+	metrics["simulatedTime"] = sim.simulatedTime
+	conflicts := sim.conflicts
+	movements := sim.movements
+	sim.runningSimulationLock.Unlock()
+	flattenMetrics(metrics, "conflicts.", conflicts)
+	flattenMetrics(metrics, "movements.", movements)
+	metrics["numEvents"] = float64(sim.scheduler.numProcessed)
+	metrics["wallTime"] = wallTime.Seconds()
+
+	flattenMetrics(metrics, "", sim.tripSummary())
+	flattenMetrics(metrics, "ring.", sim.ringMetrics())
+	flattenMetrics(metrics, "", sim.throughputMetrics())
+	flattenMetrics(metrics, "routes.", sim.routeMetrics())
+	flattenMetrics(metrics, "closures.", sim.closureMetrics())
+	flattenMetrics(metrics, "signals.", sim.signalMetrics())
+	flattenMetrics(metrics, "priority.", sim.priorityMetrics())
+	flattenMetrics(metrics, "emergency.", sim.emergencyMetrics())
+	flattenMetrics(metrics, "responders.", sim.responderMetrics())
+
+	var flow, occupancy, density, speed []float64
+	for _, report := range sim.detectorReports() {
+		for _, window := range report.Windows {
+			flow = append(flow, window.Flow)
+			occupancy = append(occupancy, window.Occupancy)
+			density = append(density, window.Density)
+			if window.SpaceMeanSpeed > 0 {
+				speed = append(speed, window.SpaceMeanSpeed)
+			}
+		}
+	}
+	flattenMetrics(metrics, "detectors.flow.", summarize(flow))
+	flattenMetrics(metrics, "detectors.occupancy.", summarize(occupancy))
+	flattenMetrics(metrics, "detectors.density.", summarize(density))
+	flattenMetrics(metrics, "detectors.spaceMeanSpeed.", summarize(speed))
+	return metrics
+}
+
+// flattenMetrics adds every number of value under its json path, like horizontal.travelTime.mean
+func flattenMetrics(metrics map[string]float64, prefix string, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return
+	}
+	var walk func(prefix string, node interface{})
+	walk = func(prefix string, node interface{}) {
+		switch node := node.(type) {
+		case map[string]interface{}:
+			for key, child := range node {
+				walk(prefix+key+".", child)
+			}
+		case float64:
+			metrics[strings.TrimSuffix(prefix, ".")] = node
+		}
+	}
+	walk(prefix, tree)
+}
+
+// experimentMetricNames lists the names experimentMetrics reports
+func experimentMetricNames() map[string]bool {
+	config := DefaultGeneralLaneConfig()
+	sim, err := initMultiLaneSimulation(config)
+	names := map[string]bool{}
+	if err != nil {
+		return names
+	}
+	for name := range experimentMetrics(sim, 0) {
+		names[name] = true
+	}
+	return names
+}
+
+// runExperimentPoint runs one replication without drawing it
+func (spec *ExperimentSpec) runExperimentPoint(point ExperimentPoint, replication int) ExperimentRun {
+	run := ExperimentRun{Point: point, Replication: replication, Seed: spec.seed(point, replication)}
+	config, err := spec.config(point)
+	if err != nil {
+		run.Err = err.Error()
+		return run
+	}
+	config.seed = run.Seed
+	if config.traceFile != "" { // every run gets its own trace
+		ext := filepath.Ext(config.traceFile)
+		config.traceFile = fmt.Sprintf("%s-%d-%d%s", strings.TrimSuffix(config.traceFile, ext), point.Index, replication, ext)
+	}
+	simulation, err := initMultiLaneSimulation(config)
+	if err != nil {
+		run.Err = err.Error()
+		return run
+	}
+	start := time.Now()
+	runHeadless(simulation)
+	all := experimentMetrics(simulation, time.Since(start))
+	run.Metrics = make(map[string]float64, len(spec.Metrics))
+	for _, metric := range spec.Metrics {
+		run.Metrics[metric] = all[metric]
+	}
+	run.Config = config
+	if spec.TimeSeries {
+		run.TimeSeries = simulation.detectorReports()
+	}
+	return run
+}
+
+// sortedMetricNames is handy to show what an experiment may ask for
+func sortedMetricNames() []string {
+	names := make([]string, 0)
+	for name := range experimentMetricNames() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -34145,7 +34145,7 @@ function (_Component) {
           lineNumber: 6
         },
         __self: this
-      }, "\uD83D\uDE97", _react.default.createElement("div", {
+      }, this.props.details.emergency ? "\uD83D\uDE91" : "\uD83D\uDE97", _react.default.createElement("div", {
         className: "car title",
         __source: {
           fileName: _jsxFileName,
@@ -34179,10 +34179,50 @@ function (_Component) {
   return Car;
 }(_react.Component);
 
-var SimulationCars =
+var SignalLights =
 /*#__PURE__*/
 function (_Component2) {
-  (0, _inherits2.default)(SimulationCars, _Component2);
+  (0, _inherits2.default)(SignalLights, _Component2);
+
+  function SignalLights() {
+    (0, _classCallCheck2.default)(this, SignalLights);
+    return (0, _possibleConstructorReturn2.default)(this, (0, _getPrototypeOf2.default)(SignalLights).apply(this, arguments));
+  }
+
+  (0, _createClass2.default)(SignalLights, [{
+    key: "render",
+    value: function render() {
+      return _react.default.createElement("div", {
+        className: "signal",
+        __source: {
+          fileName: _jsxFileName,
+          lineNumber: 20
+        },
+        __self: this
+      }, _react.default.createElement("span", {
+        className: "light " + this.props.signal.horizontal,
+        __source: {
+          fileName: _jsxFileName,
+          lineNumber: 21
+        },
+        __self: this
+      }, "\u2194"), _react.default.createElement("span", {
+        className: "light " + this.props.signal.vertical,
+        __source: {
+          fileName: _jsxFileName,
+          lineNumber: 22
+        },
+        __self: this
+      }, "\u2195"));
+    }
+  }]);
+  return SignalLights;
+}(_react.Component);
+
+var SimulationCars =
+/*#__PURE__*/
+function (_Component3) {
+  (0, _inherits2.default)(SimulationCars, _Component3);
 
   function SimulationCars() {
     (0, _classCallCheck2.default)(this, SimulationCars);
@@ -34202,7 +34242,8 @@ function (_Component2) {
         items.push({
           "name": key,
           "waitingTime": value.WaitingTime,
-          "speed": value.Speed
+          "speed": value.Speed,
+          "emergency": value.Emergency
         });
       }
 
@@ -34211,13 +34252,13 @@ function (_Component2) {
           className: "empty",
           __source: {
             fileName: _jsxFileName,
-            lineNumber: 29
+            lineNumber: 38
           },
           __self: this
         }, _react.default.createElement("div", {
           __source: {
             fileName: _jsxFileName,
-            lineNumber: 30
+            lineNumber: 39
           },
           __self: this
         }));
@@ -34228,26 +34269,26 @@ function (_Component2) {
           className: "parking",
           __source: {
             fileName: _jsxFileName,
-            lineNumber: 35
+            lineNumber: 44
           },
           __self: this
         }, _react.default.createElement("td", {
           __source: {
             fileName: _jsxFileName,
-            lineNumber: 36
+            lineNumber: 45
           },
           __self: this
         }, _react.default.createElement("div", {
           __source: {
             fileName: _jsxFileName,
-            lineNumber: 37
+            lineNumber: 46
           },
           __self: this
         }, items.map(function (item) {
           return _react.default.createElement("div", {
             __source: {
               fileName: _jsxFileName,
-              lineNumber: 38
+              lineNumber: 47
             },
             __self: this
           }, _react.default.createElement(Car, {
@@ -34255,7 +34296,7 @@ function (_Component2) {
             displayCarDetails: _this.props.displayCarDetails,
             __source: {
               fileName: _jsxFileName,
-              lineNumber: 38
+              lineNumber: 47
             },
             __self: this
           }));
@@ -34266,20 +34307,20 @@ function (_Component2) {
         return _react.default.createElement("td", {
           __source: {
             fileName: _jsxFileName,
-            lineNumber: 46
+            lineNumber: 55
           },
           __self: this
         }, _react.default.createElement("div", {
           __source: {
             fileName: _jsxFileName,
-            lineNumber: 47
+            lineNumber: 56
           },
           __self: this
         }, "\uD83D\uDD25 \uD83D\uDD25", items.map(function (item) {
           return _react.default.createElement("div", {
             __source: {
               fileName: _jsxFileName,
-              lineNumber: 50
+              lineNumber: 59
             },
             __self: this
           }, "\uD83D\uDD25", _react.default.createElement(Car, {
@@ -34287,30 +34328,70 @@ function (_Component2) {
             displayCarDetails: _this.props.displayCarDetails,
             __source: {
               fileName: _jsxFileName,
-              lineNumber: 50
+              lineNumber: 59
             },
             __self: this
           }), "\uD83D\uDD25");
         }), "\uD83D\uDD25 \uD83D\uDD25"));
       }
 
+      if (this.props.locationState === 6) {
+        return _react.default.createElement("td", {
+          className: "closed",
+          __source: {
+            fileName: _jsxFileName,
+            lineNumber: 68
+          },
+          __self: this
+        }, _react.default.createElement("div", {
+          __source: {
+            fileName: _jsxFileName,
+            lineNumber: 69
+          },
+          __self: this
+        }, "\uD83D\uDEA7", items.map(function (item) {
+          return _react.default.createElement("div", {
+            __source: {
+              fileName: _jsxFileName,
+              lineNumber: 71
+            },
+            __self: this
+          }, _react.default.createElement(Car, {
+            details: item,
+            displayCarDetails: _this.props.displayCarDetails,
+            __source: {
+              fileName: _jsxFileName,
+              lineNumber: 71
+            },
+            __self: this
+          }));
+        })));
+      }
+
       return _react.default.createElement("td", {
         __source: {
           fileName: _jsxFileName,
-          lineNumber: 59
+          lineNumber: 78
         },
         __self: this
       }, _react.default.createElement("div", {
         __source: {
           fileName: _jsxFileName,
-          lineNumber: 60
+          lineNumber: 79
         },
         __self: this
-      }, items.map(function (item) {
+      }, this.props.signal && _react.default.createElement(SignalLights, {
+        signal: this.props.signal,
+        __source: {
+          fileName: _jsxFileName,
+          lineNumber: 80
+        },
+        __self: this
+      }), items.map(function (item) {
         return _react.default.createElement("div", {
           __source: {
             fileName: _jsxFileName,
-            lineNumber: 62
+            lineNumber: 81
           },
           __self: this
         }, _react.default.createElement(Car, {
@@ -34318,7 +34399,7 @@ function (_Component2) {
           displayCarDetails: _this.props.displayCarDetails,
           __source: {
             fileName: _jsxFileName,
-            lineNumber: 62
+            lineNumber: 81
           },
           __self: this
         }));
@@ -34326,13 +34407,13 @@ function (_Component2) {
       return _react.default.createElement("td", {
         __source: {
           fileName: _jsxFileName,
-          lineNumber: 69
+          lineNumber: 88
         },
         __self: this
       }, _react.default.createElement("div", {
         __source: {
           fileName: _jsxFileName,
-          lineNumber: 70
+          lineNumber: 89
         },
         __self: this
       }, "\xA0\xA0\xA0\xA0"));
@@ -34343,8 +34424,8 @@ function (_Component2) {
 
 var Simulation =
 /*#__PURE__*/
-function (_Component3) {
-  (0, _inherits2.default)(Simulation, _Component3);
+function (_Component4) {
+  (0, _inherits2.default)(Simulation, _Component4);
 
   function Simulation() {
     (0, _classCallCheck2.default)(this, Simulation);
@@ -34356,28 +34437,36 @@ function (_Component3) {
     value: function render() {
       var _this2 = this;
 
+      // the signal of every intersection cell that has one, by row and column
+      var signalAt = {};
+      (this.props.signals || []).forEach(function (signal) {
+        signal.cells.forEach(function (cell) {
+          signalAt[cell[0] + "," + cell[1]] = signal;
+        });
+      });
       return _react.default.createElement("table", {
         className: "tableBorder",
         __source: {
           fileName: _jsxFileName,
-          lineNumber: 77
+          lineNumber: 103
         },
         __self: this
-      }, this.props.data.map(function (row) {
+      }, this.props.data.map(function (row, i) {
         return _react.default.createElement("tr", {
           __source: {
             fileName: _jsxFileName,
-            lineNumber: 79
+            lineNumber: 105
           },
           __self: this
-        }, row.map(function (item) {
+        }, row.map(function (item, j) {
           return _react.default.createElement(SimulationCars, {
             locationState: item.state,
             car: item.cars,
+            signal: signalAt[i + "," + j],
             displayCarDetails: _this2.props.displayCarDetails,
             __source: {
               fileName: _jsxFileName,
-              lineNumber: 81
+              lineNumber: 107
             },
             __self: this
           });
@@ -84336,7 +84425,8 @@ function (_Component) {
 
       _this.setState({
         simulating: true,
-        simulationData: data.locations
+        simulationData: data.locations,
+        simulationSignals: data.signals || []
       }); // console.log(data.locations)
 
     });
@@ -84375,6 +84465,7 @@ function (_Component) {
       sizeOfLane: props.sizeOfLane,
       simulating: false,
       simulationData: new Array([]),
+      simulationSignals: [],
       clientId: null,
       displayCarDetails: true
     };
@@ -84413,22 +84504,23 @@ function (_Component) {
         className: "App",
         __source: {
           fileName: _jsxFileName,
-          lineNumber: 367
+          lineNumber: 368
         },
         __self: this
       }, this.state.simulating && _react.default.createElement(_Simulation.default, {
         simulating: this.state.simulating,
         data: this.state.simulationData,
+        signals: this.state.simulationSignals,
         displayCarDetails: this.state.displayCarDetails,
         __source: {
           fileName: _jsxFileName,
-          lineNumber: 369
+          lineNumber: 370
         },
         __self: this
       }), _react.default.createElement("div", {
         __source: {
           fileName: _jsxFileName,
-          lineNumber: 371
+          lineNumber: 373
         },
         __self: this
       }, "Running Simulation: ", this.state.simulating.toString()), _react.default.createElement(SimulationForm, {
@@ -84437,14 +84529,14 @@ function (_Component) {
         cancelSimulation: this.cancelSimulation,
         __source: {
           fileName: _jsxFileName,
-          lineNumber: 372
+          lineNumber: 374
         },
         __self: this
       }), _react.default.createElement(SimulationDisplayForm, {
         onSubmit: this.changeDisplayDetails,
         __source: {
           fileName: _jsxFileName,
-          lineNumber: 374
+          lineNumber: 376
         },
         __self: this
       }));
@@ -4,7 +4,7 @@ class Car extends Component {
     render() {
         return (
             <div>
-                🚗
+                {this.props.details.emergency ? "🚑" : "🚗"}
                 <div className={"car title"}>{this.props.details.name}</div>
                 {this.props.displayCarDetails && <div className={"cardetails"}>
                     <div className={"speed"}>Speed: {this.props.details.speed}</div>
@@ -15,6 +15,15 @@ class Car extends Component {
     }
 }
 
+class SignalLights extends Component {
+    render() {
+        return <div className={"signal"}>
+            <span className={"light " + this.props.signal.horizontal}>↔</span>
+            <span className={"light " + this.props.signal.vertical}>↕</span>
+        </div>
+    }
+}
+
 class SimulationCars extends Component {
     render() {
         var items = [];
@@ -22,7 +31,7 @@ class SimulationCars extends Component {
         // console.log(this.props.car);
         for (var key in this.props.car) {
             value = this.props.car[key];
-            items.push({"name": key, "waitingTime": value.WaitingTime, "speed": value.Speed})
+            items.push({"name": key, "waitingTime": value.WaitingTime, "speed": value.Speed, "emergency": value.Emergency})
         }
 
         if (this.props.locationState === 0) {
@@ -55,10 +64,20 @@ class SimulationCars extends Component {
             </td>
         }
 
+        if (this.props.locationState === 6) {
+            return <td className={"closed"}>
+                <div>
+                    🚧
+                    {items.map(item => <div><Car details={item} displayCarDetails={this.props.displayCarDetails}/>
+                    </div>)}
+                </div>
+            </td>
+        }
+
         return (
             <td>
                 <div>
-
+                    {this.props.signal && <SignalLights signal={this.props.signal}/>}
                     {items.map(item => <div><Car details={item} displayCarDetails={this.props.displayCarDetails}/>
                     </div>)}
                 </div>
@@ -74,11 +93,19 @@ class SimulationCars extends Component {
 
 class Simulation extends Component {
     render() {
+        // the signal of every intersection cell that has one, by row and column
+        var signalAt = {};
+        (this.props.signals || []).forEach(signal => {
+            signal.cells.forEach(cell => {
+                signalAt[cell[0] + "," + cell[1]] = signal;
+            })
+        });
         return <table className={"tableBorder"}>
-            {this.props.data.map(row => {
+            {this.props.data.map((row, i) => {
                 return <tr>
-                    {row.map(item => {
+                    {row.map((item, j) => {
                         return <SimulationCars locationState={item.state} car={item.cars}
+                                               signal={signalAt[i + "," + j]}
                                                displayCarDetails={this.props.displayCarDetails}/>
                     })}
                 </tr>
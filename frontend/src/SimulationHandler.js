@@ -278,6 +278,7 @@ class SimulationHandler extends Component {
             sizeOfLane: props.sizeOfLane,
             simulating: false,
             simulationData: new Array([]),
+            simulationSignals: [],
             clientId: null,
             displayCarDetails: true
         }
@@ -332,7 +333,7 @@ class SimulationHandler extends Component {
     //    from the backend server on the socket.
     updateSimulationState = (data) => {
         console.log("update simulation event");
-        this.setState({simulating: true, simulationData: data.locations});
+        this.setState({simulating: true, simulationData: data.locations, simulationSignals: data.signals || []});
         // console.log(data.locations)
     };
 
@@ -367,6 +368,7 @@ class SimulationHandler extends Component {
             <div className="App">
                 {this.state.simulating &&
                 <Simulation simulating={this.state.simulating} data={this.state.simulationData}
+                            signals={this.state.simulationSignals}
                             displayCarDetails={this.state.displayCarDetails}/>}
                 <div>Running Simulation: {this.state.simulating.toString()}</div>
                 <SimulationForm onSubmit={this.startSimulation} simulating={this.state.simulating}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The gym API lets an agent outside of Go run the signals of a simulation one step at a time, the way a Gym
+// environment does. Reset starts a simulation and pauses it, every step sets the phases, runs stepLength simulated
+// seconds and pauses again. All of it is JSON over HTTP:
+//
+//	POST /gym/reset   {"id": optional, "config": {...}, "stepLength": 5}
+//	POST /gym/step    {"id": "...", "phases": [0, 1, -1]}
+//	GET  /gym/observe?id=...
+//	POST /gym/close   {"id": "..."}
+//
+// Every call but close answers with a GymResponse
+
+const (
+	gymDefaultStepLength = 5
+	gymStepTimeout       = time.Minute
+)
+
+// GymResetRequest starts an environment over, or a new one when it has no ID
+type GymResetRequest struct {
+	ID         string                 `json:"id,omitempty"`
+	Config     map[string]interface{} `json:"config"`     // config keys like the base of an experiment
+	StepLength float64                `json:"stepLength"` // simulated seconds a step runs for
+}
+
+// GymStepRequest is an action, the phase every signal should show in order of their IDs. A negative phase leaves
+// the signal on the phase it was last asked for
+type GymStepRequest struct {
+	ID     string `json:"id"`
+	Phases []int  `json:"phases"`
+}
+
+// GymSignalObservation is a signal as the agent sees it
+type GymSignalObservation struct {
+	ID     int            `json:"id"`
+	Phase  int            `json:"phase"`  // 0 lets the east west approaches go, 1 the north south ones
+	State  string         `json:"state"`  // green, yellow or allRed
+	Queues map[string]int `json:"queues"` // cars on the detector cells of every approach, by direction
+}
+
+// GymObservation is the state of the simulation after a step
+type GymObservation struct {
+	Time      float64                `json:"time"`
+	Occupancy [][]int                `json:"occupancy"` // cars on every cell
+	States    [][]LocationState      `json:"states"`    // what every cell is, an accident or a closure among others
+	Signals   []GymSignalObservation `json:"signals"`
+}
+
+// GymResponse is what the agent gets back from a reset, a step or an observe. The reward is the negative total
+// waiting time of the cars on the grid, done tells the simulation ended
+type GymResponse struct {
+	ID          string         `json:"id"`
+	Observation GymObservation `json:"observation"`
+	Reward      float64        `json:"reward"`
+	Done        bool           `json:"done"`
+}
+
+// gymEnv is a simulation driven by an agent. Its loop runs in its own goroutine and is paused between steps
+type gymEnv struct {
+	envLock    sync.Mutex // one call at a time
+	sim        *GeneralLaneSimulation
+	stepLength float64
+	done       chan struct{} // closed once the simulation loop returned
+}
+
+type gymServer struct {
+	lock   sync.Mutex
+	envs   map[string]*gymEnv
+	nextID int
+}
+
+var gym = &gymServer{envs: map[string]*gymEnv{}}
+
+func (server *gymServer) env(id string) (*gymEnv, bool) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	env, ok := server.envs[id]
+	return env, ok
+}
+
+// gymConfig builds the config of an environment. Its signals always take their phases from the agent, and files
+// are kept inside their directories like the ones sent over the websocket
+func gymConfig(values map[string]interface{}) (*GeneralLaneSimulationConfig, error) {
+	config := DefaultGeneralLaneConfig()
+	if err := applyConfigValues(config, values); err != nil {
+		return nil, err
+	}
+	config.signalControl = externalSignals
+	config.wallClockSpeed = 0
+	if config.networkFile != "" {
+		path, ok := pathInDir(networkDir, config.networkFile)
+		if !ok {
+			return nil, errors.New("invalid network file")
+		}
+		config.networkFile = path
+	}
+	for i, profile := range config.demandProfiles {
+		if profile.File == "" {
+			continue
+		}
+		path, ok := pathInDir(demandDir, profile.File)
+		if !ok {
+			return nil, errors.New("invalid demand file")
+		}
+		config.demandProfiles[i].File = path
+	}
+	if config.traceFile != "" {
+		path, ok := pathInDir(traceDir, config.traceFile)
+		if !ok {
+			return nil, errors.New("invalid trace file")
+		}
+		if err := os.MkdirAll(traceDir, 0755); err != nil {
+			return nil, err
+		}
+		config.traceFile = path
+	}
+	return config, nil
+}
+
+// start runs the simulation up to its first pause, at time 0
+func (env *gymEnv) start() error {
+	sim := env.sim
+	reply := make(chan error, 1)
+	sim.pauseAt = 0
+	sim.pauseReply = reply
+	sim.setRunningSimulation(true)
+	go func() {
+		for {
+			select {
+			case <-sim.drawUpdateChan:
+			case <-env.done:
+				return
+			}
+		}
+	}()
+	go func() {
+		RunGeneralSimulation(sim)
+		close(env.done)
+	}()
+	return env.wait(reply)
+}
+
+func (env *gymEnv) wait(reply chan error) error {
+	select {
+	case err := <-reply:
+		return err
+	case <-env.done:
+		return nil
+	case <-time.After(gymStepTimeout):
+		return errors.New("timed out waiting for the simulation to pause")
+	}
+}
+
+func (env *gymEnv) isDone() bool {
+	select {
+	case <-env.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// step sets the phases and runs the simulation for the step length
+func (env *gymEnv) step(phases []int) error {
+	if len(phases) > len(env.sim.signals) {
+		return fmt.Errorf("there are %d signals, not %d", len(env.sim.signals), len(phases))
+	}
+	for i, phase := range phases {
+		if phase >= signalPhases {
+			return fmt.Errorf("signal %d has no phase %d", i, phase)
+		}
+	}
+	if env.isDone() {
+		return nil
+	}
+	reply := make(chan error, 1)
+	control := SimulationControl{controlType: agentStepControl, phases: phases,
+		time: env.sim.getSimulatedTime() + env.stepLength, reply: reply}
+	select {
+	case env.sim.controlChan <- control:
+	default:
+		return errors.New("too many pending simulation controls")
+	}
+	return env.wait(reply)
+}
+
+// stop cancels the simulation and waits for its loop to return
+func (env *gymEnv) stop() {
+	if env.isDone() {
+		return
+	}
+	env.sim.cancel()
+	<-env.done
+}
+
+// response observes the simulation, only while it is paused or once it ended
+func (env *gymEnv) response(id string) GymResponse {
+	sim := env.sim
+	observation := GymObservation{
+		Time:      sim.getSimulatedTime(),
+		Occupancy: make([][]int, sim.numRows()),
+		States:    make([][]LocationState, sim.numRows()),
+		Signals:   make([]GymSignalObservation, 0, len(sim.signals)),
+	}
+	waiting := 0.0
+	for i, row := range sim.Locations {
+		observation.Occupancy[i] = make([]int, len(row))
+		observation.States[i] = make([]LocationState, len(row))
+		for j, loc := range row {
+			observation.States[i][j] = loc.getLocationState()
+			cars := loc.sortedCars()
+			observation.Occupancy[i][j] = len(cars)
+			for _, car := range cars {
+				car.smartCarLock.Lock()
+				waiting += car.WaitingTime
+				car.smartCarLock.Unlock()
+			}
+		}
+	}
+	for _, signal := range sim.signals {
+		queues := map[string]int{}
+		for _, direction := range signal.approaches {
+			queues[direction.name()] = sim.approachQueue(signal.cells, direction, signal.plan.Detector)
+		}
+		signal.signalLock.Lock()
+		state := signal.state
+		phase := signal.phase
+		signal.signalLock.Unlock()
+		observation.Signals = append(observation.Signals, GymSignalObservation{ID: signal.ID, Phase: phase,
+			State: state.name(), Queues: queues})
+	}
+	return GymResponse{ID: id, Observation: observation, Reward: -waiting, Done: env.isDone()}
+}
+
+func (state SignalState) name() string {
+	switch state {
+	case signalYellow:
+		return "yellow"
+	case signalAllRed:
+		return "allRed"
+	}
+	return "green"
+}
+
+func writeGymJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func gymReset(w http.ResponseWriter, r *http.Request) {
+	var request GymResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.StepLength < 0 {
+		http.Error(w, "the step length cannot be negative", http.StatusBadRequest)
+		return
+	}
+	if request.StepLength == 0 {
+		request.StepLength = gymDefaultStepLength
+	}
+	config, err := gymConfig(request.Config)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sim, err := initMultiLaneSimulation(config)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	gym.lock.Lock()
+	id := request.ID
+	old, exists := gym.envs[id]
+	if id == "" {
+		gym.nextID++
+		id = fmt.Sprintf("env%d", gym.nextID)
+	} else if !exists {
+		gym.lock.Unlock()
+		http.Error(w, "unknown environment "+id, http.StatusNotFound)
+		return
+	}
+	env := &gymEnv{sim: sim, stepLength: request.StepLength, done: make(chan struct{})}
+	env.envLock.Lock()
+	defer env.envLock.Unlock()
+	gym.envs[id] = env
+	gym.lock.Unlock()
+
+	if old != nil {
+		old.envLock.Lock()
+		old.stop()
+		old.envLock.Unlock()
+	}
+	if err := env.start(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeGymJSON(w, env.response(id))
+}
+
+func gymStep(w http.ResponseWriter, r *http.Request) {
+	var request GymStepRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	env, ok := gym.env(request.ID)
+	if !ok {
+		http.Error(w, "unknown environment "+request.ID, http.StatusNotFound)
+		return
+	}
+	env.envLock.Lock()
+	defer env.envLock.Unlock()
+	if err := env.step(request.Phases); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeGymJSON(w, env.response(request.ID))
+}
+
+func gymObserve(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	env, ok := gym.env(id)
+	if !ok {
+		http.Error(w, "unknown environment "+id, http.StatusNotFound)
+		return
+	}
+	env.envLock.Lock()
+	defer env.envLock.Unlock()
+	writeGymJSON(w, env.response(id))
+}
+
+func gymClose(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	gym.lock.Lock()
+	env, ok := gym.envs[request.ID]
+	delete(gym.envs, request.ID)
+	gym.lock.Unlock()
+	if !ok {
+		http.Error(w, "unknown environment "+request.ID, http.StatusNotFound)
+		return
+	}
+	env.envLock.Lock()
+	defer env.envLock.Unlock()
+	env.stop()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"github.com/urfave/cli"
 	"io/ioutil"
 	"log"
@@ -51,16 +52,168 @@ func commands(app *cli.App) {
 			Name:    "General multi lane",
 			Aliases: []string{"t"},
 			Usage:   "Can run the simulation as terminal printouts",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "restore",
+					Usage:    "continues from a snapshot file saved with the w control",
+					Required: false,
+				},
+				&cli.StringFlag{
+					Name:     "trace",
+					Usage:    "writes every event of the run to this file as JSON Lines",
+					Required: false,
+				},
+			},
 			Action: func(c *cli.Context) {
-				RunTerminalMultiLaneSimulation()
+				RunTerminalMultiLaneSimulation(c.String("restore"), c.String("trace"))
+			},
+		},
+		{
+			Name:      "replay",
+			Usage:     "Prints a run again from its trace file without simulating it",
+			ArgsUsage: "<trace file>",
+			Flags: []cli.Flag{
+				&cli.Float64Flag{
+					Name:     "speed",
+					Usage:    "simulated seconds played per second, 0 plays as fast as possible",
+					Value:    1,
+					Required: false,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return cli.NewExitError("replay needs the trace file", 1)
+				}
+				RunTerminalReplay(c.Args().First(), c.Float64("speed"))
+				return nil
+			},
+		},
+		{
+			Name:    "fundamental-diagram",
+			Aliases: []string{"fd"},
+			Usage:   "Sweeps inAlpha and numHorizontalCars on one lane and prints flow-density points as csv",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "alphas",
+					Usage: "comma separated arrival rates",
+					Value: "0.25,0.5,1,2,4",
+				},
+				&cli.StringFlag{
+					Name:  "cars",
+					Usage: "comma separated numbers of horizontal cars",
+					Value: "10,50,100",
+				},
+				&cli.Float64Flag{
+					Name:  "window",
+					Usage: "aggregation window of the loop detectors",
+					Value: 10,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				alphas, err := parseFloatList(c.String("alphas"))
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				numCars, err := parseIntList(c.String("cars"))
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				log.SetOutput(ioutil.Discard)
+				if err := RunFundamentalDiagram(alphas, numCars, c.Float64("window")); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "network",
+			Usage: "Works with road network files",
+			Subcommands: []cli.Command{
+				{
+					Name:      "import",
+					Usage:     "Converts an OpenStreetMap XML extract into a network file",
+					ArgsUsage: "<osm file> <network file>",
+					Flags: []cli.Flag{
+						&cli.Float64Flag{
+							Name:  "cell-length",
+							Usage: "metres of road in a cell",
+							Value: osmDefaultCellLength,
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 2 {
+							return cli.NewExitError("import needs the osm file and the network file to write", 1)
+						}
+						if err := ImportOSMFile(c.Args().Get(0), c.Args().Get(1), c.Float64("cell-length")); err != nil {
+							return cli.NewExitError(err.Error(), 1)
+						}
+						return nil
+					},
+				},
 			},
 		},
 		{
 			Name:    "Run Experiments",
 			Aliases: []string{"e", "experiment"},
 			Usage:   "Run the four experiments we defined in the report",
-			Action: func(c *cli.Context) {
-				RunExperiments()
+			Action: func(c *cli.Context) error {
+				log.SetOutput(ioutil.Discard)
+				if err := RunExperiments(); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				return nil
+			},
+			Subcommands: []cli.Command{
+				{
+					Name:      "run",
+					Usage:     "Runs the experiment described by a json or yaml file and prints the mean and 95% confidence interval of every metric as csv",
+					ArgsUsage: "<experiment file>",
+					Flags: []cli.Flag{
+						&cli.IntFlag{
+							Name:  "workers",
+							Usage: "simulations run at the same time, defaults to GOMAXPROCS",
+						},
+						&cli.IntFlag{
+							Name:  "replications",
+							Usage: "replications per point, overrides the experiment file",
+						},
+						&cli.BoolFlag{
+							Name:  "runs",
+							Usage: "also prints every run before the summary",
+						},
+						&cli.StringSliceFlag{
+							Name:  "output",
+							Usage: "also saves the result as type:path, with type csv (a directory), json or sqlite. Can be repeated",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							return cli.NewExitError("run needs the experiment file", 1)
+						}
+						outputs := make([]OutputSpec, 0)
+						for _, value := range c.StringSlice("output") {
+							output, err := parseOutputSpec(value)
+							if err != nil {
+								return cli.NewExitError(err.Error(), 1)
+							}
+							outputs = append(outputs, output)
+						}
+						log.SetOutput(ioutil.Discard)
+						if err := RunExperimentFile(c.Args().First(), c.Int("workers"), c.Int("replications"), c.Bool("runs"), outputs); err != nil {
+							return cli.NewExitError(err.Error(), 1)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "metrics",
+					Usage: "Lists the metrics an experiment file can collect",
+					Action: func(c *cli.Context) {
+						for _, name := range sortedMetricNames() {
+							fmt.Println(name)
+						}
+					},
+				},
 			},
 		},
 	}
@@ -2,12 +2,11 @@ package main
 
 import (
 	"fmt"
-	"github.com/go-siris/siris/core/errors"
 	"gonum.org/v1/gonum/stat/distuv"
 	"gonum.org/v1/gonum/stat/sampleuv"
 	"log"
 	"math"
-	"math/rand"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -17,9 +16,12 @@ type Direction int
 
 const unlikelyIterations = 5
 
+// Horizontal cars drive east, toward higher columns, and vertical ones south, toward higher rows
 const (
 	Horizontal Direction = iota
 	Vertical
+	Westbound
+	Northbound
 )
 
 type AccidentResolution int
@@ -36,6 +38,40 @@ type Accident struct {
 	prevLocationState LocationState
 	probRestart       float64
 	removalRate       float64
+
+	reported float64 // when the crews heard of it
+	crew     int     // the crew sent to clear it
+	attended bool    // the crew got there
+}
+
+// ConflictCounts splits the accidents by how the car that crashed was moving compared to the cars it hit
+type ConflictCounts struct {
+	RearEnd  int `json:"rearEnd"`  // going the same way
+	Crossing int `json:"crossing"` // on the crossing street
+	HeadOn   int `json:"headOn"`   // coming the other way
+	LeftTurn int `json:"leftTurn"` // one of them turning left across the other coming from the opposite approach
+}
+
+// countConflict adds an accident of car into the cars at loc, nothing when loc was empty
+func (counts *ConflictCounts) countConflict(car *SmartCar, cars []*SmartCar) {
+	if len(cars) == 0 {
+		return
+	}
+	conflict := &counts.RearEnd
+	for _, other := range cars {
+		if car.turnsLeftAcross(other) || other.turnsLeftAcross(car) {
+			conflict = &counts.LeftTurn
+			break
+		}
+		if other.Direction == car.Direction.opposite() {
+			conflict = &counts.HeadOn
+			break
+		}
+		if other.Direction != car.Direction {
+			conflict = &counts.Crossing
+		}
+	}
+	*conflict++
 }
 
 type Parking struct {
@@ -63,6 +99,21 @@ type SmartCar struct {
 	slowingDown  bool
 	WaitingTime  float64
 	smartCarLock sync.Mutex
+
+	clock      *SimEvent         // the pending movement attempt, at most one per car
+	stalledLoc *StatefulLocation // set while the car stands still because its speed is zero
+
+	movement       Movement  // what the car does at the intersection it is crossing or about to enter
+	approach       Direction // the direction the car came into that intersection going
+	movementChosen bool      // cleared once the car is past the intersection
+
+	arrivedAt float64 // when the car first tried to drive into that intersection
+	rested    bool    // it came to rest before driving in
+
+	Emergency   bool // an emergency vehicle, the other cars move aside for it
+	movingAside bool // the car leaves its lane on its next move, to let an emergency vehicle by
+
+	trip TripMetrics
 }
 
 func (car *SmartCar) isSlowingDown() bool {
@@ -98,6 +149,7 @@ const (
 	ParkingLoc            LocationState = 3
 	AccidentLocationState LocationState = 4
 	CrossWalk             LocationState = 5
+	ClosedLocationState   LocationState = 6 // shut by a planned closure
 )
 
 // Location is one spot on a lane
@@ -107,6 +159,8 @@ type StatefulLocation struct {
 	X             int
 	Y             int
 	locationLock  sync.Mutex
+
+	detector *LoopDetector // only set on locations being measured
 }
 
 func (loc *StatefulLocation) setLocationState(state LocationState) {
@@ -121,29 +175,41 @@ func (loc *StatefulLocation) getLocationState() LocationState {
 	return loc.LocationState
 }
 
-func getNewCarSpeed(speedType CarDistributionType, carSpeedEndRange float64, carSpeed float64, removeUnlikely bool, unlikelyCutoff float64) (float64, float64) {
+func getNewCarSpeed(stream *RandomStream, speedType CarDistributionType, carSpeedEndRange float64, carSpeed float64, removeUnlikely bool, unlikelyCutoff float64) (float64, float64) {
 	var speed float64
 	var prob float64
 	if speedType == constantDistribution {
 		speed = 1.0
 		prob = 1.0
 	} else if speedType == normalDistribution {
-		var UnitNormal = distuv.Normal{Mu: carSpeed, Sigma: 1}
+		var UnitNormal = distuv.Normal{Mu: carSpeed, Sigma: 1, Src: stream.source}
 		speed = UnitNormal.Rand()
+		for i := 0; speed <= 0 && i < unlikelyIterations; i++ {
+			speed = UnitNormal.Rand() // a car can't drive backwards
+		}
+		if speed <= 0 {
+			speed = UnitNormal.Mean()
+		}
 		prob = UnitNormal.Prob(speed)
 	} else if speedType == exponentialDistribution {
 		var exponential = distuv.Exponential{Rate: carSpeed}
-		speed = getExpRand(carSpeed, unlikelyCutoff, removeUnlikely)
+		speed = getExpRand(stream, carSpeed, unlikelyCutoff, removeUnlikely)
 		prob = exponential.Prob(speed)
 	} else if speedType == poissonDistribution {
 		var poisson = distuv.Poisson{Lambda: carSpeed}
-		speed = getPoissonRand(carSpeed, unlikelyCutoff, removeUnlikely)
+		speed = getPoissonRand(stream, carSpeed, unlikelyCutoff, removeUnlikely)
+		for i := 0; speed <= 0 && i < unlikelyIterations; i++ {
+			speed = getPoissonRand(stream, carSpeed, unlikelyCutoff, removeUnlikely) // a car of speed 0 never moves again
+		}
+		if speed <= 0 {
+			speed = poisson.Mean()
+		}
 		prob = poisson.Prob(speed)
 	} else if speedType == uniformDistribution {
 		if carSpeedEndRange == 0 {
 			carSpeedEndRange = 1
 		}
-		speed = UniformRandMinMax(0, carSpeedEndRange)
+		speed = stream.UniformRandMinMax(0, carSpeedEndRange)
 		prob = 1 / carSpeedEndRange
 	}
 	return speed, prob
@@ -160,7 +226,9 @@ func (loc *StatefulLocation) canMoveToParking() bool {
 	return state == LaneLoc || state == CrossWalk || state == AccidentLocationState
 }
 
-func (loc *StatefulLocation) addNCars(numCars int,
+func (loc *StatefulLocation) addNCars(stream *RandomStream,
+	idPrefix string,
+	numCars int,
 	direction Direction,
 	probMovement float64,
 	speedType CarDistributionType,
@@ -168,23 +236,23 @@ func (loc *StatefulLocation) addNCars(numCars int,
 	carSpeed float64,
 	unlikely bool, unlikelyCutoff float64) {
 	for i := 0; i < numCars; i++ {
-		var id string
-		if direction == Horizontal {
-			id = fmt.Sprintf("hcar %d", i)
-		} else {
-			id = fmt.Sprintf("vcar %d", i)
-		}
-		speed, _ := getNewCarSpeed(speedType, carSpeedEndRange, carSpeed, unlikely, unlikelyCutoff)
-		loc.Cars[id] = &SmartCar{
-			ID:        id,
-			Direction: direction,
-			X:         -1, Y: -1,
-			Speed:        speed,
-			probMovement: probMovement,
-			carState:     Working,
-			smartCarLock: sync.Mutex{}}
+		id := fmt.Sprintf("%s %d", idPrefix, i)
+		speed, _ := getNewCarSpeed(stream, speedType, carSpeedEndRange, carSpeed, unlikely, unlikelyCutoff)
+		loc.Cars[id] = newSmartCar(id, direction, speed, probMovement)
 	}
 }
+
+// newSmartCar makes a working car that is not on the grid yet
+func newSmartCar(id string, direction Direction, speed float64, probMovement float64) *SmartCar {
+	return &SmartCar{
+		ID:        id,
+		Direction: direction,
+		X:         -1, Y: -1,
+		Speed:        speed,
+		probMovement: probMovement,
+		carState:     Working,
+		smartCarLock: sync.Mutex{}}
+}
 func (loc *StatefulLocation) noCars() bool {
 	loc.locationLock.Lock()
 	defer loc.locationLock.Unlock()
@@ -201,8 +269,13 @@ func (loc *StatefulLocation) isEmpty() bool {
 
 func (loc *StatefulLocation) removeCar(car *SmartCar) {
 	loc.locationLock.Lock()
-	defer loc.locationLock.Unlock()
+	_, found := loc.Cars[car.ID]
 	delete(loc.Cars, car.ID)
+	loc.locationLock.Unlock()
+
+	if found && loc.detector != nil {
+		loc.detector.carLeft(car)
+	}
 }
 
 func (loc *StatefulLocation) getCar(del bool) (*SmartCar) {
@@ -220,6 +293,32 @@ func (loc *StatefulLocation) getCar(del bool) (*SmartCar) {
 	return currCar
 }
 
+// sortedCars lists the cars at the location ordered by ID, so iterating them is reproducible
+func (loc *StatefulLocation) sortedCars() []*SmartCar {
+	loc.locationLock.Lock()
+	defer loc.locationLock.Unlock()
+
+	cars := make([]*SmartCar, 0, len(loc.Cars))
+	for _, car := range loc.Cars {
+		cars = append(cars, car)
+	}
+	sort.Slice(cars, func(i, j int) bool {
+		return cars[i].ID < cars[j].ID
+	})
+	return cars
+}
+
+// pickCar removes a uniformly chosen car from the location
+func (loc *StatefulLocation) pickCar(stream *RandomStream) *SmartCar {
+	cars := loc.sortedCars()
+	if len(cars) == 0 {
+		return nil
+	}
+	car := cars[stream.Intn(len(cars))]
+	loc.removeCar(car)
+	return car
+}
+
 func (loc *StatefulLocation) addCar(car *SmartCar) {
 	loc.locationLock.Lock()
 	car.smartCarLock.Lock()
@@ -231,6 +330,9 @@ func (loc *StatefulLocation) addCar(car *SmartCar) {
 	car.Y = loc.Y
 	loc.Cars[car.ID] = car
 
+	if loc.detector != nil {
+		loc.detector.carArrived(car)
+	}
 }
 
 type CarDistributionType int
@@ -333,6 +435,102 @@ type GeneralLaneSimulationConfig struct {
 	removeUnlikelyEvents     bool
 	unlikelyCutoff           float64
 
+	// seeds every random stream of the run, the same seed and config replays the same run
+	seed int64
+
+	// pacing, simulated seconds played per wall clock second. 0 runs as fast as possible
+	wallClockSpeed float64
+
+	// JSON Lines file recording every event of the run, empty disables the trace
+	traceFile string
+
+	// loop detectors, measured over windows of detectorWindow. 0 measures the whole run as one window
+	detectors      []LocationRef
+	detectorWindow float64
+
+	// city grid, the lane count of every horizontal and vertical street. Streets are blockLength cells apart and the
+	// grid is sized to fit them, sizeOfLane only applies to the single crossing used when both are empty
+	horizontalStreets []int
+	verticalStreets   []int
+	blockLength       int
+
+	// opposing lanes, westbound next to the horizontal lanes and northbound next to the vertical ones. The city grid
+	// gives the count for each street, in the order of horizontalStreets and verticalStreets
+	numWestboundLanes  int
+	numNorthboundLanes int
+	westboundStreets   []int
+	northboundStreets  []int
+
+	// weights of turning left, going straight and turning right at an intersection for the cars going each
+	// direction. Empty lets every car go straight
+	horizontalTurnRatios []float64
+	verticalTurnRatios   []float64
+	westboundTurnRatios  []float64
+	northboundTurnRatios []float64
+
+	// a network file lays the streets out from its nodes and links, in place of the crossing or the city grid
+	networkFile string
+	// metres of road in a cell when the network file is an OpenStreetMap extract
+	osmCellLength float64
+
+	// on a ring road cars leaving the last cell of a lane come back in on its first cell, so the number of cars
+	// stays the same until ringDuration. Detectors and ring measurements start after warmUp
+	ringRoad     bool
+	ringDuration float64
+	warmUp       float64
+
+	// an open system creates cars as they arrive instead of emptying a pool. It stops at the horizon or after
+	// maxExits cars left, a horizon also ends other runs early
+	openSystem bool
+	horizon    float64
+	maxExits   int
+
+	// cars coming in through an entry street of the OD matrix draw an exit street to head for from its row,
+	// odMatrix[origin][destination] being the weight, and follow the shortest route there. Dynamic routing plans the
+	// route again at every intersection, counting congestionCost cells for every car per lane on the way
+	odMatrix       map[string]map[string]float64
+	dynamicRouting bool
+	congestionCost float64
+
+	// entries follow their demand profile instead of inAlpha. arrivalProfile is a stepped profile for every entry
+	// without one of its own
+	arrivalProfile []ArrivalStep
+	demandProfiles []DemandProfile
+
+	// closures shut cells during part of the run. Cars merge out of a closed lane, switching lanes like any other car
+	// within mergeDistance cells of the closure and having to right before it
+	closures      []Closure
+	mergeDistance int
+
+	// signalControl puts traffic signals on the intersections, only on the signalized nodes of a network. Fixed
+	// signals follow their plan, the one in signalPlans for their intersection or else the one without a cell.
+	// Actuated and max pressure signals pick their phases from the queues counted on the plan's detector cells
+	signalControl string
+	signalPlans   []SignalPlan
+
+	// intersectionControl runs the intersections without a signal by stop or yield signs, instead of letting cars in
+	// with probEnteringIntersection. At an all way stop every car stops and they go first come first served, at a two
+	// way stop the cars off the majorRoad stop and wait for the major road to be clear, and at a yield they only stop
+	// when major road cars are in the intersection or within yieldGap cells of it
+	intersectionControl string
+	majorRoad           string
+	yieldGap            int
+
+	// emergencyVehicles enter on their schedule ahead of the queued cars and drive at emergencySpeed. Signals they
+	// are within preemptDistance cells of turn green for them, stop and yield signs let them through and the cars
+	// ahead of them move to another lane when there is one. Accident cells still hold them up
+	emergencyVehicles []EmergencyDispatch
+	emergencySpeed    float64
+	preemptDistance   int
+
+	// responders are the crews that clear accidents, without any an accident clears on its own. The nearest free
+	// crew drives to it at responderSpeed cells per second from where it cleared the last one, and the clearing at
+	// carRemovalRate starts once it got there. The crews start on the responderStations in turn, or in the middle of
+	// the grid
+	responders        int
+	responderStations [][2]int
+	responderSpeed    float64
+
 	// scales poisson rate by certain amount
 }
 
@@ -359,6 +557,11 @@ func DefaultGeneralLaneConfig() *GeneralLaneSimulationConfig {
 	config.CarDistributionType = constantDistribution
 	config.reSampleSpeedEveryClk = false
 
+	// a zero rate means the clock never fires
+	config.carRemovalRate = 1
+	config.crossWalkSlowDownRate = 1
+	config.slowDownSpeed = 1
+
 	config.parkingEnabled = false
 	config.probEnteringIntersection = 1
 	config.parkingTimeRate = 1
@@ -368,33 +571,80 @@ func DefaultGeneralLaneConfig() *GeneralLaneSimulationConfig {
 	config.intersectionAccidentProb = 0
 	config.removeUnlikelyEvents = true
 	config.unlikelyCutoff = 0.05
+	config.wallClockSpeed = 0
+	config.detectorWindow = 10
+	config.blockLength = 4
+	config.osmCellLength = osmDefaultCellLength
+	config.congestionCost = 2
+	config.mergeDistance = 3
+	config.majorRoad = majorHorizontal
+	config.yieldGap = 2
+	config.emergencySpeed = 2
+	config.preemptDistance = 5
+	config.responderSpeed = 1
+	config.seed = time.Now().UnixNano()
 	return &config
 }
 
-// GeneralLaneSimulation handles a general simulation with n horizontal lanes and n vertical lanes and intersections
+// GeneralLaneSimulation handles a general simulation with horizontal and vertical streets of n lanes and their intersections
 type GeneralLaneSimulation struct {
 	Simulation
-	Locations        [][]*StatefulLocation
-	InHorizontalRoot *StatefulLocation
-	InVerticalRoot   *StatefulLocation
+	Locations [][]*StatefulLocation
 
-	OutHorizontalRoot *StatefulLocation
-	OutVerticalRoot   *StatefulLocation
+	streets       []*Street   // horizontal ones first
+	rowStreets    [][]*Street // the streets along every row
+	columnStreets [][]*Street
+	rootNames     map[*StatefulLocation]string // what every root is called, built with the streets
+	twoWay        bool // some streets have opposing lanes, so intersections have four approaches
+	ringLaps      int  // cars that went round a ring road since the warm up
 
 	config *GeneralLaneSimulationConfig
 
-	moveCarsIn  chan Direction
-	moveCarsOut chan Direction
+	scheduler *Scheduler
+	random    *SimulationRandom
+	restored  bool // continues from a snapshot
+	replaying bool // redraws a trace instead of simulating
+	tracer    *TraceWriter
+	detectors []*LoopDetector
+
+	demand     map[*Street]*demandCurve // arrival rates of the entries that follow a demand profile
+	routeTurns map[*Street][]streetTurn // the streets cars can turn onto from each street, with an OD matrix
 
-	carClock       chan *SmartCar
-	crossWalkClock chan *SlowCar
+	closureCells [][]*StatefulLocation // the cells of every closure
+	closedCells  map[*StatefulLocation]*closedCell
 
-	accidentChan chan *Accident
+	signals  []*Signal
+	signalAt map[*StatefulLocation]*Signal // the signal of every intersection cell that has one
 
-	parkingReturn chan *Parking
+	priorityIntersections []*PriorityIntersection
+	priorityAt            map[*StatefulLocation]*PriorityIntersection // the intersection of every intersection cell
+
+	dispatches      []emergencyDispatch
+	emergencyPlaced int // emergency vehicles that got onto the grid
+	emergencyOut    int // and the ones of them in the out roots
+
+	crews            []*responderCrew
+	waitingAccidents []*Accident // for a crew to be free, in the order they happened
+	responderStats   ResponderStats
+
+	// anchors the simulated clock to the wall clock when pacing is enabled
+	pacingStart    time.Time
+	pacingSimStart float64
+	playbackSpeed  float64
+
+	// viewer controls, only touched by the simulation loop except for paused and simulatedTime
+	controlChan   chan SimulationControl
+	paused        bool
+	stepsLeft     int
+	pauseAt       float64
+	pauseReply    chan error // answered the next time the loop pauses
+	simulatedTime float64
 
 	runningSimulationLock sync.Mutex
 	numAccidents          int
+	conflicts             ConflictCounts
+	movements             ApproachMovements
+	priority              PriorityCounts
 }
 
 func (sim *GeneralLaneSimulation) isRunningSimulation() bool {
@@ -416,13 +666,17 @@ type JsonGeneralLocation struct {
 
 type JsonGeneralLaneSimulation struct {
 	Locations [][]JsonGeneralLocation `json:"locations"`
+	Time      float64                 `json:"time"`
+	Paused    bool                    `json:"paused"`
+	Signals   []JsonSignal            `json:"signals,omitempty"`
 }
 
 func (sim *GeneralLaneSimulation) getJsonRepresentation() JsonGeneralLaneSimulation {
-	jsonGen := JsonGeneralLaneSimulation{Locations: make([][]JsonGeneralLocation, sim.config.sizeOfLane)}
-	for i := 0; i < sim.config.sizeOfLane; i++ {
-		jsonGen.Locations[i] = make([]JsonGeneralLocation, sim.config.sizeOfLane)
-		for j := 0; j < sim.config.sizeOfLane; j++ {
+	jsonGen := JsonGeneralLaneSimulation{Locations: make([][]JsonGeneralLocation, sim.numRows()), Time: sim.getSimulatedTime(), Paused: sim.isPaused()}
+	jsonGen.Signals = sim.jsonSignals()
+	for i := 0; i < sim.numRows(); i++ {
+		jsonGen.Locations[i] = make([]JsonGeneralLocation, sim.numColumns())
+		for j := 0; j < sim.numColumns(); j++ {
 			jsonGen.Locations[i][j] = JsonGeneralLocation{}
 			jsonGen.Locations[i][j].Cars = make(map[string]SmartCar, 0)
 			jsonGen.Locations[i][j].LocationState = int(sim.Locations[i][j].getLocationState())
@@ -435,7 +689,7 @@ func (sim *GeneralLaneSimulation) getJsonRepresentation() JsonGeneralLaneSimulat
 				waitingTime := math.Floor(v.WaitingTime * 100)/100
 				speed := math.Floor(v.Speed * 100)/100
 				v.smartCarLock.Unlock()
-				jsonGen.Locations[i][j].Cars[k] = SmartCar{ID: v.ID, WaitingTime: waitingTime, Speed: speed}
+				jsonGen.Locations[i][j].Cars[k] = SmartCar{ID: v.ID, WaitingTime: waitingTime, Speed: speed, Emergency: v.Emergency}
 			}
 			loc.locationLock.Unlock()
 
@@ -448,37 +702,35 @@ func (sim *GeneralLaneSimulation) getJsonRepresentation() JsonGeneralLaneSimulat
 func (sim *GeneralLaneSimulation) String() (string) {
 	var b strings.Builder
 	fmt.Fprintf(&b, RightPad2Len("", "-", 20)+" \n")
-
-	fmt.Fprintf(&b, " horizontalInBin ")
-	for car := range sim.InHorizontalRoot.Cars {
-		fmt.Fprintf(&b, car+" ")
-	}
-	fmt.Fprintf(&b, " horizontalOutBin ")
-	for car := range sim.OutHorizontalRoot.Cars {
-		fmt.Fprintf(&b, car+" ")
+	fmt.Fprintf(&b, " time %.2f", sim.getSimulatedTime())
+	if sim.isPaused() {
+		fmt.Fprintf(&b, " paused")
 	}
 	fmt.Fprintf(&b, "\n")
-	fmt.Fprintf(&b, " verticalInBin ")
-	for car := range sim.InVerticalRoot.Cars {
-		fmt.Fprintf(&b, car+" ")
-	}
-	fmt.Fprintf(&b, " verticalOutBin ")
-	for car := range sim.OutVerticalRoot.Cars {
-		fmt.Fprintf(&b, car+" ")
+
+	for _, street := range sim.streets {
+		fmt.Fprintf(&b, " "+street.name()+"InBin ")
+		for _, car := range street.InRoot.sortedCars() {
+			fmt.Fprintf(&b, car.ID+" ")
+		}
+		fmt.Fprintf(&b, " "+street.name()+"OutBin ")
+		for _, car := range street.OutRoot.sortedCars() {
+			fmt.Fprintf(&b, car.ID+" ")
+		}
+		fmt.Fprintf(&b, "\n")
 	}
 
-	fmt.Fprintf(&b, "\n")
-	for i := 0; i < sim.config.sizeOfLane; i++ {
-		for j := 0; j < sim.config.sizeOfLane; j++ {
+	for i := 0; i < sim.numRows(); i++ {
+		for j := 0; j < sim.numColumns(); j++ {
 			loc := sim.Locations[i][j]
-			loc.locationLock.Lock()
-			if len(loc.Cars) == 0 {
+			car := loc.getCar(false) // takes the location lock itself
+			if car == nil && loc.getLocationState() == ClosedLocationState {
+				fmt.Fprintf(&b, RightPad2Len("", "#", 8)+" ")
+			} else if car == nil {
 				fmt.Fprintf(&b, RightPad2Len("", "_", 8)+" ")
 			} else {
-				car := loc.getCar(false)
 				fmt.Fprintf(&b, RightPad2Len(car.ID, " ", 8)+" ")
 			}
-			loc.locationLock.Unlock()
 		}
 		fmt.Fprintf(&b, "\n")
 	}
@@ -510,17 +762,13 @@ func (sim *GeneralLaneSimulation) verticalIndexRange() (int, int) {
 }
 
 func (sim *GeneralLaneSimulation) allCarsMovedIn() (bool) {
-	sim.OutHorizontalRoot.locationLock.Lock()
-	sim.OutVerticalRoot.locationLock.Lock()
-
-	defer sim.OutHorizontalRoot.locationLock.Unlock()
-	defer sim.OutVerticalRoot.locationLock.Unlock()
-
-	if sim.config.numHorizontalLanes > 0 && len(sim.OutHorizontalRoot.Cars) != sim.config.sizeOfLane {
-		return false
-	}
-	if sim.config.numVerticalLanes > 0 && len(sim.OutVerticalRoot.Cars) != sim.config.sizeOfLane {
-		return false
+	for _, street := range sim.streets {
+		street.OutRoot.locationLock.Lock()
+		numOut := len(street.OutRoot.Cars)
+		street.OutRoot.locationLock.Unlock()
+		if numOut != sim.laneLength(street.Direction) {
+			return false
+		}
 	}
 	return true
 }
@@ -537,126 +785,124 @@ func (loc *StatefulLocation) shouldCheckForMovingIn(checkLocationType CheckLocat
 	return checkLocationType == Open && loc.isEmpty() || checkLocationType == NotOpen && !loc.isEmpty() || checkLocationType == AllLocationTypes
 }
 
+// getHorizontalLanesAtIndex gives the lanes of every horizontal street at column index
 func (sim *GeneralLaneSimulation) getHorizontalLanesAtIndex(index int, checkLocationType CheckLocationType) []*StatefulLocation {
 	openLanes := make([]*StatefulLocation, 0)
-	low, high := sim.horizontalIndexRange()
-	for i := low; i <= high; i++ {
-		loc := sim.Locations[i][index]
-		if loc.shouldCheckForMovingIn(checkLocationType) {
-			openLanes = append(openLanes, loc)
-		}
+	for _, street := range sim.streetsGoing(Horizontal) {
+		openLanes = append(openLanes, sim.streetLanesAtIndex(street, index, checkLocationType)...)
 	}
 	return openLanes
 }
 
+// getVerticalLanesAtIndex gives the lanes of every vertical street at row index
 func (sim *GeneralLaneSimulation) getVerticalLanesAtIndex(index int, checkLocationType CheckLocationType) []*StatefulLocation {
 	openLanes := make([]*StatefulLocation, 0)
-	low, high := sim.verticalIndexRange()
-	for i := low; i <= high; i++ {
-		loc := sim.Locations[index][i]
-		if loc.shouldCheckForMovingIn(checkLocationType) {
-			openLanes = append(openLanes, loc)
-		}
+	for _, street := range sim.streetsGoing(Vertical) {
+		openLanes = append(openLanes, sim.streetLanesAtIndex(street, index, checkLocationType)...)
 	}
 	return openLanes
 }
 
 func initMultiLaneSimulation(config *GeneralLaneSimulationConfig) (*GeneralLaneSimulation, error) {
-	simulation := GeneralLaneSimulation{config: config}
-	sizeOfLane := simulation.config.sizeOfLane
-	numVerticalLanes := simulation.config.numVerticalLanes
-	numHorizontalLanes := simulation.config.numHorizontalLanes
-	if sizeOfLane-2 <= 0 {
-		return nil, errors.New("The lane must be 2 spots")
+	simulation := GeneralLaneSimulation{config: config, random: newSimulationRandom(config.seed)}
+	layout, err := simulation.layout()
+	if err != nil {
+		return nil, err
+	}
+	if err := config.validateTurnRatios(); err != nil {
+		return nil, err
 	}
-	if !(sizeOfLane > numVerticalLanes && sizeOfLane > numHorizontalLanes) {
-		return nil, errors.New("The number of vertical/horizontal lanes cannot be more than size of lane")
+	if err := config.validateOpenSystem(); err != nil {
+		return nil, err
 	}
-	locations := make([][] *StatefulLocation, sizeOfLane)
+	locations := make([][] *StatefulLocation, layout.rows)
 	for i := range locations {
-		locations[i] = make([]*StatefulLocation, sizeOfLane)
-		for j := 0; j < sizeOfLane; j++ {
+		locations[i] = make([]*StatefulLocation, layout.columns)
+		for j := 0; j < layout.columns; j++ {
 			locations[i][j] = &StatefulLocation{LocationState: Empty, Cars: make(map[string]*SmartCar), locationLock: sync.Mutex{}}
 			locations[i][j].X = i
 			locations[i][j].Y = j
 		}
 	}
 
-	// Initialize horizontal locations
-	horizontalStartIndex, horizontalEndIndex := simulation.horizontalIndexRange()
-	for i := horizontalStartIndex; i <= horizontalEndIndex; i++ {
-		for j := 0; j < sizeOfLane; j++ {
-			locations[i][j].LocationState = LaneLoc
+	// Initialize horizontal locations, both ways
+	for _, street := range layout.streets {
+		if !street.direction.isHorizontal() {
+			continue
+		}
+		for _, i := range street.lanes {
+			for _, j := range indexRange(street.span()) {
+				locations[i][j].LocationState = LaneLoc
+			}
 		}
-
-	}
-
-	if numHorizontalLanes > 0 {
-		horizontalRoot := StatefulLocation{Cars: make(map[string]*SmartCar, 0)}
-		horizontalRoot.addNCars(simulation.config.numHorizontalCars,
-			Horizontal,
-			simulation.config.carMovementP,
-			simulation.config.CarDistributionType,
-			simulation.config.carSpeedUniformEndRange,
-			simulation.config.carClock,
-			simulation.config.removeUnlikelyEvents,
-			simulation.config.unlikelyCutoff)
-		simulation.InHorizontalRoot = &horizontalRoot
-		simulation.OutHorizontalRoot = &StatefulLocation{Cars: make(map[string]*SmartCar, 0), X: -1, Y: -1}
 	}
 
-	// Initialize vertical locations
-	verticalStartIndex, verticalEndIndex := simulation.verticalIndexRange()
-	for i := 0; i < sizeOfLane; i++ {
-		for j := verticalStartIndex; j <= verticalEndIndex; j++ {
-			if locations[i][j].LocationState == LaneLoc {
-				locations[i][j].LocationState = Intersection
-				// If it is already on the horizontal path then send in update
-			} else {
-				locations[i][j].LocationState = LaneLoc
+	// Initialize vertical locations, both ways
+	for _, street := range layout.streets {
+		if street.direction.isHorizontal() {
+			continue
+		}
+		for _, i := range indexRange(street.span()) {
+			for _, j := range street.lanes {
+				if locations[i][j].LocationState == LaneLoc {
+					locations[i][j].LocationState = Intersection
+					// If it is already on the horizontal path then send in update
+				} else {
+					locations[i][j].LocationState = LaneLoc
+				}
 			}
 		}
 	}
 	simulation.Locations = locations
-
-	if numVerticalLanes > 0 {
-		verticalRoot := StatefulLocation{Cars: make(map[string]*SmartCar, 0)}
-		verticalRoot.addNCars(simulation.config.numVerticalCars,
-			Vertical,
-			simulation.config.carMovementP,
-			simulation.config.CarDistributionType,
-			simulation.config.carSpeedUniformEndRange, simulation.config.carClock, simulation.config.removeUnlikelyEvents,
-			simulation.config.unlikelyCutoff)
-		simulation.InVerticalRoot = &verticalRoot
-		simulation.OutVerticalRoot = &StatefulLocation{Cars: make(map[string]*SmartCar, 0), X: -1, Y: -1}
+	simulation.buildStreets(layout)
+	if err := simulation.validateRing(); err != nil {
+		return nil, err
+	}
+	if err := simulation.loadDemand(); err != nil {
+		return nil, err
+	}
+	if err := simulation.loadRoutes(); err != nil {
+		return nil, err
+	}
+	if err := simulation.loadClosures(); err != nil {
+		return nil, err
+	}
+	if err := simulation.loadSignals(layout); err != nil {
+		return nil, err
+	}
+	if err := simulation.loadPriorityRules(); err != nil {
+		return nil, err
+	}
+	if err := simulation.loadEmergencies(); err != nil {
+		return nil, err
+	}
+	if err := simulation.loadResponders(); err != nil {
+		return nil, err
 	}
 
-	// Note: Decided to only use one position for parking
+	// Note: Decided to only use one position for parking, on the drivers' right of every street
 	// Initialize ParkingLoc Locations
 	if simulation.config.parkingEnabled {
-		for i := 0; i < sizeOfLane; i++ {
-			//verticalParkingStart := verticalStartIndex - 1
-			verticalParkingEnd := verticalEndIndex + 1
-			//simulation.addParkingIfInBounds(verticalParkingStart, i)
-			simulation.addParkingIfInBounds(verticalParkingEnd, i)
-
-			//horizontalParkingStart := horizontalStartIndex - 1
-			horizontalParkingEnd := horizontalEndIndex + 1
-			//simulation.addParkingIfInBounds(i, horizontalParkingStart)
-			simulation.addParkingIfInBounds(i, horizontalParkingEnd)
+		for _, street := range simulation.streets {
+			low, high := street.span()
+			for i := low; i <= high; i++ {
+				if street.Direction.isHorizontal() {
+					simulation.addParkingIfInBounds(street.parkingLane(), i)
+				} else {
+					simulation.addParkingIfInBounds(i, street.parkingLane())
+				}
+			}
 		}
 	}
 
-	simulation.moveCarsIn = make(chan Direction)
-	simulation.moveCarsOut = make(chan Direction)
-
-	simulation.carClock = make(chan *SmartCar)
-	simulation.accidentChan = make(chan *Accident)
-	simulation.parkingReturn = make(chan *Parking)
-	simulation.crossWalkClock = make(chan *SlowCar)
+	simulation.scheduler = newScheduler()
 
 	simulation.setRunningSimulation(false)
 	simulation.drawUpdateChan = make(chan bool)
+	simulation.cancelSimulation = make(chan bool, 1)
+	simulation.controlChan = make(chan SimulationControl, 16)
+	simulation.playbackSpeed = config.wallClockSpeed
+	simulation.pauseAt = math.Inf(1)
 
 	simulation.runningSimulationLock = sync.Mutex{}
 
@@ -670,7 +916,7 @@ func normalize(arr []float64, totalCount float64) []float64 {
 	return weights
 }
 func (sim *GeneralLaneSimulation) addParkingIfInBounds(i int, j int) {
-	if !isInBounds(i, sim.config.sizeOfLane) || !isInBounds(j, sim.config.sizeOfLane) {
+	if !sim.inGrid(i, j) {
 		return
 	}
 	location := sim.Locations[i][j]
@@ -685,16 +931,16 @@ func isInBounds(index int, size int) bool {
 }
 func (sim *GeneralLaneSimulation) countNumCarsNearby(loc *StatefulLocation) int {
 	count := 1
-	if isInBounds(loc.X+1, sim.config.sizeOfLane) && !sim.Locations[loc.X+1][loc.Y].isEmpty() {
+	if sim.inGrid(loc.X+1, loc.Y) && !sim.Locations[loc.X+1][loc.Y].isEmpty() {
 		count++
 	}
-	if isInBounds(loc.X-1, sim.config.sizeOfLane) && !sim.Locations[loc.X-1][loc.Y].isEmpty() {
+	if sim.inGrid(loc.X-1, loc.Y) && !sim.Locations[loc.X-1][loc.Y].isEmpty() {
 		count++
 	}
-	if isInBounds(loc.Y+1, sim.config.sizeOfLane) && !sim.Locations[loc.X-1][loc.Y+1].isEmpty() {
+	if sim.inGrid(loc.X, loc.Y+1) && !sim.Locations[loc.X][loc.Y+1].isEmpty() {
 		count++
 	}
-	if isInBounds(loc.Y-1, sim.config.sizeOfLane) && !sim.Locations[loc.X-1][loc.Y-1].isEmpty() {
+	if sim.inGrid(loc.X, loc.Y-1) && !sim.Locations[loc.X][loc.Y-1].isEmpty() {
 		count++
 	}
 	return count
@@ -704,16 +950,16 @@ func (sim *GeneralLaneSimulation) selectBasedOnTraffic(locs []*StatefulLocation,
 	totalCount := 0.0
 	var i int
 	for _, item := range locs {
-		if direction == Horizontal {
+		if direction.isHorizontal() {
 			i = item.X
 		} else {
 			i = item.Y
 		}
 
 		count := 0.0
-		for j := 0; j < sim.config.sizeOfLane; j++ {
-			if direction == Horizontal && !sim.Locations[i][j].isEmpty() ||
-				direction == Vertical && !sim.Locations[j][i].isEmpty() {
+		for j := 0; j < sim.laneLength(direction); j++ {
+			if direction.isHorizontal() && !sim.Locations[i][j].isEmpty() ||
+				!direction.isHorizontal() && !sim.Locations[j][i].isEmpty() {
 				count++
 			}
 		}
@@ -721,11 +967,14 @@ func (sim *GeneralLaneSimulation) selectBasedOnTraffic(locs []*StatefulLocation,
 
 		totalCount += count
 	}
+	if totalCount == 0 {
+		return locs[sim.random.laneChoice.Intn(len(locs))] // no traffic to weigh by
+	}
 	weights = normalize(weights, totalCount)
 
 	w := sampleuv.NewWeighted(
 		weights,
-		nil,
+		sim.random.laneChoice.source,
 	)
 
 	i, _ = w.Take()
@@ -734,435 +983,649 @@ func (sim *GeneralLaneSimulation) selectBasedOnTraffic(locs []*StatefulLocation,
 
 func (sim *GeneralLaneSimulation) RandomlyPickLocation(lanes []*StatefulLocation, direction Direction, choice LaneChoice) *StatefulLocation {
 	if choice == uniformLaneChoice {
-		return lanes[rand.Intn(len(lanes))]
+		return lanes[sim.random.laneChoice.Intn(len(lanes))]
 	}
 	return sim.selectBasedOnTraffic(lanes, direction)
 }
 
 func (singleSim *GeneralLaneSimulation) close() {
 	singleSim.setRunningSimulation(false)
+	close(singleSim.drawUpdateChan) // wakes up anyone still waiting for a frame
+}
+
+// cancel asks the running simulation to stop without blocking the caller
+func (sim *GeneralLaneSimulation) cancel() {
+	select {
+	case sim.cancelSimulation <- true:
+	default:
+	}
 }
 
-// RunSingleLaneSimulation runs the simulation such that all the cars from bin 0 move to the last bin
+// notifyDraw hands a frame to the viewer. It gives up if the simulation gets cancelled while waiting
+func (sim *GeneralLaneSimulation) notifyDraw() {
+	select {
+	case sim.drawUpdateChan <- true:
+	case <-sim.cancelSimulation:
+		sim.setRunningSimulation(false)
+	}
+}
+
+// RunGeneralSimulation runs the simulation such that all the cars from the in roots move to the out roots
 func RunGeneralSimulation(simulation *GeneralLaneSimulation) {
 	defer simulation.close()
 	simulation.setRunningSimulation(true)
 
-	moveCarsIn := simulation.moveCarsIn
-	moveCarsOut := simulation.moveCarsOut
-
-	carClock := simulation.carClock
-	accidentChan := simulation.accidentChan
-	parkingChan := simulation.parkingReturn
-	crossWalkClock := simulation.crossWalkClock
+	simulation.resetPacing()
 
-	drawUpdateChan := simulation.drawUpdateChan
+	if !simulation.restored && simulation.config.ringRoad {
+		simulation.fillRing()
+	} else if !simulation.restored { // a restored simulation already has its bin clocks pending
+		for _, street := range simulation.streets {
+			if street.entry {
+				simulation.scheduleEntry(street)
+			}
+			if street.exit {
+				simulation.moveCarsThroughBinsDirection(carOutEvent, street, simulation.config.outBeta)
+			}
+		}
+	}
+	if !simulation.restored {
+		simulation.scheduleClosures()
+		simulation.scheduleSignals()
+		simulation.scheduleEmergencies()
+	}
+	log.Println("starting simulation with seed", simulation.config.seed)
+	if err := simulation.openTrace(); err != nil {
+		log.Println("unable to open trace", err)
+	}
+	defer simulation.closeTrace()
+	if simulation.scheduler.Now() >= simulation.config.warmUp {
+		if len(simulation.detectors) == 0 { // a restored run keeps measuring with the detectors of its snapshot
+			simulation.installDetectors()
+		}
+	} else if !simulation.restored {
+		simulation.scheduler.schedule(simulation.config.warmUp, &SimEvent{Type: warmUpEvent})
+	}
+	defer simulation.finishDetectors()
 
-	go moveCarsThroughBinsDirection(moveCarsIn, Horizontal, simulation, simulation.config.inAlpha,
-		simulation.config.removeUnlikelyEvents, simulation.config.unlikelyCutoff)
-	go moveCarsThroughBinsDirection(moveCarsOut, Horizontal, simulation, simulation.config.outBeta,
-		simulation.config.removeUnlikelyEvents, simulation.config.unlikelyCutoff)
+	simulation.runLoop(func() (float64, bool) {
+		if simulation.isCompleted() {
+			return 0, false
+		}
+		event := simulation.scheduler.peek()
+		if event == nil {
+			return 0, false
+		}
+		if end := simulation.endTime(); event.Time > end {
+			simulation.scheduler.advanceTo(end)
+			simulation.setSimulatedTime(end)
+			return 0, false
+		}
+		return event.Time, true
+	}, func() {
+		event := simulation.scheduler.next()
+		simulation.setSimulatedTime(event.Time)
+		simulation.processEvent(event)
+	})
+}
 
-	go moveCarsThroughBinsDirection(moveCarsIn, Vertical, simulation, simulation.config.inAlpha,
-		simulation.config.removeUnlikelyEvents, simulation.config.unlikelyCutoff)
-	go moveCarsThroughBinsDirection(moveCarsOut, Vertical, simulation, simulation.config.outBeta,
-		simulation.config.removeUnlikelyEvents, simulation.config.unlikelyCutoff)
-	log.Println("starting simulation")
+// runLoop handles the viewer controls and pacing around the events. peek gives the time of the next event,
+// or false once there are none left, and process handles that event
+func (simulation *GeneralLaneSimulation) runLoop(peek func() (float64, bool), process func()) {
 	for {
 		if !simulation.isRunningSimulation() {
 			return
 		}
 
-		if simulation.isCompleted() {
-			return
+		if simulation.isPaused() {
+			simulation.waitWhilePaused()
+			continue
 		}
 
 		select {
-		case carInDirection := <-moveCarsIn:
-			if !simulation.isRunningSimulation() {
-				return
-			}
-			openLanes := make([]*StatefulLocation, 0)
-			var root *StatefulLocation
-			if carInDirection == Horizontal {
-				openLanes = simulation.getHorizontalLanesAtIndex(0, Open)
-				root = simulation.InHorizontalRoot
-			} else if carInDirection == Vertical {
-				openLanes = simulation.getVerticalLanesAtIndex(0, Open)
-				root = simulation.InVerticalRoot
-			}
+		case <-simulation.cancelSimulation:
+			simulation.setRunningSimulation(false)
+			return
+		case control := <-simulation.controlChan:
+			simulation.applyControl(control)
+			continue
+		default:
+		}
+
+		eventTime, ok := peek()
+		if !ok {
+			return
+		}
+		if eventTime > simulation.pauseAt {
+			simulation.scheduler.advanceTo(simulation.pauseAt)
+			simulation.pause()
+			continue
+		}
+		if !simulation.waitForWallClock(eventTime) {
+			continue
+		}
+		process()
 
-			if len(openLanes) == 0 {
-				break
+		if simulation.stepsLeft > 0 {
+			simulation.stepsLeft--
+			if simulation.stepsLeft == 0 {
+				simulation.pause()
 			}
+		}
+	}
+}
 
-			chosenLoc := simulation.RandomlyPickLocation(openLanes, carInDirection, simulation.config.inLaneChoice)
+func (simulation *GeneralLaneSimulation) processEvent(event *SimEvent) {
+	switch event.Type {
+	case carInEvent:
+		if simulation.config.openSystem {
+			simulation.carArrived(event.street)
+		} else {
+			simulation.moveCarIn(event.street)
+		}
+		simulation.scheduleEntry(event.street)
+	case carOutEvent:
+		simulation.moveCarOut(event.street)
+		simulation.moveCarsThroughBinsDirection(carOutEvent, event.street, simulation.config.outBeta)
+	case carClockEvent:
+		if event.car.clock == event {
+			event.car.clock = nil
+		}
+		simulation.carClockFired(event.car, event.carLoc)
+	case accidentEvent:
+		simulation.resolveAccident(event.accident)
+	case parkingEvent:
+		simulation.returnFromParking(event.parking)
+	case warmUpEvent:
+		simulation.endWarmUp()
+	case closureStartEvent:
+		simulation.startClosure(event.closure)
+	case closureEndEvent:
+		simulation.endClosure(event.closure)
+	case signalEvent:
+		simulation.changeSignal(event.signal)
+	case emergencyEvent:
+		simulation.dispatchEmergency(event.dispatch)
+	case responderEvent:
+		simulation.responderArrived(event.accident)
+	case slowCarEvent:
+		slowCar := event.slowCar
+		slowCar.car.setSlowingDown(false)
+		slowCar.car.setSpeed(slowCar.oldSpeed)
+		simulation.stopTripInterval(slowCar.car, slowedInterval)
+		simulation.traceCar(traceSpeedRestored, slowCar.car, nil, nil, "")
+		if slowCar.car.stalledLoc != nil {
+			simulation.MoveSmartCarInLane(slowCar.car, slowCar.car.stalledLoc) // the car was stopped, get it going again
+		}
+	}
+}
 
-			currCar := root.getCar(true) // allows for picking any car from the pool
-			if currCar == nil {
-				break
-			}
+// moveCarIn places a car from the in root of the street on the first cell of one of its lanes
+func (simulation *GeneralLaneSimulation) moveCarIn(street *Street) {
+	openLanes := simulation.streetLanesAtIndex(street, street.First, Open)
+	root := street.InRoot
 
-			chosenLoc.addCar(currCar)
-			log.Println("placing car ", currCar.ID, "at", currCar.X, currCar.Y)
-			go MoveSmartCarInLane(currCar, carClock, chosenLoc, simulation.config.removeUnlikelyEvents, simulation.config.unlikelyCutoff)
-			drawUpdateChan <- true
-			break
-		case carOutDirection := <-moveCarsOut:
-			if !simulation.isRunningSimulation() {
-				return
-			}
-			openLanes := make([]*StatefulLocation, 0)
-			var root *StatefulLocation
-			lastIndex := simulation.config.sizeOfLane - 1
-			if carOutDirection == Horizontal {
-				openLanes = simulation.getHorizontalLanesAtIndex(lastIndex, NotOpen)
-				root = simulation.OutHorizontalRoot
-			} else if carOutDirection == Vertical {
-				openLanes = simulation.getVerticalLanesAtIndex(lastIndex, NotOpen)
-				root = simulation.OutVerticalRoot
-			}
+	if len(openLanes) == 0 {
+		return
+	}
 
-			if len(openLanes) == 0 {
-				break
-			}
+	chosenLoc := simulation.RandomlyPickLocation(openLanes, street.Direction, simulation.config.inLaneChoice)
 
-			chosenLoc := simulation.RandomlyPickLocation(openLanes, carOutDirection, simulation.config.outLaneChoice)
-			currCar := chosenLoc.getCar(true) // allows for removing any car from the pool
+	var currCar *SmartCar
+	if simulation.config.openSystem {
+		currCar = root.firstArrived() // the entry is a queue
+	} else {
+		currCar = root.pickCar(simulation.random.arrivals) // allows for picking any car from the pool
+	}
+	if currCar == nil {
+		return
+	}
 
-			if currCar == nil {
-				break
-			}
+	chosenLoc.addCar(currCar)
+	simulation.enterTrip(currCar, street)
+	simulation.traceCar(traceCarPlaced, currCar, root, chosenLoc, "")
+	simulation.MoveSmartCarInLane(currCar, chosenLoc)
+	simulation.notifyDraw()
+}
 
-			root.addCar(currCar)
-			log.Println("took out car", currCar.ID, currCar.X, currCar.Y)
-			drawUpdateChan <- true
+// moveCarOut takes a car off the last cell of one of the street's lanes into its out root
+func (simulation *GeneralLaneSimulation) moveCarOut(street *Street) {
+	openLanes := simulation.streetLanesAtIndex(street, street.Last, NotOpen)
+	root := street.OutRoot
 
-			break
+	if len(openLanes) == 0 {
+		return
+	}
 
-		case car := <-carClock:
-			log.Println("recieved", car.ID)
-			if !simulation.isRunningSimulation() {
-				return
-			}
-			if car == nil {
-				break
-			}
-			car.smartCarLock.Lock()
-			x := car.X
-			y := car.Y
-			direction := car.Direction
-			car.smartCarLock.Unlock()
-
-			if x == -1 || y == -1 ||
-				!isInBounds(x, simulation.config.sizeOfLane) ||
-				!isInBounds(y, simulation.config.sizeOfLane) {
-				log.Println("invalid bounds", car.ID)
-				break
-			}
-			currLoc := simulation.Locations[x][y]
+	chosenLoc := simulation.RandomlyPickLocation(openLanes, street.Direction, simulation.config.outLaneChoice)
+	currCar := chosenLoc.pickCar(simulation.random.arrivals) // allows for removing any car from the pool
 
-			var nextLoc *StatefulLocation
-			switchLanes := UniformRand() < simulation.config.probSwitchingLanes
+	if currCar == nil {
+		return
+	}
 
-			if direction == Horizontal {
-				if y+1 == simulation.config.sizeOfLane {
-					log.Println("at the end", car.ID)
-					break
-				}
-				if !switchLanes {
-					nextLoc = simulation.Locations[x][y+1]
-				} else {
-					log.Println("attempting lane switch", car.ID)
-					var openLanes []*StatefulLocation
-					openLanes = simulation.getHorizontalLanesAtIndex(y+1, AllLocationTypes)
-					nextLoc = simulation.RandomlyPickLocation(openLanes, direction, simulation.config.laneSwitchChoice) // TODO consider whether the car can pick its own position to switch to
-				}
-			} else if direction == Vertical {
-				if x+1 == simulation.config.sizeOfLane {
-					log.Println("at the end", car.ID)
-					break
-				}
-				if !switchLanes {
-					nextLoc = simulation.Locations[x+1][y]
-				} else {
-					log.Println("attempting lane switch", car.ID)
-					var openLanes []*StatefulLocation
-					openLanes = simulation.getVerticalLanesAtIndex(x+1, AllLocationTypes)
-					nextLoc = simulation.RandomlyPickLocation(openLanes, direction, simulation.config.laneSwitchChoice)
-				}
+	root.addCar(currCar)
+	simulation.exitTrip(currCar, street, false)
+	simulation.traceCar(traceCarExited, currCar, chosenLoc, root, "")
+	simulation.notifyDraw()
+}
+
+// carClockFired checks that the car is still where its clock was started before trying to move it
+func (simulation *GeneralLaneSimulation) carClockFired(car *SmartCar, carLoc *StatefulLocation) {
+	car.smartCarLock.Lock()
+	x := car.X
+	y := car.Y
+	car.smartCarLock.Unlock()
+
+	if x == -1 || y == -1 || x != carLoc.X || y != carLoc.Y {
+		return
+	}
+
+	if carLoc.getLocationState() == AccidentLocationState {
+		return // if Accident ignore the car
+	}
+
+	if simulation.random.carClock.UniformRand() < car.probMovement {
+		simulation.moveCar(car)
+		return
+	}
+	simulation.MoveSmartCarInLane(car, carLoc)
+}
+
+func (simulation *GeneralLaneSimulation) moveCar(car *SmartCar) {
+	car.smartCarLock.Lock()
+	x := car.X
+	y := car.Y
+	direction := car.Direction
+	car.smartCarLock.Unlock()
+
+	if simulation.inGrid(x, y) && simulation.Locations[x][y].getLocationState() == Intersection {
+		direction = simulation.turnIfNeeded(car, x, y)
+	}
+	street := simulation.streetAt(direction, x, y)
+	if street == nil {
+		log.Println("invalid bounds", car.ID)
+		return
+	}
+	currLoc := simulation.Locations[x][y]
+
+	var nextLoc *StatefulLocation
+	switchLanes := simulation.random.laneChoice.UniformRand() < simulation.config.probSwitchingLanes
+	aside := car.movingAside // an emergency vehicle is behind, the car switches lanes if it can
+	car.movingAside = false
+	switchLanes = switchLanes || aside
+
+	dx, dy := direction.step()
+	nextX, nextY := x+dx, y+dy
+	nextIndex := indexAlong(direction, nextX, nextY)
+	ahead := street
+	wraps := false
+	if !street.contains(nextIndex) {
+		ahead = street.next // nil at the exit, or where the car has to turn
+		if ahead == nil {
+			nextX, nextY, wraps = simulation.wrapsAround(street, x, y)
+			nextIndex = indexAlong(direction, nextX, nextY)
+			if wraps {
+				ahead = street
 			}
-			if currLoc.getLocationState() == AccidentLocationState {
-				log.Println("current location accident state", car.ID)
-				break
+		}
+	}
+	if ahead == nil || !simulation.inGrid(nextX, nextY) {
+		return
+	}
+	merge := false
+	if !switchLanes {
+		nextLoc = simulation.Locations[nextX][nextY]
+		switchLanes = !ahead.hasLane(nextLoc) // the lane ends here, so the car merges
+		merge = !switchLanes && simulation.mergesBeforeClosure(nextLoc, direction)
+		switchLanes = switchLanes || merge
+	}
+	if switchLanes {
+		var openLanes []*StatefulLocation
+		openLanes = simulation.avoidClosures(simulation.streetLanesAtIndex(ahead, nextIndex, AllLocationTypes), direction)
+		if aside {
+			openLanes = asideLanes(openLanes, currLoc, direction)
+		}
+		nextLoc = simulation.RandomlyPickLocation(openLanes, direction, simulation.config.laneSwitchChoice) // TODO consider whether the car can pick its own position to switch to
+	}
+	if currLoc.getLocationState() == AccidentLocationState {
+		return
+	}
+	if nextLoc.getLocationState() == ClosedLocationState { // every lane is closed here
+		simulation.tripClosureBlocked(car)
+		simulation.traceCar(traceBlocked, car, currLoc, nextLoc, causeClosure)
+		simulation.MoveSmartCarInLane(car, currLoc)
+		return
+	}
+	entersIntersection := currLoc.getLocationState() != Intersection && nextLoc.getLocationState() == Intersection
+	if entersIntersection && !car.movementChosen {
+		car.movement = simulation.chooseMovement(car, nextLoc)
+		car.approach = direction
+		car.movementChosen = true
+		simulation.arriveAtIntersection(car)
+	}
+	if entersIntersection && !simulation.signalAllows(nextLoc, direction) {
+		simulation.tripRedLight(car)
+		simulation.traceCar(traceBlocked, car, currLoc, nextLoc, causeRedLight)
+		simulation.MoveSmartCarInLane(car, currLoc)
+		return
+	}
+	if entersIntersection {
+		if allowed, cause := simulation.priorityAllows(car, nextLoc, direction); !allowed {
+			simulation.traceCar(traceBlocked, car, currLoc, nextLoc, cause)
+			simulation.MoveSmartCarInLane(car, currLoc)
+			return
+		}
+	}
+	if nextLoc.getLocationState() == AccidentLocationState {
+		simulation.tripBlocked(car)
+		simulation.startTripInterval(car, accidentAheadInterval)
+		simulation.traceCar(traceBlocked, car, currLoc, nextLoc, causeAccidentAhead)
+		simulation.MoveSmartCarInLane(car, currLoc) // just try again later
+		return
+	}
+
+	if simulation.config.parkingEnabled && !car.Emergency && currLoc.canMoveToParking() { // parking can only happen on regular lane
+		distractionOccurs := getPoissonRand(simulation.random.parking, 1, simulation.config.unlikelyCutoff, simulation.config.removeUnlikelyEvents) < simulation.config.distractionRate
+		if distractionOccurs {
+			var parkingLoc *StatefulLocation
+			parkingX, parkingY := x, street.parkingLane()
+			if direction.isHorizontal() {
+				parkingX, parkingY = street.parkingLane(), y
 			}
-			if nextLoc.getLocationState() == AccidentLocationState {
-				log.Println("next location accident state", car.ID)
-				go MoveSmartCarInLane(car, carClock, currLoc, simulation.config.removeUnlikelyEvents, simulation.config.unlikelyCutoff) // just try again later
-				break
+			if simulation.inGrid(parkingX, parkingY) {
+				parkingLoc = simulation.Locations[parkingX][parkingY]
 			}
-
-			if simulation.config.parkingEnabled && currLoc.canMoveToParking() { // parking can only happen on regular lane
-				distractionOccurs := getPoissonRand(1, simulation.config.unlikelyCutoff, simulation.config.removeUnlikelyEvents) < simulation.config.distractionRate
-				if distractionOccurs {
-					var parkingLoc *StatefulLocation
-					if direction == Horizontal {
-						_, bottomEnd := simulation.horizontalIndexRange()
-						if isInBounds(bottomEnd+1, simulation.config.sizeOfLane) {
-							parkingLoc = simulation.Locations[bottomEnd+1][y]
-						}
-					} else {
-						_, bottomEnd := simulation.verticalIndexRange()
-						if isInBounds(bottomEnd+1, simulation.config.sizeOfLane) {
-							parkingLoc = simulation.Locations[x][bottomEnd+1]
-						}
-					}
-					if parkingLoc != nil {
-						currLoc.removeCar(car)
-						parkingLoc.addCar(car)
-						go HandleParking(&Parking{prevLoc: currLoc, car: car, parkingTimeRate: simulation.config.parkingTimeRate, parkingLoc: parkingLoc}, parkingChan)
-						simulation.AddCrossWalkIfNeeded(parkingLoc, direction)
-						log.Println("handle parking", car.ID)
-						break
-					}
-				}
+			if parkingLoc != nil && parkingLoc.getLocationState() == ParkingLoc { // not where the street crosses another
+				currLoc.removeCar(car)
+				parkingLoc.addCar(car)
+				simulation.startTripInterval(car, parkedInterval)
+				simulation.traceCar(traceParked, car, currLoc, parkingLoc, "")
+				simulation.HandleParking(&Parking{prevLoc: currLoc, car: car, parkingTimeRate: simulation.config.parkingTimeRate, parkingLoc: parkingLoc})
+				simulation.AddCrossWalkIfNeeded(parkingLoc, street)
+				return
 			}
+		}
+	}
 
-			var accidentOccurs = false
-			if !nextLoc.noCars() {
-				randPoisson := getPoissonRand(1, simulation.config.unlikelyCutoff, simulation.config.removeUnlikelyEvents)
-				accidentOccurs = randPoisson < simulation.config.accidentProb
+	if car.Emergency && !nextLoc.noCars() { // it waits for the cars ahead to move aside rather than crash into them
+		simulation.clearWay(nextLoc)
+		simulation.tripBlocked(car)
+		simulation.traceCar(traceBlocked, car, currLoc, nextLoc, causeCarAhead)
+		simulation.MoveSmartCarInLane(car, currLoc)
+		return
+	}
 
-				if nextLoc.getLocationState() == Intersection {
-					accidentOccurs = randPoisson < simulation.config.intersectionAccidentProb
-				}
+	var accidentOccurs = false
+	if !nextLoc.noCars() {
+		randPoisson := getPoissonRand(simulation.random.accidents, 1, simulation.config.unlikelyCutoff, simulation.config.removeUnlikelyEvents)
+		accidentOccurs = randPoisson < simulation.config.accidentProb
 
-				//log.Println("next Car has more than 1", accidentOccurs, poisson.Prob(poisson.Rand()))
-
-				if simulation.config.accidentScaling {
-					numCarsNearby := simulation.countNumCarsNearby(nextLoc)
-					for i := 0; i < numCarsNearby; i++ {
-						if accidentOccurs {
-							break
-						}
-						accidentOccurs = randPoisson < simulation.config.accidentProb
-						randPoisson = getPoissonRand(1, simulation.config.unlikelyCutoff, simulation.config.removeUnlikelyEvents)
-					}
-				}
+		if nextLoc.getLocationState() == Intersection {
+			accidentOccurs = randPoisson < simulation.config.intersectionAccidentProb
+		}
 
-				if !accidentOccurs {
-					log.Println("car is already there")
-					go MoveSmartCarInLane(car, carClock, currLoc, simulation.config.removeUnlikelyEvents, simulation.config.unlikelyCutoff) // just try again with another exponential clock
+		if simulation.config.accidentScaling {
+			numCarsNearby := simulation.countNumCarsNearby(nextLoc)
+			for i := 0; i < numCarsNearby; i++ {
+				if accidentOccurs {
 					break
 				}
-				// If next position blocked, attempt to move again on a exponential clock
+				accidentOccurs = randPoisson < simulation.config.accidentProb
+				randPoisson = getPoissonRand(simulation.random.accidents, 1, simulation.config.unlikelyCutoff, simulation.config.removeUnlikelyEvents)
 			}
+		}
 
-			if !accidentOccurs && nextLoc.getLocationState() == CrossWalk {
-				accidentOccurs = getPoissonRand(1, simulation.config.unlikelyCutoff, simulation.config.removeUnlikelyEvents) < simulation.config.pedestrianDeathAccidentProb
-			}
+		if !accidentOccurs {
+			simulation.tripBlocked(car)
+			simulation.traceCar(traceBlocked, car, currLoc, nextLoc, causeCarAhead)
+			simulation.MoveSmartCarInLane(car, currLoc) // just try again with another exponential clock
+			return
+		}
+		// If next position blocked, attempt to move again on a exponential clock
+	}
 
-			if accidentOccurs {
-				simulation.runningSimulationLock.Lock()
-				simulation.numAccidents += 1
-				simulation.runningSimulationLock.Unlock()
-				prevLocState := nextLoc.getLocationState()
-				nextLoc.setLocationState(AccidentLocationState)
+	if !accidentOccurs && simulation.twoWay && currLoc.getLocationState() != Intersection &&
+		!simulation.canClearIntersection(nextLoc, direction, car.movement) { // entering would block the intersection for the other approaches
+		simulation.tripBlocked(car)
+		simulation.traceCar(traceBlocked, car, currLoc, nextLoc, causeIntersection)
+		simulation.MoveSmartCarInLane(car, currLoc)
+		return
+	}
 
-				currLoc.removeCar(car)
-				nextLoc.addCar(car)
-				go HandleAccident(&Accident{prevLocationState: prevLocState, loc: nextLoc, resolution: Unresolved, removalRate: simulation.config.carRemovalRate, probRestart: simulation.config.carRestartProb}, accidentChan, simulation.config.removeUnlikelyEvents, simulation.config.unlikelyCutoff)
-				drawUpdateChan <- true
-				log.Println("accident occured", car.ID)
-				break
-			}
+	if !accidentOccurs && nextLoc.getLocationState() == CrossWalk {
+		accidentOccurs = getPoissonRand(simulation.random.accidents, 1, simulation.config.unlikelyCutoff, simulation.config.removeUnlikelyEvents) < simulation.config.pedestrianDeathAccidentProb
+	}
 
-			if !(UniformRand() < simulation.config.probEnteringIntersection) { // doesn't enter intersection try again
-				log.Println("unable to enter intersection", car.ID)
-				go MoveSmartCarInLane(car, carClock, currLoc, simulation.config.removeUnlikelyEvents, simulation.config.unlikelyCutoff) // just try again with another exponential clock
-				break
-			}
-			log.Println("removed car from currLoc", car.ID)
-			currLoc.removeCar(car)
-
-			pollicePullsOver := UniformRand() < simulation.config.probPolicePullOverProb
-			if simulation.config.speedBasedPullOver {
-				_, prob := getNewCarSpeed(simulation.config.CarDistributionType,
-					simulation.config.carSpeedUniformEndRange,
-					simulation.config.carClock,
-					simulation.config.removeUnlikelyEvents,
-					simulation.config.unlikelyCutoff)
-				if simulation.config.probPolicePullOverProb < prob {
-					pollicePullsOver = true
-					log.Println("police pulls over", car.ID)
-				}
-			}
-			// Re sample if config is available
-			if (currLoc.getLocationState() == CrossWalk || pollicePullsOver) && !car.isSlowingDown() {
-				car.setSlowingDown(true)
-				go HandleCrossWalkSlowCar(&SlowCar{car: car, oldSpeed: car.Speed, slowDownRate: simulation.config.crossWalkSlowDownRate}, crossWalkClock)
-				car.setSpeed(simulation.config.slowDownSpeed)
-				log.Println("handle slowing down", car.ID)
+	if accidentOccurs {
+		simulation.runningSimulationLock.Lock()
+		simulation.numAccidents += 1
+		simulation.conflicts.countConflict(car, nextLoc.sortedCars())
+		simulation.countPriorityAccident(car, nextLoc)
+		if entersIntersection {
+			simulation.movements.approach(direction).count(car.movement)
+			simulation.enterPriority(car, nextLoc)
+		}
+		simulation.runningSimulationLock.Unlock()
+		prevLocState := nextLoc.getLocationState()
+		cause := causeLane
+		if prevLocState == Intersection {
+			cause = causeIntersection
+		} else if prevLocState == CrossWalk && nextLoc.noCars() {
+			cause = causePedestrian
+		}
+		simulation.changeLocationState(nextLoc, AccidentLocationState)
 
-			}
+		currLoc.removeCar(car)
+		nextLoc.addCar(car)
+		simulation.traceCar(traceAccident, car, currLoc, nextLoc, cause)
+		for _, stuckCar := range nextLoc.sortedCars() {
+			simulation.startTripInterval(stuckCar, accidentInterval)
+		}
+		simulation.HandleAccident(&Accident{prevLocationState: prevLocState, loc: nextLoc, resolution: Unresolved, removalRate: simulation.config.carRemovalRate, probRestart: simulation.config.carRestartProb})
+		simulation.notifyDraw()
+		return
+	}
 
-			if simulation.config.reSampleSpeedEveryClk && !car.slowingDown {
-				speed, _ := getNewCarSpeed(simulation.config.CarDistributionType,
-					simulation.config.carSpeedUniformEndRange,
-					simulation.config.carClock,
-					simulation.config.removeUnlikelyEvents,
-					simulation.config.unlikelyCutoff)
-				car.setSpeed(speed)
-			}
+	if !(entersIntersection && simulation.ruledByPriority(nextLoc)) && // signs decided already
+		!(simulation.random.carClock.UniformRand() < simulation.config.probEnteringIntersection) { // doesn't enter intersection try again
+		simulation.tripBlocked(car)
+		simulation.traceCar(traceBlocked, car, currLoc, nextLoc, causeIntersection)
+		simulation.MoveSmartCarInLane(car, currLoc) // just try again with another exponential clock
+		return
+	}
+	currLoc.removeCar(car)
 
-			nextLoc.addCar(car)
-			go MoveSmartCarInLane(car, carClock, nextLoc, simulation.config.removeUnlikelyEvents, simulation.config.unlikelyCutoff) // If next position blocked, attempt to move again on a exponential clock
-			log.Println("move to next pos", car.ID)
-			drawUpdateChan <- true
-			break
-		case accident := <-accidentChan:
-			if !simulation.isRunningSimulation() {
-				return
-			}
-			accident.loc.setLocationState(accident.prevLocationState)
-			accident.loc.locationLock.Lock()
-			cars := accident.loc.Cars
-			accident.loc.locationLock.Unlock()
-
-			if accident.resolution == Resolved {
-				for _, car := range cars {
-					go MoveSmartCarInLane(car, carClock, accident.loc, simulation.config.removeUnlikelyEvents, simulation.config.unlikelyCutoff)
-				}
-			} else {
-				// handle removing the cars by setting them to deleted and moving them to out root
-				var root *StatefulLocation
-				for _, car := range cars {
-					accident.loc.removeCar(car)
-					if car.Direction == Horizontal {
-						root = simulation.OutHorizontalRoot
-					} else {
-						root = simulation.OutVerticalRoot
-					}
-
-					car.carState = Deleted
-					root.addCar(car)
-				}
-			}
-			break
-		case parkingCar := <-parkingChan:
-			if !simulation.isRunningSimulation() {
-				return
-			}
-			var openLanes []*StatefulLocation
-			openLanes = simulation.getVerticalLanesAtIndex(parkingCar.prevLoc.X, Open)
-			if len(openLanes) == 0 {
-				go HandleParking(parkingCar, parkingChan) // retry bc no item in lane is free
-				break
-			}
-			nextLoc := simulation.RandomlyPickLocation(openLanes, parkingCar.car.Direction, simulation.config.laneSwitchChoice) // TODO consider whether the car can pick its own position to switch to
+	pollicePullsOver := simulation.random.carClock.UniformRand() < simulation.config.probPolicePullOverProb
+	if simulation.config.speedBasedPullOver {
+		_, prob := getNewCarSpeed(simulation.random.carClock,
+			simulation.config.CarDistributionType,
+			simulation.config.carSpeedUniformEndRange,
+			simulation.config.carClock,
+			simulation.config.removeUnlikelyEvents,
+			simulation.config.unlikelyCutoff)
+		if simulation.config.probPolicePullOverProb < prob {
+			pollicePullsOver = true
+		}
+	}
+	if pollicePullsOver {
+		simulation.traceCar(tracePullOver, car, currLoc, nil, "")
+	}
+	// Re sample if config is available
+	if (currLoc.getLocationState() == CrossWalk || pollicePullsOver) && !car.isSlowingDown() {
+		car.setSlowingDown(true)
+		simulation.HandleCrossWalkSlowCar(&SlowCar{car: car, oldSpeed: car.Speed, slowDownRate: simulation.config.crossWalkSlowDownRate})
+		car.setSpeed(simulation.config.slowDownSpeed)
+		cause := causeCrossWalk
+		if pollicePullsOver {
+			cause = causePolice
+		}
+		simulation.startTripInterval(car, slowedInterval)
+		simulation.traceCar(traceSlowDown, car, currLoc, nil, cause)
+	}
 
-			if !nextLoc.isEmpty() {
-				go HandleParking(parkingCar, parkingChan)
-				break
-				// send the car back into parking if there is no spot to return to
-			}
+	if simulation.config.reSampleSpeedEveryClk && !car.slowingDown && !car.Emergency {
+		speed, _ := getNewCarSpeed(simulation.random.carClock,
+			simulation.config.CarDistributionType,
+			simulation.config.carSpeedUniformEndRange,
+			simulation.config.carClock,
+			simulation.config.removeUnlikelyEvents,
+			simulation.config.unlikelyCutoff)
+		car.setSpeed(speed)
+	}
 
-			parkingCar.parkingLoc.removeCar(parkingCar.car) // remove a specific car from parking
-			nextLoc.addCar(parkingCar.car)
-			go MoveSmartCarInLane(parkingCar.car, carClock, nextLoc, simulation.config.removeUnlikelyEvents, simulation.config.unlikelyCutoff) // If next position blocked, attempt to move again on a exponential clock
-			simulation.RemoveCrossWalkIfNeeded(parkingCar.parkingLoc, parkingCar.car.Direction)
-			break
-		case slowCar := <-crossWalkClock:
-			if !simulation.isRunningSimulation() {
-				return
-			}
-			slowCar.car.setSlowingDown(false)
-			slowCar.car.setSpeed(slowCar.oldSpeed)
-		case <-simulation.cancelSimulation:
-			simulation.setRunningSimulation(false)
-			return
+	if entersIntersection {
+		simulation.runningSimulationLock.Lock()
+		simulation.movements.approach(direction).count(car.movement)
+		simulation.enterPriority(car, nextLoc)
+		simulation.runningSimulationLock.Unlock()
+		simulation.stopTripInterval(car, redLightInterval)
+	} else if nextLoc.getLocationState() != Intersection {
+		car.movementChosen = false
+	}
+	nextLoc.addCar(car)
+	simulation.stopTripInterval(car, accidentAheadInterval)
+	if car.Emergency {
+		simulation.emergencyMoved(car, nextLoc)
+	}
+	cause := ""
+	if switchLanes {
+		cause = causeLaneSwitch
+	}
+	if merge {
+		simulation.tripMerged(car)
+		cause = causeClosure
+	}
+	if wraps {
+		simulation.countLap()
+		cause = causeRingLap
+	}
+	simulation.traceCar(traceCarMoved, car, currLoc, nextLoc, cause)
+	simulation.MoveSmartCarInLane(car, nextLoc) // If next position blocked, attempt to move again on a exponential clock
+	simulation.admitQueued(currLoc)
+	simulation.notifyDraw()
+}
+
+func (simulation *GeneralLaneSimulation) resolveAccident(accident *Accident) {
+	if simulation.random.accidents.UniformRand() < accident.probRestart {
+		accident.resolution = Resolved
+	} else {
+		accident.resolution = ToBeDeleted
+	}
+
+	simulation.changeLocationState(accident.loc, simulation.afterAccident(accident.loc, accident.prevLocationState))
+	cars := accident.loc.sortedCars()
+
+	if accident.resolution == Resolved {
+		for _, car := range cars {
+			simulation.stopTripInterval(car, accidentInterval)
+			simulation.traceCar(traceAccidentCleared, car, accident.loc, nil, causeResolved)
+			simulation.MoveSmartCarInLane(car, accident.loc)
+		}
+	} else {
+		// handle removing the cars by setting them to deleted and moving them to the out root of their street
+		for _, car := range cars {
+			accident.loc.removeCar(car)
+			root := simulation.streetAt(car.Direction, accident.loc.X, accident.loc.Y).OutRoot
+
+			car.carState = Deleted
+			root.addCar(car)
+			simulation.exitTrip(car, nil, true)
+			simulation.traceCar(traceCarRemoved, car, accident.loc, root, causeRemoved)
 		}
 	}
+	simulation.accidentCleared(accident)
+}
+
+func (simulation *GeneralLaneSimulation) returnFromParking(parkingCar *Parking) {
+	prevLoc := parkingCar.prevLoc
+	street := simulation.streetAt(parkingCar.car.Direction, prevLoc.X, prevLoc.Y)
+	openLanes := simulation.streetLanesAtIndex(street, indexAlong(street.Direction, prevLoc.X, prevLoc.Y), Open)
+	if len(openLanes) == 0 {
+		simulation.HandleParking(parkingCar) // retry bc no item in lane is free
+		return
+	}
+	nextLoc := simulation.RandomlyPickLocation(openLanes, parkingCar.car.Direction, simulation.config.laneSwitchChoice) // TODO consider whether the car can pick its own position to switch to
+
+	if !nextLoc.isEmpty() {
+		simulation.HandleParking(parkingCar)
+		return
+		// send the car back into parking if there is no spot to return to
+	}
+
+	parkingCar.parkingLoc.removeCar(parkingCar.car) // remove a specific car from parking
+	nextLoc.addCar(parkingCar.car)
+	simulation.stopTripInterval(parkingCar.car, parkedInterval)
+	simulation.traceCar(traceUnparked, parkingCar.car, parkingCar.parkingLoc, nextLoc, "")
+	simulation.MoveSmartCarInLane(parkingCar.car, nextLoc) // If next position blocked, attempt to move again on a exponential clock
+	simulation.RemoveCrossWalkIfNeeded(parkingCar.parkingLoc, street)
 }
 
-func (sim *GeneralLaneSimulation) AddCrossWalkIfNeeded(parkingLoc *StatefulLocation, direction Direction) {
+func (sim *GeneralLaneSimulation) AddCrossWalkIfNeeded(parkingLoc *StatefulLocation, street *Street) {
 	parkingLoc.locationLock.Lock()
 	cutoff := len(parkingLoc.Cars) >= sim.config.crossWalkCutoff
 	parkingLoc.locationLock.Unlock()
 
 	if cutoff {
-		var locs []*StatefulLocation
-		if direction == Horizontal {
-			locs = sim.getHorizontalLanesAtIndex(parkingLoc.Y, AllLocationTypes)
-		} else {
-			locs = sim.getVerticalLanesAtIndex(parkingLoc.X, AllLocationTypes)
-		}
+		locs := sim.streetLanesAtIndex(street, indexAlong(street.Direction, parkingLoc.X, parkingLoc.Y), AllLocationTypes)
 		for _, loc := range locs {
 			if loc.getLocationState() == LaneLoc {
-				loc.setLocationState(CrossWalk)
+				sim.changeLocationState(loc, CrossWalk)
 			}
 		}
 	}
 
 }
 
-func (sim *GeneralLaneSimulation) RemoveCrossWalkIfNeeded(parkingLoc *StatefulLocation, direction Direction) {
+func (sim *GeneralLaneSimulation) RemoveCrossWalkIfNeeded(parkingLoc *StatefulLocation, street *Street) {
 	parkingLoc.locationLock.Lock()
 	cutoff := len(parkingLoc.Cars) < sim.config.crossWalkCutoff
 	parkingLoc.locationLock.Unlock()
 
 	if cutoff {
-		var locs []*StatefulLocation
-		if direction == Horizontal {
-			locs = sim.getHorizontalLanesAtIndex(parkingLoc.Y, AllLocationTypes)
-		} else {
-			locs = sim.getVerticalLanesAtIndex(parkingLoc.X, AllLocationTypes)
-		}
+		locs := sim.streetLanesAtIndex(street, indexAlong(street.Direction, parkingLoc.X, parkingLoc.Y), AllLocationTypes)
 		for _, loc := range locs {
 			if loc.getLocationState() == CrossWalk {
-				loc.setLocationState(LaneLoc)
+				sim.changeLocationState(loc, LaneLoc)
 			}
 		}
 	}
 }
+// isCompleted tells whether every car left, turning cars leave through the out root of another street
 func (sim *GeneralLaneSimulation) isCompleted() bool {
-	if sim.OutHorizontalRoot != nil {
-		sim.OutHorizontalRoot.locationLock.Lock()
-		defer sim.OutHorizontalRoot.locationLock.Unlock()
-		if sim.config.numHorizontalCars != len(sim.OutHorizontalRoot.Cars) {
-			return false
-		}
+	if sim.config.openSystem {
+		return sim.isOpenSystemDone()
 	}
-	if sim.OutVerticalRoot != nil {
-		sim.OutVerticalRoot.locationLock.Lock()
-		defer sim.OutVerticalRoot.locationLock.Unlock()
-		if sim.config.numVerticalCars != len(sim.OutVerticalRoot.Cars) {
-			return false
-		}
+	numCars, numOut := 0, 0
+	for _, street := range sim.streets {
+		street.OutRoot.locationLock.Lock()
+		numOut += len(street.OutRoot.Cars)
+		street.OutRoot.locationLock.Unlock()
+		numCars += street.numCars
 	}
 
-	return true
+	return numCars == numOut-sim.emergencyOut && sim.emergencyPlaced == sim.emergencyOut
 }
 
-func HandleCrossWalkSlowCar(slowCar *SlowCar, movementChan chan *SlowCar) {
-	movementTime := rand.ExpFloat64() / slowCar.slowDownRate
-	select {
-	case <-time.After(time.Duration(movementTime) * time.Second):
-		movementChan <- slowCar
-	}
+// HandleCrossWalkSlowCar schedules the end of the car's slow down
+func (sim *GeneralLaneSimulation) HandleCrossWalkSlowCar(slowCar *SlowCar) {
+	movementTime := sim.random.carClock.ExpFloat64() / slowCar.slowDownRate
+	sim.scheduler.schedule(movementTime, &SimEvent{Type: slowCarEvent, slowCar: slowCar})
 }
 
-func HandleParking(parking *Parking, movementChan chan *Parking) {
-	movementTime := rand.ExpFloat64() / parking.parkingTimeRate
-	select {
-	case <-time.After(time.Duration(movementTime) * time.Second):
-		movementChan <- parking
-	}
+// HandleParking schedules the car leaving its parking spot
+func (sim *GeneralLaneSimulation) HandleParking(parking *Parking) {
+	movementTime := sim.random.parking.ExpFloat64() / parking.parkingTimeRate
+	sim.scheduler.schedule(movementTime, &SimEvent{Type: parkingEvent, parking: parking})
 }
 
-func HandleAccident(accident *Accident, movementChan chan *Accident, removeUnlikely bool, unlikelyCutoff float64) {
-	movementTime := getExpRand(accident.removalRate, unlikelyCutoff, removeUnlikely)
-	log.Println("accident time", movementTime)
+// HandleAccident schedules the clearing of the accident, once a crew got there when there are responders
+func (sim *GeneralLaneSimulation) HandleAccident(accident *Accident) {
+	if len(sim.crews) > 0 && !accident.attended {
+		sim.reportAccident(accident)
+		return
+	}
+	movementTime := getExpRand(sim.random.accidents, accident.removalRate, sim.config.unlikelyCutoff, sim.config.removeUnlikelyEvents)
 	accident.loc.locationLock.Lock()
 	for _, car := range accident.loc.Cars {
 		car.smartCarLock.Lock()
@@ -1171,28 +1634,13 @@ func HandleAccident(accident *Accident, movementChan chan *Accident, removeUnlik
 	}
 	accident.loc.locationLock.Unlock()
 
-	select {
-	case <-time.After(time.Duration(movementTime) * time.Second):
-		if UniformRand() < accident.probRestart {
-			accident.resolution = Resolved
-			movementChan <- accident
-			break
-		} else {
-			accident.resolution = ToBeDeleted
-			movementChan <- accident
-		}
-	}
+	sim.scheduler.schedule(movementTime, &SimEvent{Type: accidentEvent, accident: accident})
 }
 
-// MoveCarInLane moves the car through a lane using an exponential clock and probability of movement
-func MoveSmartCarInLane(car *SmartCar, movementChan chan *SmartCar, carLoc *StatefulLocation, removeUnlikelyEvents bool, unlikelyCutoff float64) {
-	log.Println("moving", car.ID)
+// MoveSmartCarInLane starts the car's exponential clock, when it fires the car attempts to move with probability of movement
+func (sim *GeneralLaneSimulation) MoveSmartCarInLane(car *SmartCar, carLoc *StatefulLocation) {
 	speed := car.getSpeed()
-	var exponential = distuv.Exponential{Rate: speed}
-
-	movementTime := getExpRand(speed, unlikelyCutoff, removeUnlikelyEvents)
-	//log.Println("clock started for", car.ID, car.X, car.Y)
-	log.Println("running ", car.ID, "for ", time.Duration(movementTime)*time.Second, exponential.Prob(movementTime))
+	movementTime := getExpRand(sim.random.carClock, speed, sim.config.unlikelyCutoff, sim.config.removeUnlikelyEvents)
 
 	car.smartCarLock.Lock()
 	x := car.X
@@ -1200,55 +1648,22 @@ func MoveSmartCarInLane(car *SmartCar, movementChan chan *SmartCar, carLoc *Stat
 	car.WaitingTime = movementTime
 	car.smartCarLock.Unlock()
 
+	sim.scheduler.cancel(car.clock)
+	car.clock = nil
+	car.stalledLoc = nil
 	if x == -1 || y == -1 {
 		return
 	}
-	select {
-	case <-time.After(time.Duration(movementTime) * time.Second):
-		log.Println("timeout finished for ", car.ID)
-		car.smartCarLock.Lock()
-		x := car.X
-		y := car.Y
-		car.smartCarLock.Unlock()
-
-		if x == -1 || y == -1 || x != carLoc.X || y != carLoc.Y {
-			log.Println("moved locs already", car.ID, carLoc.X, carLoc.Y, x, y)
-			return
-		}
-		log.Println("sent", car.ID, "in")
-
-		if carLoc.getLocationState() == AccidentLocationState {
-			return // if Accident ignore the car
-		}
-
-		//log.Println("clock fired for", car.ID, car.X, car.Y)
-		if UniformRand() < car.probMovement {
-			log.Println("sending ", car.ID, "for ", time.Duration(movementTime)*time.Second)
-			movementChan <- car
-			return
-		}
-		log.Println("failed retrying ", car.ID, "for ", time.Duration(movementTime)*time.Second)
-		go MoveSmartCarInLane(car, movementChan, carLoc, removeUnlikelyEvents, unlikelyCutoff)
+	clock := &SimEvent{Type: carClockEvent, car: car, carLoc: carLoc}
+	if sim.scheduler.schedule(movementTime, clock) {
+		car.clock = clock
+	} else {
+		car.stalledLoc = carLoc
 	}
 }
 
-func moveCarsThroughBinsDirection(
-	movementChan chan Direction,
-	direction Direction,
-	sim *GeneralLaneSimulation, rate float64, removeUnlikelyEvents bool, unlikelyCutoff float64) {
-	movementTime := getExpRand(rate, unlikelyCutoff, removeUnlikelyEvents)
-	timeout := time.After(time.Duration(movementTime) * time.Second)
-	for {
-		if !sim.isRunningSimulation() {
-			return
-		}
-		select {
-		case <-timeout:
-			movementChan <- direction
-			movementTime = getExpRand(rate, unlikelyCutoff, removeUnlikelyEvents)
-			timeout = time.After(time.Duration(movementTime) * time.Second)
-			//log.Println("moved item for ", direction)
-			break
-		}
-	}
+// moveCarsThroughBinsDirection schedules the next firing of an in or out bin of the street on an exponential clock
+func (sim *GeneralLaneSimulation) moveCarsThroughBinsDirection(eventType SimEventType, street *Street, rate float64) {
+	movementTime := getExpRand(sim.random.arrivals, rate, sim.config.unlikelyCutoff, sim.config.removeUnlikelyEvents)
+	sim.scheduler.schedule(movementTime, &SimEvent{Type: eventType, street: street})
 }
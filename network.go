@@ -0,0 +1,494 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// networkMargin is how many empty cells the network keeps from the edges of the grid, room for parking
+const networkMargin = 1
+
+// NetworkNode is a point where links meet. Entry nodes feed cars into the links leaving them and exit nodes take
+// the cars of the links ending at them. A node where east west links meet north south ones is an intersection
+type NetworkNode struct {
+	ID           string `json:"id"`
+	Intersection bool   `json:"intersection"`
+	Entry        bool   `json:"entry"`
+	Exit         bool   `json:"exit"`
+	Cars         *int   `json:"cars,omitempty"`       // entering on each link leaving the entry, numHorizontalCars or numVerticalCars when missing
+	Signalized   bool   `json:"signalized,omitempty"` // has traffic signals
+}
+
+// NetworkLink is a one way road from one node to another, Length cells long between the two nodes
+type NetworkLink struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Heading string `json:"heading"` // east, south, west or north
+	Lanes   int    `json:"lanes"`
+	Length  int    `json:"length"`
+}
+
+// Network is a road network as described in a network file
+type Network struct {
+	Nodes []NetworkNode `json:"nodes"`
+	Links []NetworkLink `json:"links"`
+}
+
+func loadNetwork(path string) (*Network, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	network := &Network{}
+	if err := json.Unmarshal(data, network); err != nil {
+		return nil, errors.Wrap(err, "reading network")
+	}
+	return network, nil
+}
+
+// headingDirection is the direction of the cars on a link with the heading
+func headingDirection(heading string) (Direction, bool) {
+	switch heading {
+	case "east":
+		return Horizontal, true
+	case "south":
+		return Vertical, true
+	case "west":
+		return Westbound, true
+	case "north":
+		return Northbound, true
+	}
+	return Horizontal, false
+}
+
+// networkRoad is a straight road, the links of one axis joined at their nodes. Across the road the westbound or
+// northbound lanes come first. Along it pos has where the box of every node starts
+type networkRoad struct {
+	horizontal bool
+	reversed   int // lanes of the widest link going west or north
+	forward    int // lanes of the widest link going east or south
+	pos        map[string]int
+	placed     bool
+	base       int // first row of a horizontal road on the grid, first column of a vertical one
+	offset     int // column of pos 0 for a horizontal road, row for a vertical one
+}
+
+func (road *networkRoad) width() int {
+	return road.reversed + road.forward
+}
+
+type networkLink struct {
+	NetworkLink
+	direction Direction
+}
+
+// networkLayout works out where the links of a network go on the grid
+type networkLayout struct {
+	nodes     []NetworkNode
+	nodeIndex map[string]int
+	links     []networkLink
+	roads     []*networkRoad
+	nodeRoads map[string][2]*networkRoad // the east west and the north south road through each node
+}
+
+// road is the road through the node along the axis of direction
+func (layout *networkLayout) road(node string, direction Direction) *networkRoad {
+	if direction.isHorizontal() {
+		return layout.nodeRoads[node][0]
+	}
+	return layout.nodeRoads[node][1]
+}
+
+// boxSize is how many cells the node takes along a road going direction, the width of the road crossing it
+func (layout *networkLayout) boxSize(node string, direction Direction) int {
+	if direction.isHorizontal() {
+		return layout.nodeRoads[node][1].width()
+	}
+	return layout.nodeRoads[node][0].width()
+}
+
+// networkGridLayout lays the network out on a grid
+func networkGridLayout(network *Network, config *GeneralLaneSimulationConfig) (gridLayout, error) {
+	layout := &networkLayout{nodes: network.Nodes, nodeIndex: map[string]int{}}
+	for i, node := range network.Nodes {
+		if _, ok := layout.nodeIndex[node.ID]; ok {
+			return gridLayout{}, fmt.Errorf("node %s appears twice", node.ID)
+		}
+		layout.nodeIndex[node.ID] = i
+	}
+	if len(network.Links) == 0 {
+		return gridLayout{}, errors.New("the network has no links")
+	}
+	for _, link := range network.Links {
+		direction, ok := headingDirection(link.Heading)
+		if !ok {
+			return gridLayout{}, fmt.Errorf("link from %s to %s has an unknown heading %q", link.From, link.To, link.Heading)
+		}
+		if _, ok := layout.nodeIndex[link.From]; !ok {
+			return gridLayout{}, fmt.Errorf("a link starts at unknown node %s", link.From)
+		}
+		if _, ok := layout.nodeIndex[link.To]; !ok {
+			return gridLayout{}, fmt.Errorf("a link ends at unknown node %s", link.To)
+		}
+		if link.From == link.To || link.Lanes < 1 || link.Length < 1 {
+			return gridLayout{}, fmt.Errorf("link from %s to %s needs two nodes, a lane and a cell", link.From, link.To)
+		}
+		for _, other := range layout.links {
+			if other.direction == direction && (other.From == link.From || other.To == link.To) {
+				return gridLayout{}, fmt.Errorf("two links head %s at node %s", link.Heading, link.From)
+			}
+		}
+		layout.links = append(layout.links, networkLink{NetworkLink: link, direction: direction})
+	}
+
+	layout.buildRoads()
+	for _, node := range layout.nodes {
+		crossing := layout.nodeRoads[node.ID][0].width() > 0 && layout.nodeRoads[node.ID][1].width() > 0
+		switch {
+		case crossing && !node.Intersection:
+			return gridLayout{}, fmt.Errorf("node %s joins east west and north south links, it has to be an intersection", node.ID)
+		case !crossing && node.Intersection:
+			return gridLayout{}, fmt.Errorf("intersection %s needs both east west and north south links", node.ID)
+		case crossing && (node.Entry || node.Exit):
+			return gridLayout{}, fmt.Errorf("intersection %s cannot be an entry or an exit", node.ID)
+		}
+	}
+	if err := layout.placeAlongRoads(); err != nil {
+		return gridLayout{}, err
+	}
+	if err := layout.placeRoads(); err != nil {
+		return gridLayout{}, err
+	}
+	grid := layout.fitGrid()
+	streets, err := layout.streets(config)
+	if err != nil {
+		return gridLayout{}, err
+	}
+	grid.streets = streets
+	for _, node := range layout.nodes {
+		if node.Intersection && node.Signalized {
+			road := layout.road(node.ID, Horizontal)
+			grid.signals = append(grid.signals, [2]int{road.base, road.offset + road.pos[node.ID]})
+		}
+	}
+	return grid, nil
+}
+
+// buildRoads joins the nodes linked along each axis into roads, in the order of the nodes in the file
+func (layout *networkLayout) buildRoads() {
+	layout.nodeRoads = map[string][2]*networkRoad{}
+	for _, node := range layout.nodes {
+		layout.nodeRoads[node.ID] = [2]*networkRoad{
+			{horizontal: true, pos: map[string]int{node.ID: 0}},
+			{horizontal: false, pos: map[string]int{node.ID: 0}},
+		}
+	}
+	for _, link := range layout.links {
+		axis := 1
+		if link.direction.isHorizontal() {
+			axis = 0
+		}
+		road, other := layout.nodeRoads[link.From][axis], layout.nodeRoads[link.To][axis]
+		if road != other {
+			for id := range other.pos {
+				road.pos[id] = 0
+				roads := layout.nodeRoads[id]
+				roads[axis] = road
+				layout.nodeRoads[id] = roads
+			}
+			road.reversed = maxInt(road.reversed, other.reversed)
+			road.forward = maxInt(road.forward, other.forward)
+		}
+		if link.direction.isReversed() {
+			road.reversed = maxInt(road.reversed, link.Lanes)
+		} else {
+			road.forward = maxInt(road.forward, link.Lanes)
+		}
+	}
+	seen := map[*networkRoad]bool{}
+	for _, node := range layout.nodes {
+		for _, road := range layout.nodeRoads[node.ID] {
+			if road.width() > 0 && !seen[road] {
+				seen[road] = true
+				layout.roads = append(layout.roads, road)
+			}
+		}
+	}
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// placeAlongRoads works out where the nodes of each road are from the lengths of its links. A link that disagrees
+// with the ones already placed is an error
+func (layout *networkLayout) placeAlongRoads() error {
+	for _, road := range layout.roads {
+		known := map[string]bool{}
+		for _, node := range layout.nodes { // start from the road's first node in the file
+			if _, ok := road.pos[node.ID]; ok {
+				known[node.ID] = true
+				break
+			}
+		}
+		for changed := true; changed; {
+			changed = false
+			for _, link := range layout.links {
+				if layout.road(link.From, link.direction) != road {
+					continue
+				}
+				// the node further along the road starts after the other node's box and the link
+				low, high := link.From, link.To
+				if link.direction.isReversed() {
+					low, high = high, low
+				}
+				step := layout.boxSize(low, link.direction) + link.Length
+				switch {
+				case known[low] && known[high]:
+					if road.pos[high] != road.pos[low]+step {
+						return fmt.Errorf("the link from %s to %s does not fit the lengths of the other links", link.From, link.To)
+					}
+				case known[low]:
+					road.pos[high] = road.pos[low] + step
+					known[high] = true
+					changed = true
+				case known[high]:
+					road.pos[low] = road.pos[high] - step
+					known[low] = true
+					changed = true
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// placeRoads puts the roads on the grid so that every intersection is where its two roads cross
+func (layout *networkLayout) placeRoads() error {
+	layout.roads[0].placed = true
+	for changed := true; changed; {
+		changed = false
+		for _, node := range layout.nodes {
+			if !node.Intersection {
+				continue
+			}
+			horizontal, vertical := layout.nodeRoads[node.ID][0], layout.nodeRoads[node.ID][1]
+			switch {
+			case horizontal.placed && vertical.placed:
+				if vertical.base != horizontal.offset+horizontal.pos[node.ID] || horizontal.base != vertical.offset+vertical.pos[node.ID] {
+					return fmt.Errorf("the roads do not meet at intersection %s, check the lengths of the links around it", node.ID)
+				}
+			case horizontal.placed:
+				vertical.base = horizontal.offset + horizontal.pos[node.ID]
+				vertical.offset = horizontal.base - vertical.pos[node.ID]
+				vertical.placed = true
+				changed = true
+			case vertical.placed:
+				horizontal.base = vertical.offset + vertical.pos[node.ID]
+				horizontal.offset = vertical.base - horizontal.pos[node.ID]
+				horizontal.placed = true
+				changed = true
+			}
+		}
+	}
+	for _, road := range layout.roads {
+		if !road.placed {
+			return errors.New("every road of the network has to meet the others at intersections")
+		}
+	}
+	return nil
+}
+
+// extent is the first and last cell a road takes along itself
+func (layout *networkLayout) extent(road *networkRoad) (int, int) {
+	direction := Vertical
+	if road.horizontal {
+		direction = Horizontal
+	}
+	low, high := 0, 0
+	first := true
+	for id, pos := range road.pos {
+		start, end := road.offset+pos, road.offset+pos+layout.boxSize(id, direction)-1 // nodes without a box end before they start
+		if first || start < low {
+			low = start
+		}
+		if first || end > high {
+			high = end
+		}
+		first = false
+	}
+	return low, high
+}
+
+// fitGrid moves the roads clear of the top and left edges and sizes the grid to hold them
+func (layout *networkLayout) fitGrid() gridLayout {
+	minRow, minColumn, maxRow, maxColumn := 0, 0, 0, 0
+	for i, road := range layout.roads {
+		low, high := layout.extent(road)
+		rows := [2]int{road.base, road.base + road.width() - 1}
+		columns := [2]int{low, high}
+		if !road.horizontal {
+			rows, columns = columns, rows
+		}
+		if i == 0 || rows[0] < minRow {
+			minRow = rows[0]
+		}
+		if i == 0 || rows[1] > maxRow {
+			maxRow = rows[1]
+		}
+		if i == 0 || columns[0] < minColumn {
+			minColumn = columns[0]
+		}
+		if i == 0 || columns[1] > maxColumn {
+			maxColumn = columns[1]
+		}
+	}
+	for _, road := range layout.roads {
+		rowShift, columnShift := networkMargin-minRow, networkMargin-minColumn
+		if road.horizontal {
+			road.base += rowShift
+			road.offset += columnShift
+		} else {
+			road.base += columnShift
+			road.offset += rowShift
+		}
+	}
+	return gridLayout{rows: maxRow - minRow + 1 + 2*networkMargin, columns: maxColumn - minColumn + 1 + 2*networkMargin}
+}
+
+// lanes are the rows or columns of the link. A link with fewer lanes than its road leaves out the outside ones
+func (layout *networkLayout) lanes(link networkLink) []int {
+	road := layout.road(link.From, link.direction)
+	if link.direction.isReversed() {
+		return indexRange(road.base+road.reversed-link.Lanes, road.base+road.reversed-1)
+	}
+	return indexRange(road.base+road.reversed, road.base+road.reversed+link.Lanes-1)
+}
+
+// linkSpan is the first and last cell of the link in driving order
+func (layout *networkLayout) linkSpan(link networkLink) (int, int) {
+	road := layout.road(link.From, link.direction)
+	if link.direction.isReversed() {
+		return road.offset + road.pos[link.From] - 1, road.offset + road.pos[link.To] + layout.boxSize(link.To, link.direction)
+	}
+	return road.offset + road.pos[link.From] + layout.boxSize(link.From, link.direction), road.offset + road.pos[link.To] - 1
+}
+
+// boxSpan is the first and last cell of the node's box in driving order
+func (layout *networkLayout) boxSpan(node string, direction Direction) (int, int) {
+	road := layout.road(node, direction)
+	low, high := road.offset+road.pos[node], road.offset+road.pos[node]+layout.boxSize(node, direction)-1
+	if direction.isReversed() {
+		return high, low
+	}
+	return low, high
+}
+
+// following is the link going the same way out of the node the link ends at, -1 if there is none
+func (layout *networkLayout) following(link networkLink) int {
+	for i, other := range layout.links {
+		if other.From == link.To && other.direction == link.direction {
+			return i
+		}
+	}
+	return -1
+}
+
+// joins tells whether the link carries on the street of the link before it
+func (layout *networkLayout) joins(before networkLink, link networkLink) bool {
+	node := layout.nodes[layout.nodeIndex[link.From]]
+	return before.Lanes == link.Lanes && !node.Entry && !node.Exit
+}
+
+// streets chains the links going the same way with the same lanes into streets. A street takes in the boxes of
+// the intersections at its ends, so cars can turn onto and off it, unless the street before it has the box
+func (layout *networkLayout) streets(config *GeneralLaneSimulationConfig) ([]streetLayout, error) {
+	streets := make([]streetLayout, 0)
+	linkStreet := map[int]int{}
+	for i, link := range layout.links {
+		before := -1
+		for j, other := range layout.links {
+			if other.To == link.From && other.direction == link.direction {
+				before = j
+			}
+		}
+		if before >= 0 && layout.joins(layout.links[before], link) {
+			continue // part of the street of the link before
+		}
+		from := layout.nodes[layout.nodeIndex[link.From]]
+		street := streetLayout{direction: link.direction, lanes: layout.lanes(link), entry: from.Entry, next: -1}
+		street.first, _ = layout.linkSpan(link)
+		if from.Intersection && before < 0 {
+			street.first, _ = layout.boxSpan(from.ID, link.direction)
+		}
+		if street.entry {
+			street.numCars = config.numVerticalCars
+			if link.direction.isHorizontal() {
+				street.numCars = config.numHorizontalCars
+			}
+			if from.Cars != nil {
+				street.numCars = *from.Cars
+			}
+		}
+		last := link
+		linkStreet[i] = len(streets)
+		for next := layout.following(last); next >= 0 && layout.joins(last, layout.links[next]); next = layout.following(last) {
+			last = layout.links[next]
+			linkStreet[next] = len(streets)
+		}
+		to := layout.nodes[layout.nodeIndex[last.To]]
+		_, street.last = layout.linkSpan(last)
+		if to.Intersection {
+			_, street.last = layout.boxSpan(to.ID, last.direction)
+		}
+		street.exit = to.Exit
+		streets = append(streets, street)
+	}
+
+	// a street ends where the lanes change and the street after it carries on
+	for i, link := range layout.links {
+		next := layout.following(link)
+		if next >= 0 && linkStreet[next] != linkStreet[i] {
+			streets[linkStreet[i]].next = linkStreet[next]
+		}
+	}
+	for i, link := range layout.links {
+		street := streets[linkStreet[i]]
+		if layout.following(link) >= 0 || street.exit || street.next >= 0 {
+			continue
+		}
+		if !layout.nodes[layout.nodeIndex[link.To]].Intersection {
+			return nil, fmt.Errorf("the link from %s to %s leads nowhere, end it at an exit or an intersection", link.From, link.To)
+		}
+		if !layout.canTurnAt(link) {
+			return nil, fmt.Errorf("cars on the link from %s to %s cannot turn anywhere at %s", link.From, link.To, link.To)
+		}
+	}
+
+	indexes := map[Direction]int{}
+	for i := range streets {
+		streets[i].index = indexes[streets[i].direction]
+		indexes[streets[i].direction]++
+	}
+	return streets, nil
+}
+
+// canTurnAt tells whether a link leaves the intersection the link ends at to its left or right
+func (layout *networkLayout) canTurnAt(link networkLink) bool {
+	for _, other := range layout.links {
+		if other.From == link.To && (other.direction == link.direction.left() || other.direction == link.direction.right()) {
+			return true
+		}
+		// a street going through the intersection takes its box too
+		if other.To == link.To && layout.following(other) >= 0 && (other.direction == link.direction.left() || other.direction == link.direction.right()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// osmDefaultCellLength is how many metres of road one cell stands for, about the room a car takes in a queue
+const osmDefaultCellLength = 7.5
+
+const earthRadius = 6371000.0
+
+// osmDrivable are the highway values cars drive on
+var osmDrivable = map[string]bool{
+	"motorway": true, "trunk": true, "primary": true, "secondary": true, "tertiary": true,
+	"motorway_link": true, "trunk_link": true, "primary_link": true, "secondary_link": true, "tertiary_link": true,
+	"unclassified": true, "residential": true, "living_street": true, "service": true, "road": true,
+}
+
+type osmTag struct {
+	Key   string `xml:"k,attr"`
+	Value string `xml:"v,attr"`
+}
+
+type osmNode struct {
+	ID     string   `xml:"id,attr"`
+	Lat    float64  `xml:"lat,attr"`
+	Lon    float64  `xml:"lon,attr"`
+	Action string   `xml:"action,attr"`
+	Tags   []osmTag `xml:"tag"`
+}
+
+type osmRef struct {
+	Ref string `xml:"ref,attr"`
+}
+
+type osmWay struct {
+	ID     string   `xml:"id,attr"`
+	Action string   `xml:"action,attr"`
+	Refs   []osmRef `xml:"nd"`
+	Tags   []osmTag `xml:"tag"`
+}
+
+// osmFile is the part of an OpenStreetMap XML extract the importer reads
+type osmFile struct {
+	Nodes []osmNode `xml:"node"`
+	Ways  []osmWay  `xml:"way"`
+}
+
+func osmTagValue(tags []osmTag, key string) string {
+	for _, tag := range tags {
+		if tag.Key == key {
+			return tag.Value
+		}
+	}
+	return ""
+}
+
+// osmLanes is how many lanes a way has along its nodes and against them
+func osmLanes(tags []osmTag) (int, int) {
+	highway := osmTagValue(tags, "highway")
+	oneway := osmTagValue(tags, "oneway")
+	junction := osmTagValue(tags, "junction")
+	if oneway == "" && (highway == "motorway" || junction == "roundabout" || junction == "circular") {
+		oneway = "yes"
+	}
+	lanes, err := strconv.Atoi(osmTagValue(tags, "lanes"))
+	if err != nil || lanes < 1 {
+		lanes = 0
+	}
+	switch oneway {
+	case "yes", "true", "1":
+		return maxInt(lanes, 1), 0
+	case "-1", "reverse":
+		return 0, maxInt(lanes, 1)
+	}
+	forward, errForward := strconv.Atoi(osmTagValue(tags, "lanes:forward"))
+	backward, errBackward := strconv.Atoi(osmTagValue(tags, "lanes:backward"))
+	if errForward != nil || errBackward != nil {
+		forward, backward = (lanes+1)/2, lanes/2
+	}
+	return maxInt(forward, 1), maxInt(backward, 1)
+}
+
+// osmPoint is a node projected onto a plane, in metres east and south of the first node
+type osmPoint struct {
+	x, y float64
+}
+
+// osmEdge is a stretch of a way between two nodes the network keeps, on one axis
+type osmEdge struct {
+	way        string
+	from, to   string
+	horizontal bool
+	forward    int
+	backward   int
+}
+
+// osmImport turns the ways of an extract into network links
+type osmImport struct {
+	cellLength float64
+	points     map[string]osmPoint
+	signals    map[string]bool
+	edges      []osmEdge
+	column     map[string]int // the column of every kept node
+	row        map[string]int
+	warnings   []string
+}
+
+func (imp *osmImport) warn(format string, args ...interface{}) {
+	imp.warnings = append(imp.warnings, fmt.Sprintf(format, args...))
+}
+
+// importOSM reads an OpenStreetMap XML file into a network. Every way is straightened onto the east west or the
+// north south axis, splitting it where it bends, and the nodes are moved onto rows and columns of cells of
+// cellLength metres. What does not fit the grid is left out and explained in the warnings
+func importOSM(path string, cellLength float64) (*Network, []string, error) {
+	if cellLength <= 0 {
+		return nil, nil, errors.New("the cell length has to be positive")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	file := &osmFile{}
+	if err := xml.Unmarshal(data, file); err != nil {
+		return nil, nil, errors.Wrap(err, "reading osm file")
+	}
+	imp := &osmImport{cellLength: cellLength, signals: map[string]bool{}}
+	ways := imp.drivableWays(file)
+	if len(ways) == 0 {
+		return nil, nil, errors.New("the file has no roads cars can drive on")
+	}
+	imp.project(file, ways)
+	imp.splitWays(ways)
+	imp.placeNodes()
+	network := imp.network()
+	if len(network.Links) == 0 {
+		return nil, imp.warnings, errors.New("none of the roads fit on the grid")
+	}
+	return network, imp.warnings, nil
+}
+
+// drivableWays are the highways of the file with their missing nodes left out
+func (imp *osmImport) drivableWays(file *osmFile) []osmWay {
+	known := map[string]bool{}
+	for _, node := range file.Nodes {
+		if node.Action != "delete" {
+			known[node.ID] = true
+		}
+	}
+	ways := make([]osmWay, 0)
+	for _, way := range file.Ways {
+		if way.Action == "delete" || !osmDrivable[osmTagValue(way.Tags, "highway")] {
+			continue
+		}
+		refs := make([]osmRef, 0, len(way.Refs))
+		for _, ref := range way.Refs {
+			if known[ref.Ref] {
+				refs = append(refs, ref)
+			}
+		}
+		if len(refs) < len(way.Refs) {
+			imp.warn("way %s is cut by the edge of the extract", way.ID)
+		}
+		if len(refs) >= 2 {
+			way.Refs = refs
+			ways = append(ways, way)
+		}
+	}
+	return ways
+}
+
+// project puts the nodes of the ways on a plane, good enough for the few kilometres of a city extract
+func (imp *osmImport) project(file *osmFile, ways []osmWay) {
+	used := map[string]bool{}
+	for _, way := range ways {
+		for _, ref := range way.Refs {
+			used[ref.Ref] = true
+		}
+	}
+	imp.points = map[string]osmPoint{}
+	var lat0, lon0 float64
+	first := true
+	for _, node := range file.Nodes {
+		if !used[node.ID] {
+			continue
+		}
+		if first {
+			lat0, lon0 = node.Lat, node.Lon
+			first = false
+		}
+		imp.points[node.ID] = osmPoint{
+			x: (node.Lon - lon0) * math.Pi / 180 * earthRadius * math.Cos(lat0*math.Pi/180),
+			y: -(node.Lat - lat0) * math.Pi / 180 * earthRadius,
+		}
+		if osmTagValue(node.Tags, "highway") == "traffic_signals" {
+			imp.signals[node.ID] = true
+		}
+	}
+}
+
+// splitWays cuts the ways into edges at junctions, where a way meets another or itself, and where it turns from
+// one axis to the other. Bits shorter than half a cell do not turn the way
+func (imp *osmImport) splitWays(ways []osmWay) {
+	uses := map[string]int{}
+	for _, way := range ways {
+		for _, ref := range way.Refs {
+			uses[ref.Ref]++
+		}
+	}
+	signalized := map[string]bool{}
+	for _, way := range ways {
+		forward, backward := osmLanes(way.Tags)
+		start := 0
+		horizontal, axisSet := false, false
+		for i := 1; i < len(way.Refs); i++ {
+			a, b := imp.points[way.Refs[i-1].Ref], imp.points[way.Refs[i].Ref]
+			dx, dy := b.x-a.x, b.y-a.y
+			if math.Hypot(dx, dy) >= imp.cellLength/2 || !axisSet {
+				segment := math.Abs(dx) >= math.Abs(dy)
+				if axisSet && segment != horizontal && i-1 > start {
+					imp.addEdge(way, start, i-1, horizontal, forward, backward, signalized)
+					start = i - 1
+				}
+				horizontal, axisSet = segment, true
+			}
+			if uses[way.Refs[i].Ref] > 1 || i == len(way.Refs)-1 {
+				imp.addEdge(way, start, i, horizontal, forward, backward, signalized)
+				start = i
+				axisSet = false
+			}
+		}
+	}
+	for id := range signalized {
+		imp.signals[id] = true
+	}
+}
+
+// addEdge keeps the nodes start to end of the way as an edge. A traffic signal between them moves to the nearer end
+func (imp *osmImport) addEdge(way osmWay, start int, end int, horizontal bool, forward int, backward int, signalized map[string]bool) {
+	from, to := way.Refs[start].Ref, way.Refs[end].Ref
+	if from == to {
+		return
+	}
+	for _, ref := range way.Refs[start+1 : end] {
+		if !imp.signals[ref.Ref] {
+			continue
+		}
+		p := imp.points[ref.Ref]
+		a, b := imp.points[from], imp.points[to]
+		if math.Hypot(p.x-a.x, p.y-a.y) <= math.Hypot(p.x-b.x, p.y-b.y) {
+			signalized[from] = true
+		} else {
+			signalized[to] = true
+		}
+	}
+	imp.edges = append(imp.edges, osmEdge{way: way.ID, from: from, to: to, horizontal: horizontal, forward: forward, backward: backward})
+}
+
+// osmGroups joins nodes into groups with union find
+type osmGroups map[string]string
+
+func (groups osmGroups) find(id string) string {
+	for groups[id] != "" && groups[id] != id {
+		id = groups[id]
+	}
+	return id
+}
+
+func (groups osmGroups) union(a string, b string) {
+	ra, rb := groups.find(a), groups.find(b)
+	groups[ra] = ra
+	groups[rb] = ra
+}
+
+// placeNodes gives every node a row and a column. The nodes joined by east west edges share a row and the ones
+// joined by north south edges a column. Rows and columns keep the order of the real streets and are spread out so
+// that the widest road that could go through them still leaves a cell to the next one
+func (imp *osmImport) placeNodes() {
+	for changed := true; changed; {
+		imp.column = imp.axisPlaces(false)
+		imp.row = imp.axisPlaces(true)
+		changed = false
+		edges := imp.edges[:0]
+		for _, edge := range imp.edges {
+			if edge.horizontal && imp.column[edge.from] == imp.column[edge.to] || !edge.horizontal && imp.row[edge.from] == imp.row[edge.to] {
+				imp.warn("way %s from node %s to %s folds onto one cell and is left out", edge.way, edge.from, edge.to)
+				changed = true
+				continue
+			}
+			edges = append(edges, edge)
+		}
+		imp.edges = edges
+	}
+}
+
+// axisPlaces works out the columns of the nodes, or their rows when rows is set
+func (imp *osmImport) axisPlaces(rows bool) map[string]int {
+	groups := osmGroups{}
+	for _, edge := range imp.edges {
+		groups[edge.from], groups[edge.to] = edge.from, edge.to
+	}
+	for _, edge := range imp.edges {
+		if edge.horizontal == rows {
+			groups.union(edge.from, edge.to)
+		}
+	}
+	widths, sums, counts := map[string]int{}, map[string]float64{}, map[string]int{}
+	for _, edge := range imp.edges {
+		if edge.horizontal == rows {
+			root := groups.find(edge.from)
+			widths[root] = maxInt(widths[root], 2*maxInt(edge.forward, edge.backward))
+		}
+	}
+	for node := range groups {
+		root := groups.find(node)
+		if rows {
+			sums[root] += imp.points[node].y
+		} else {
+			sums[root] += imp.points[node].x
+		}
+		counts[root]++
+	}
+	roots := make([]string, 0, len(sums))
+	for root := range sums {
+		roots = append(roots, root)
+	}
+	mean := func(root string) float64 { return sums[root] / float64(counts[root]) }
+	sort.Slice(roots, func(i, j int) bool {
+		if mean(roots[i]) != mean(roots[j]) {
+			return mean(roots[i]) < mean(roots[j])
+		}
+		return roots[i] < roots[j]
+	})
+	places := map[string]int{}
+	next := 0
+	for i, root := range roots {
+		place := int(math.Round(mean(root) / imp.cellLength))
+		if i > 0 && place < next {
+			place = next
+		}
+		places[root] = place
+		next = place + widths[root] + 1
+	}
+	nodePlaces := map[string]int{}
+	for node := range groups {
+		nodePlaces[node] = places[groups.find(node)]
+	}
+	return nodePlaces
+}
+
+// osmHeading is the heading of a link along the axis going from place a to place b
+func osmHeading(horizontal bool, a int, b int) string {
+	switch {
+	case horizontal && b > a:
+		return "east"
+	case horizontal:
+		return "west"
+	case b > a:
+		return "south"
+	}
+	return "north"
+}
+
+// network turns the edges into links and nodes. A link running over another going the same way is left out, and
+// so is everything not connected to the biggest part of the network. Nodes where east west and north south links
+// meet are intersections and the ends of the network are entries and exits
+func (imp *osmImport) network() *Network {
+	links := make([]NetworkLink, 0)
+	type span struct{ low, high int }
+	taken := map[string][]span{}
+	addLink := func(edge osmEdge, from string, to string, lanes int) {
+		if lanes == 0 {
+			return
+		}
+		link := NetworkLink{From: from, To: to, Lanes: lanes}
+		key, a, b := "", 0, 0
+		if edge.horizontal {
+			a, b = imp.column[from], imp.column[to]
+			key = fmt.Sprintf("row %d", imp.row[from])
+		} else {
+			a, b = imp.row[from], imp.row[to]
+			key = fmt.Sprintf("column %d", imp.column[from])
+		}
+		link.Heading = osmHeading(edge.horizontal, a, b)
+		key += " " + link.Heading
+		s := span{low: a, high: b}
+		if a > b {
+			s = span{low: b, high: a}
+		}
+		for _, other := range taken[key] {
+			if s.low < other.high && other.low < s.high {
+				imp.warn("way %s from node %s to %s runs over another road going %s and is left out", edge.way, from, to, link.Heading)
+				return
+			}
+		}
+		taken[key] = append(taken[key], s)
+		links = append(links, link)
+	}
+	for _, edge := range imp.edges {
+		addLink(edge, edge.from, edge.to, edge.forward)
+		addLink(edge, edge.to, edge.from, edge.backward)
+	}
+	links = imp.biggestPart(links)
+
+	// the length of a link leaves out the box of the node it starts from going east or south, or ends at going
+	// west or north, as wide as the road crossing there
+	roads := [2]osmGroups{{}, {}}
+	for _, link := range links {
+		axis := osmAxis(link.Heading)
+		roads[axis][link.From], roads[axis][link.To] = link.From, link.To
+	}
+	for _, link := range links {
+		roads[osmAxis(link.Heading)].union(link.From, link.To)
+	}
+	widths := [2]map[string][2]int{{}, {}}
+	for _, link := range links {
+		axis := osmAxis(link.Heading)
+		root := roads[axis].find(link.From)
+		width := widths[axis][root]
+		side := 0
+		if link.Heading == "west" || link.Heading == "north" {
+			side = 1
+		}
+		width[side] = maxInt(width[side], link.Lanes)
+		widths[axis][root] = width
+	}
+	boxSize := func(node string, axis int) int {
+		if _, ok := roads[1-axis][node]; !ok {
+			return 0
+		}
+		width := widths[1-axis][roads[1-axis].find(node)]
+		return width[0] + width[1]
+	}
+	for i, link := range links {
+		axis := osmAxis(link.Heading)
+		place := imp.row
+		if axis == 0 {
+			place = imp.column
+		}
+		low := link.From
+		if place[link.To] < place[link.From] {
+			low = link.To
+		}
+		distance := place[link.To] - place[link.From]
+		if distance < 0 {
+			distance = -distance
+		}
+		links[i].Length = distance - boxSize(low, axis)
+	}
+
+	network := &Network{Nodes: make([]NetworkNode, 0), Links: links}
+	seen := map[string]bool{}
+	for _, link := range links {
+		for _, id := range []string{link.From, link.To} {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			_, horizontal := roads[0][id]
+			_, vertical := roads[1][id]
+			node := NetworkNode{ID: id, Intersection: horizontal && vertical, Signalized: imp.signals[id]}
+			if !node.Intersection {
+				node.Entry, node.Exit = osmEnds(links, id)
+			}
+			network.Nodes = append(network.Nodes, node)
+		}
+	}
+	return network
+}
+
+// osmAxis is 0 for east west headings and 1 for north south ones
+func osmAxis(heading string) int {
+	if heading == "east" || heading == "west" {
+		return 0
+	}
+	return 1
+}
+
+// osmEnds tells whether cars come into the network at the node, on a link no other link leads onto, and whether
+// they leave it, from a link no other link carries on
+func osmEnds(links []NetworkLink, node string) (bool, bool) {
+	in, out := map[string]bool{}, map[string]bool{}
+	for _, link := range links {
+		if link.To == node {
+			in[link.Heading] = true
+		}
+		if link.From == node {
+			out[link.Heading] = true
+		}
+	}
+	entry, exit := false, false
+	for heading := range out {
+		entry = entry || !in[heading]
+	}
+	for heading := range in {
+		exit = exit || !out[heading]
+	}
+	return entry, exit
+}
+
+// biggestPart keeps the links of the biggest connected part of the network
+func (imp *osmImport) biggestPart(links []NetworkLink) []NetworkLink {
+	groups := osmGroups{}
+	for _, link := range links {
+		groups[link.From], groups[link.To] = link.From, link.To
+	}
+	for _, link := range links {
+		groups.union(link.From, link.To)
+	}
+	sizes := map[string]int{}
+	biggest := ""
+	for _, link := range links {
+		root := groups.find(link.From)
+		sizes[root]++
+		if biggest == "" || sizes[root] > sizes[biggest] {
+			biggest = root
+		}
+	}
+	kept := make([]NetworkLink, 0, len(links))
+	left := 0
+	for _, link := range links {
+		if groups.find(link.From) == biggest {
+			kept = append(kept, link)
+		} else {
+			left++
+		}
+	}
+	if left > 0 {
+		imp.warn("%d links not connected to the rest of the network are left out", left)
+	}
+	return kept
+}
+
+// ImportOSMFile converts an OpenStreetMap XML file into a network file the simulation can load and edit
+func ImportOSMFile(osmPath string, networkPath string, cellLength float64) error {
+	network, warnings, err := importOSM(osmPath, cellLength)
+	for _, warning := range warnings {
+		fmt.Fprintln(os.Stderr, "warning:", warning)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := networkGridLayout(network, DefaultGeneralLaneConfig()); err != nil {
+		return errors.Wrap(err, "the imported network does not load")
+	}
+	data, err := json.MarshalIndent(network, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d nodes and %d links\n", len(network.Nodes), len(network.Links))
+	return ioutil.WriteFile(networkPath, data, 0644)
+}
+
+// isOSMFile tells whether a network file is an OpenStreetMap extract rather than a network file
+func isOSMFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".osm")
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Rules of the intersections without a signal. Without one cars get in by probEnteringIntersection
+const (
+	allWayStop = "allWayStop" // every car stops, then they go first come first served
+	twoWayStop = "twoWayStop" // cars off the major road stop, then go once no major road car is coming
+	yieldSigns = "yield"      // cars off the major road only stop when a major road car is coming
+)
+
+// signalDiscipline counts the intersections that have a signal next to the ones run by a rule
+const signalDiscipline = "signal"
+
+// priorityDisciplines are the ways an intersection can be run, the empty one leaves it uncontrolled
+var priorityDisciplines = []string{"", allWayStop, twoWayStop, yieldSigns, signalDiscipline}
+
+// Roads a two way stop or a yield gives priority to
+const (
+	majorHorizontal = "horizontal"
+	majorVertical   = "vertical"
+)
+
+// PriorityIntersection is an intersection and the rule cars enter it by
+type PriorityIntersection struct {
+	ID         int
+	cells      []*StatefulLocation
+	rule       string      // an intersection control, signalDiscipline, or empty when uncontrolled
+	approaches []Direction // the directions cars drive into the intersection
+}
+
+// DisciplineCounts is what happened at the intersections run one way
+type DisciplineCounts struct {
+	Entries   int            `json:"entries"`   // cars that drove into them, crashing or not
+	Stops     int            `json:"stops"`     // cars that came to rest before driving in
+	Yields    int            `json:"yields"`    // moves held back to give way to another car
+	Accidents int            `json:"accidents"` // in the intersections
+	Conflicts ConflictCounts `json:"conflicts"`
+	TotalWait float64        `json:"totalWait"` // from reaching the intersection to driving in, of every car
+}
+
+// PriorityCounts splits what happened at the intersections by how they are run
+type PriorityCounts struct {
+	Uncontrolled DisciplineCounts `json:"uncontrolled"`
+	AllWayStop   DisciplineCounts `json:"allWayStop"`
+	TwoWayStop   DisciplineCounts `json:"twoWayStop"`
+	Yield        DisciplineCounts `json:"yield"`
+	Signal       DisciplineCounts `json:"signal"`
+}
+
+func (counts *PriorityCounts) discipline(rule string) *DisciplineCounts {
+	switch rule {
+	case allWayStop:
+		return &counts.AllWayStop
+	case twoWayStop:
+		return &counts.TwoWayStop
+	case yieldSigns:
+		return &counts.Yield
+	case signalDiscipline:
+		return &counts.Signal
+	}
+	return &counts.Uncontrolled
+}
+
+// DisciplineMetrics is how the cars fared at the intersections run one way
+type DisciplineMetrics struct {
+	DisciplineCounts
+	Intersections int     `json:"intersections"`
+	AccidentRate  float64 `json:"accidentRate"` // accidents per car driving in
+	MeanWait      float64 `json:"meanWait"`     // from reaching the intersection to driving in
+}
+
+func disciplineName(rule string) string {
+	if rule == "" {
+		return "uncontrolled"
+	}
+	return rule
+}
+
+// loadPriorityRules gives every intersection the rule cars enter it by, the ones with a signal keep it
+func (sim *GeneralLaneSimulation) loadPriorityRules() error {
+	config := sim.config
+	switch config.intersectionControl {
+	case "", allWayStop, twoWayStop, yieldSigns:
+	default:
+		return fmt.Errorf("unknown intersection control %q, use %s, %s or %s", config.intersectionControl, allWayStop,
+			twoWayStop, yieldSigns)
+	}
+	switch config.majorRoad {
+	case "", majorHorizontal, majorVertical:
+	default:
+		return fmt.Errorf("unknown major road %q, use %s or %s", config.majorRoad, majorHorizontal, majorVertical)
+	}
+	if config.yieldGap < 0 {
+		return errors.New("the yield gap cannot be negative")
+	}
+
+	sim.priorityAt = map[*StatefulLocation]*PriorityIntersection{}
+	sim.priorityIntersections = nil
+	for _, box := range sim.intersectionBoxes() {
+		rule := config.intersectionControl
+		if sim.signalAt[box[0]] != nil {
+			rule = signalDiscipline
+		}
+		intersection := &PriorityIntersection{ID: len(sim.priorityIntersections), cells: box, rule: rule,
+			approaches: sim.boxApproaches(box)}
+		sim.priorityIntersections = append(sim.priorityIntersections, intersection)
+		for _, loc := range box {
+			sim.priorityAt[loc] = intersection
+		}
+	}
+	return nil
+}
+
+// ruledByPriority tells whether cars enter the intersection at loc by stop or yield signs
+func (sim *GeneralLaneSimulation) ruledByPriority(loc *StatefulLocation) bool {
+	intersection := sim.priorityAt[loc]
+	return intersection != nil && intersection.rule != "" && intersection.rule != signalDiscipline
+}
+
+// isMajor tells whether cars going direction are on the major road
+func (sim *GeneralLaneSimulation) isMajor(direction Direction) bool {
+	return direction.isHorizontal() == (sim.config.majorRoad != majorVertical)
+}
+
+// arriveAtIntersection starts the wait of a car that got to an intersection, on its first try to drive in
+func (sim *GeneralLaneSimulation) arriveAtIntersection(car *SmartCar) {
+	car.arrivedAt = sim.scheduler.Now()
+	car.rested = false
+}
+
+// priorityAllows tells whether a car going direction may drive into the intersection at loc, and else why not. A
+// car that has to stop spends its first try coming to rest
+func (sim *GeneralLaneSimulation) priorityAllows(car *SmartCar, loc *StatefulLocation, direction Direction) (bool, string) {
+	if !sim.ruledByPriority(loc) || car.Emergency { // emergency vehicles go first
+		return true, ""
+	}
+	intersection := sim.priorityAt[loc]
+	major := sim.isMajor(direction)
+	if intersection.rule != allWayStop && major {
+		return true, ""
+	}
+	if intersection.rule != yieldSigns && !car.rested {
+		sim.restAtIntersection(car, intersection)
+		return false, causeStopSign
+	}
+	if !sim.mustYield(car, intersection, direction) {
+		return true, ""
+	}
+	if !car.rested {
+		sim.restAtIntersection(car, intersection)
+	} else {
+		sim.tripBlocked(car)
+	}
+	sim.runningSimulationLock.Lock()
+	sim.priority.discipline(intersection.rule).Yields++
+	sim.runningSimulationLock.Unlock()
+	return false, causeYield
+}
+
+func (sim *GeneralLaneSimulation) restAtIntersection(car *SmartCar, intersection *PriorityIntersection) {
+	car.rested = true
+	sim.tripBlocked(car)
+	sim.runningSimulationLock.Lock()
+	sim.priority.discipline(intersection.rule).Stops++
+	sim.runningSimulationLock.Unlock()
+}
+
+// mustYield tells whether a car going direction has to give way. Every car waits for the crossing cars inside the
+// intersection. At an all way stop it also waits for the crossing cars that got to the intersection before it, off
+// the major road for the major road cars within yieldGap cells of it
+func (sim *GeneralLaneSimulation) mustYield(car *SmartCar, intersection *PriorityIntersection, direction Direction) bool {
+	crosses := func(other Direction) bool {
+		return other.isHorizontal() != direction.isHorizontal()
+	}
+	for _, loc := range intersection.cells {
+		for _, other := range loc.sortedCars() {
+			if crosses(other.approach) {
+				return true
+			}
+		}
+	}
+	for _, approach := range intersection.approaches {
+		if !crosses(approach) {
+			continue
+		}
+		if intersection.rule != allWayStop {
+			if sim.isMajor(approach) && sim.approachQueue(intersection.cells, approach, sim.config.yieldGap) > 0 {
+				return true
+			}
+			continue
+		}
+		for _, loc := range sim.approachCells(intersection.cells, approach, -1) {
+			for _, other := range loc.sortedCars() {
+				if other.movementChosen && other.arrivedAt < car.arrivedAt {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// enterPriority counts a car driving into the intersection at loc and how long it waited to. The caller holds the
+// running simulation lock
+func (sim *GeneralLaneSimulation) enterPriority(car *SmartCar, loc *StatefulLocation) {
+	intersection := sim.priorityAt[loc]
+	if intersection == nil {
+		return
+	}
+	counts := sim.priority.discipline(intersection.rule)
+	counts.Entries++
+	counts.TotalWait += sim.scheduler.Now() - car.arrivedAt
+}
+
+// countPriorityAccident counts an accident of a car into the cars at loc, when loc is in an intersection. The caller
+// holds the running simulation lock
+func (sim *GeneralLaneSimulation) countPriorityAccident(car *SmartCar, loc *StatefulLocation) {
+	intersection := sim.priorityAt[loc]
+	if intersection == nil {
+		return
+	}
+	counts := sim.priority.discipline(intersection.rule)
+	counts.Accidents++
+	counts.Conflicts.countConflict(car, loc.sortedCars())
+}
+
+// priorityMetrics has every discipline, the ones no intersection of the run follows stay at zero
+func (sim *GeneralLaneSimulation) priorityMetrics() map[string]DisciplineMetrics {
+	sim.runningSimulationLock.Lock()
+	counts := sim.priority
+	sim.runningSimulationLock.Unlock()
+	metrics := map[string]DisciplineMetrics{}
+	for _, rule := range priorityDisciplines {
+		discipline := DisciplineMetrics{DisciplineCounts: *counts.discipline(rule)}
+		for _, intersection := range sim.priorityIntersections {
+			if intersection.rule == rule {
+				discipline.Intersections++
+			}
+		}
+		if discipline.Entries > 0 {
+			discipline.AccidentRate = float64(discipline.Accidents) / float64(discipline.Entries)
+			discipline.MeanWait = discipline.TotalWait / float64(discipline.Entries)
+		}
+		metrics[disciplineName(rule)] = discipline
+	}
+	return metrics
+}
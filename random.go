@@ -0,0 +1,70 @@
+package main
+
+import (
+	"golang.org/x/exp/rand"
+)
+
+// splitMixSource is a SplitMix64 generator. Its whole state is one integer, so it is cheap to seed and to save
+type splitMixSource struct {
+	state uint64
+}
+
+func (source *splitMixSource) Seed(seed uint64) {
+	source.state = seed
+}
+
+func (source *splitMixSource) Uint64() uint64 {
+	source.state += 0x9e3779b97f4a7c15
+	z := source.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// RandomStream is an independent, reproducible source of random numbers for one part of the simulation
+type RandomStream struct {
+	*rand.Rand
+	source *splitMixSource
+}
+
+func newRandomStream(seed uint64) *RandomStream {
+	source := &splitMixSource{}
+	source.Seed(seed)
+	return &RandomStream{Rand: rand.New(source), source: source}
+}
+
+// UniformRandMinMax picks a number uniformly from min to max
+func (stream *RandomStream) UniformRandMinMax(min float64, max float64) float64 {
+	return stream.Float64()*(max-min) + min
+}
+
+// UniformRand picks a number uniformly from 0 to 1
+func (stream *RandomStream) UniformRand() float64 {
+	return stream.UniformRandMinMax(0, 1)
+}
+
+// SimulationRandom keeps one stream per subsystem, so extra draws in one subsystem don't shift the others
+type SimulationRandom struct {
+	arrivals   *RandomStream // in and out bin clocks, and which car leaves the pool
+	carClock   *RandomStream // car clocks, speeds, slow downs and entering intersections
+	accidents  *RandomStream // accidents happening and being cleared
+	parking    *RandomStream // distractions and parking time
+	laneChoice *RandomStream // picking lanes when entering, leaving and switching
+	turns      *RandomStream // movements at intersections
+	routes     *RandomStream // destinations drawn from the OD matrix
+}
+
+func newSimulationRandom(seed int64) *SimulationRandom {
+	// Derive each stream's seed from the run seed so streams don't overlap
+	seeder := &splitMixSource{}
+	seeder.Seed(uint64(seed))
+	return &SimulationRandom{
+		arrivals:   newRandomStream(seeder.Uint64()),
+		carClock:   newRandomStream(seeder.Uint64()),
+		accidents:  newRandomStream(seeder.Uint64()),
+		parking:    newRandomStream(seeder.Uint64()),
+		laneChoice: newRandomStream(seeder.Uint64()),
+		turns:      newRandomStream(seeder.Uint64()),
+		routes:     newRandomStream(seeder.Uint64()),
+	}
+}
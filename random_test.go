@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+)
+
+// testConfig is a small two lane run with accidents and lane switches, so every stream gets drawn from
+func testConfig(seed int64) *GeneralLaneSimulationConfig {
+	config := DefaultGeneralLaneConfig()
+	config.wallClockSpeed = 0
+	config.seed = seed
+	config.numHorizontalCars = 20
+	config.numVerticalCars = 20
+	config.accidentProb = 0.5
+	config.removeUnlikelyEvents = false
+	config.probSwitchingLanes = 0.2
+	return config
+}
+
+// runMetrics runs the simulation to the end and gives its metrics, but the wall time
+func runMetrics(t *testing.T, sim *GeneralLaneSimulation) map[string]float64 {
+	t.Helper()
+	runHeadless(sim)
+	metrics := experimentMetrics(sim, 0)
+	delete(metrics, "wallTime")
+	return metrics
+}
+
+func seededMetrics(t *testing.T, seed int64) map[string]float64 {
+	t.Helper()
+	sim, err := initMultiLaneSimulation(testConfig(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runMetrics(t, sim)
+}
+
+func TestSameSeedSameRun(t *testing.T) {
+	first := seededMetrics(t, 7)
+	second := seededMetrics(t, 7)
+	for name, value := range first {
+		if second[name] != value {
+			t.Errorf("%s is %v then %v with the same seed", name, value, second[name])
+		}
+	}
+}
+
+func TestOtherSeedOtherRun(t *testing.T) {
+	first := seededMetrics(t, 7)
+	second := seededMetrics(t, 8)
+	if first["simulatedTime"] == second["simulatedTime"] && first["numEvents"] == second["numEvents"] {
+		t.Fatalf("seeds 7 and 8 both ran %v seconds in %v events", first["simulatedTime"], first["numEvents"])
+	}
+}
+
+func TestStreamsIndependent(t *testing.T) {
+	quiet := newSimulationRandom(7)
+	busy := newSimulationRandom(7)
+	for i := 0; i < 100; i++ {
+		busy.accidents.UniformRand()
+	}
+	for i := 0; i < 10; i++ {
+		if a, b := quiet.carClock.UniformRand(), busy.carClock.UniformRand(); a != b {
+			t.Fatalf("draw %d of the car clocks is %v, %v after drawing accidents", i, a, b)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// TraceReplay is a trace read back from disk
+type TraceReplay struct {
+	header    *TraceHeader
+	startTime float64
+	events    []TraceEvent
+}
+
+// loadTrace reads a trace written with the traceFile setting
+func loadTrace(path string) (*TraceReplay, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	replay := &TraceReplay{}
+	decoder := json.NewDecoder(file)
+	for {
+		event := TraceEvent{}
+		if replay.header == nil {
+			event.Header = &TraceHeader{Config: DefaultGeneralLaneConfig()}
+		}
+		err := decoder.Decode(&event)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "reading trace")
+		}
+		if replay.header == nil {
+			if event.Type != traceStart {
+				return nil, fmt.Errorf("trace starts with %s instead of %s", event.Type, traceStart)
+			}
+			replay.header = event.Header
+			replay.startTime = event.Time
+			continue
+		}
+		replay.events = append(replay.events, event)
+	}
+	if replay.header == nil {
+		return nil, errors.New("trace is empty")
+	}
+	return replay, nil
+}
+
+// newReplaySimulation builds the grid the trace starts from. Nothing is scheduled, the cars only move through RunTraceReplay
+func newReplaySimulation(replay *TraceReplay) (*GeneralLaneSimulation, error) {
+	config := *replay.header.Config
+	config.traceFile = "" // replaying must never overwrite the trace
+	sim, err := initMultiLaneSimulation(&config)
+	if err != nil {
+		return nil, err
+	}
+	sim.replaying = true
+
+	if len(replay.header.LocationStates) != len(sim.Locations) {
+		return nil, errors.New("trace grid does not match its config")
+	}
+	for i, row := range replay.header.LocationStates {
+		if len(row) != len(sim.Locations[i]) {
+			return nil, errors.New("trace grid does not match its config")
+		}
+		for j, state := range row {
+			sim.Locations[i][j].setLocationState(state)
+		}
+	}
+
+	cars := map[string]*SmartCar{}
+	for _, loc := range sim.allLocations() {
+		for _, car := range loc.sortedCars() {
+			cars[car.ID] = car
+			loc.removeCar(car)
+		}
+	}
+	for id, ref := range replay.header.Cars {
+		car, ok := cars[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown car %s in trace", id)
+		}
+		loc, err := sim.resolveLocationRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		loc.addCar(car)
+	}
+	sim.scheduler.advanceTo(replay.startTime)
+	sim.simulatedTime = replay.startTime
+	return sim, nil
+}
+
+// applyTraceEvent redoes the event on the grid, it returns true if there is something new to draw
+func (sim *GeneralLaneSimulation) applyTraceEvent(event TraceEvent) (bool, error) {
+	if event.Type == traceLocationState {
+		if event.To == nil || event.State == nil {
+			return false, fmt.Errorf("%s event without a location or state", event.Type)
+		}
+		loc, err := sim.resolveLocationRef(*event.To)
+		if err != nil {
+			return false, err
+		}
+		loc.setLocationState(*event.State)
+		return true, nil
+	}
+	if event.CarID == "" || event.To == nil {
+		return false, nil // nothing moved
+	}
+	if event.Type == traceCarArrived {
+		for _, street := range sim.streets {
+			if ref := sim.locationRef(street.InRoot); event.To.Root == ref.Root {
+				street.InRoot.addCar(newSmartCar(event.CarID, street.Direction, 0, 0))
+				return false, nil
+			}
+		}
+		return false, fmt.Errorf("car %s arrived at unknown root %s", event.CarID, event.To.Root)
+	}
+
+	var car *SmartCar
+	for _, loc := range sim.allLocations() {
+		loc.locationLock.Lock()
+		found, ok := loc.Cars[event.CarID]
+		loc.locationLock.Unlock()
+		if ok {
+			car = found
+			loc.removeCar(car)
+			break
+		}
+	}
+	if car == nil {
+		return false, fmt.Errorf("unknown car %s in trace", event.CarID)
+	}
+	loc, err := sim.resolveLocationRef(*event.To)
+	if err != nil {
+		return false, err
+	}
+	loc.addCar(car)
+	return true, nil
+}
+
+// RunTraceReplay plays the trace back on a simulation made by newReplaySimulation, with the same controls as a live run
+func RunTraceReplay(sim *GeneralLaneSimulation, replay *TraceReplay) {
+	defer sim.close()
+	sim.setRunningSimulation(true)
+	sim.resetPacing()
+
+	next := 0
+	sim.runLoop(func() (float64, bool) {
+		if next >= len(replay.events) {
+			return 0, false
+		}
+		return replay.events[next].Time, true
+	}, func() {
+		event := replay.events[next]
+		next++
+		sim.scheduler.advanceTo(event.Time)
+		sim.setSimulatedTime(event.Time)
+		if event.Type == traceAccident {
+			sim.runningSimulationLock.Lock()
+			sim.numAccidents += 1
+			sim.runningSimulationLock.Unlock()
+		}
+		draw, err := sim.applyTraceEvent(event)
+		if err != nil {
+			fmt.Println("stopping replay:", err)
+			sim.setRunningSimulation(false)
+			return
+		}
+		if draw {
+			sim.notifyDraw()
+		}
+	})
+}
+
+// loadReplay reads the trace and builds the simulation to play it on
+func loadReplay(path string) (*GeneralLaneSimulation, *TraceReplay, error) {
+	replay, err := loadTrace(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	sim, err := newReplaySimulation(replay)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sim, replay, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// responderCrew clears accidents, driving to each from where it cleared the last one or else from its station
+type responderCrew struct {
+	loc  *StatefulLocation
+	busy bool
+}
+
+// ResponderMetrics is how long the accidents waited for a crew and to be cleared
+type ResponderMetrics struct {
+	Crews         int     `json:"crews"`
+	Accidents     int     `json:"accidents"`     // reported to the crews
+	Queued        int     `json:"queued"`        // had to wait for a crew to be free
+	ArrivalTime   Summary `json:"arrivalTime"`   // from the accident to a crew getting there
+	ClearanceTime Summary `json:"clearanceTime"` // from the accident to the cars driving on or being taken off
+}
+
+// ResponderStats is what the crews did so far
+type ResponderStats struct {
+	Accidents      int       `json:"accidents"`
+	Queued         int       `json:"queued"`
+	ArrivalTimes   []float64 `json:"arrivalTimes"`
+	ClearanceTimes []float64 `json:"clearanceTimes"`
+}
+
+// loadResponders puts the crews on their stations, in turn
+func (sim *GeneralLaneSimulation) loadResponders() error {
+	config := sim.config
+	sim.crews = nil
+	sim.waitingAccidents = nil
+	if config.responders < 0 {
+		return errors.New("the number of responders cannot be negative")
+	}
+	if config.responders == 0 {
+		return nil
+	}
+	if config.responderSpeed <= 0 {
+		return errors.New("the responder speed has to be positive")
+	}
+	stations := config.responderStations
+	if len(stations) == 0 {
+		stations = [][2]int{{sim.numRows() / 2, sim.numColumns() / 2}}
+	}
+	for i, station := range stations {
+		if !sim.inGrid(station[0], station[1]) {
+			return fmt.Errorf("responder station %d: %d,%d is off the grid", i, station[0], station[1])
+		}
+	}
+	for i := 0; i < config.responders; i++ {
+		station := stations[i%len(stations)]
+		sim.crews = append(sim.crews, &responderCrew{loc: sim.Locations[station[0]][station[1]]})
+	}
+	return nil
+}
+
+// reportAccident sends the nearest free crew to the accident, or has it wait for the first crew to be free
+func (sim *GeneralLaneSimulation) reportAccident(accident *Accident) {
+	accident.reported = sim.scheduler.Now()
+	accident.crew = -1
+	sim.responderStats.Accidents++
+	crew := -1
+	for i, candidate := range sim.crews {
+		if !candidate.busy && (crew < 0 || cellDistance(candidate.loc, accident.loc) < cellDistance(sim.crews[crew].loc, accident.loc)) {
+			crew = i
+		}
+	}
+	if crew < 0 {
+		sim.responderStats.Queued++
+		sim.waitingAccidents = append(sim.waitingAccidents, accident)
+		return
+	}
+	sim.sendCrew(crew, accident)
+}
+
+func (sim *GeneralLaneSimulation) sendCrew(i int, accident *Accident) {
+	crew := sim.crews[i]
+	crew.busy = true
+	accident.crew = i
+	travel := float64(cellDistance(crew.loc, accident.loc)) / sim.config.responderSpeed
+	sim.scheduler.schedule(travel, &SimEvent{Type: responderEvent, accident: accident})
+}
+
+// responderArrived starts clearing the accident the crew got to
+func (sim *GeneralLaneSimulation) responderArrived(accident *Accident) {
+	sim.crews[accident.crew].loc = accident.loc
+	sim.responderStats.ArrivalTimes = append(sim.responderStats.ArrivalTimes, sim.scheduler.Now()-accident.reported)
+	accident.attended = true
+	sim.HandleAccident(accident)
+}
+
+// accidentCleared frees the crew of the accident, which goes on to the accident that waited longest
+func (sim *GeneralLaneSimulation) accidentCleared(accident *Accident) {
+	if !accident.attended || accident.crew < 0 || accident.crew >= len(sim.crews) {
+		return
+	}
+	sim.responderStats.ClearanceTimes = append(sim.responderStats.ClearanceTimes, sim.scheduler.Now()-accident.reported)
+	sim.crews[accident.crew].busy = false
+	if len(sim.waitingAccidents) > 0 {
+		next := sim.waitingAccidents[0]
+		sim.waitingAccidents = sim.waitingAccidents[1:]
+		sim.sendCrew(accident.crew, next)
+	}
+}
+
+// cellDistance is how many cells a crew drives between two cells, along the streets
+func cellDistance(from *StatefulLocation, to *StatefulLocation) int {
+	return int(math.Abs(float64(from.X-to.X)) + math.Abs(float64(from.Y-to.Y)))
+}
+
+// responderMetrics is safe to call once the simulation stopped
+func (sim *GeneralLaneSimulation) responderMetrics() ResponderMetrics {
+	metrics := ResponderMetrics{
+		Crews:         len(sim.crews),
+		Accidents:     sim.responderStats.Accidents,
+		Queued:        sim.responderStats.Queued,
+		ArrivalTime:   summarize(sim.responderStats.ArrivalTimes),
+		ClearanceTime: summarize(sim.responderStats.ClearanceTimes),
+	}
+	return metrics
+}
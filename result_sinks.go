@@ -0,0 +1,265 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// Kinds of result sinks
+const (
+	csvSink    = "csv"    // path is a directory, gets <name>.csv and, with time series, <name>-timeseries.csv
+	jsonSink   = "json"   // path is the json document to write
+	sqliteSink = "sqlite" // path is the database, experiments are added to it so they can be queried later
+)
+
+// ExperimentResult is everything an experiment produced
+type ExperimentResult struct {
+	Spec      *ExperimentSpec `json:"experiment"`
+	StartedAt time.Time       `json:"startedAt"`
+	Runs      []ExperimentRun `json:"runs"`
+	Summary   []PointSummary  `json:"summary"`
+}
+
+// ResultSink persists the result of an experiment
+type ResultSink interface {
+	write(result *ExperimentResult) error
+}
+
+// newResultSink only checks the output, nothing is touched until the result gets written
+func newResultSink(output OutputSpec) (ResultSink, error) {
+	if output.Path == "" {
+		return nil, fmt.Errorf("%s output needs a path", output.Type)
+	}
+	switch output.Type {
+	case csvSink:
+		return &CsvResultSink{dir: output.Path}, nil
+	case jsonSink:
+		return &JsonResultSink{path: output.Path}, nil
+	case sqliteSink:
+		return &SqliteResultSink{path: output.Path}, nil
+	}
+	return nil, fmt.Errorf("unknown output type %s", output.Type)
+}
+
+// parseOutputSpec reads outputs given on the command line as type:path
+func parseOutputSpec(s string) (OutputSpec, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return OutputSpec{}, fmt.Errorf("output %q is not type:path", s)
+	}
+	output := OutputSpec{Type: parts[0], Path: parts[1]}
+	_, err := newResultSink(output)
+	return output, err
+}
+
+// CsvResultSink writes one row per run, with the config as json in the last column
+type CsvResultSink struct {
+	dir string
+}
+
+func (sink *CsvResultSink) write(result *ExperimentResult) error {
+	if err := os.MkdirAll(sink.dir, 0755); err != nil {
+		return err
+	}
+	spec := result.Spec
+	file, err := os.Create(filepath.Join(sink.dir, spec.Name+".csv"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	header := append(sweepHeader(spec), "replication", "seed")
+	header = append(header, spec.Metrics...)
+	writer.Write(append(header, "error", "config"))
+	for _, run := range result.Runs {
+		row := append(pointRow(run.Point), fmt.Sprint(run.Replication), fmt.Sprint(run.Seed))
+		for _, metric := range spec.Metrics {
+			row = append(row, fmt.Sprintf("%g", run.Metrics[metric]))
+		}
+		config, err := json.Marshal(run.Config)
+		if err != nil {
+			return err
+		}
+		writer.Write(append(row, run.Err, string(config)))
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	if !spec.TimeSeries {
+		return nil
+	}
+	return sink.writeTimeSeries(result)
+}
+
+func (sink *CsvResultSink) writeTimeSeries(result *ExperimentResult) error {
+	spec := result.Spec
+	file, err := os.Create(filepath.Join(sink.dir, spec.Name+"-timeseries.csv"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"point", "replication", "detectorX", "detectorY", "start", "end", "count", "flow", "occupancy", "density", "spaceMeanSpeed"})
+	for _, run := range result.Runs {
+		for _, report := range run.TimeSeries {
+			for _, window := range report.Windows {
+				writer.Write([]string{
+					fmt.Sprint(run.Point.Index), fmt.Sprint(run.Replication),
+					fmt.Sprint(report.Location.X), fmt.Sprint(report.Location.Y),
+					fmt.Sprintf("%g", window.Start), fmt.Sprintf("%g", window.End), fmt.Sprint(window.Count),
+					fmt.Sprintf("%g", window.Flow), fmt.Sprintf("%g", window.Occupancy),
+					fmt.Sprintf("%g", window.Density), fmt.Sprintf("%g", window.SpaceMeanSpeed),
+				})
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// JsonResultSink writes the whole result as one document
+type JsonResultSink struct {
+	path string
+}
+
+func (sink *JsonResultSink) write(result *ExperimentResult) error {
+	data, err := json.MarshalIndent(result, "", " ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(sink.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(sink.path, data, 0644)
+}
+
+// SqliteResultSink appends the experiment to a database with experiments, runs, metrics and timeseries tables
+type SqliteResultSink struct {
+	path string
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS experiments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	started_at TEXT NOT NULL,
+	spec TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	experiment_id INTEGER NOT NULL REFERENCES experiments(id),
+	point INTEGER NOT NULL,
+	params TEXT NOT NULL,
+	replication INTEGER NOT NULL,
+	seed INTEGER NOT NULL,
+	config TEXT,
+	error TEXT
+);
+CREATE TABLE IF NOT EXISTS metrics (
+	run_id INTEGER NOT NULL REFERENCES runs(id),
+	name TEXT NOT NULL,
+	value REAL,
+	PRIMARY KEY (run_id, name)
+);
+CREATE TABLE IF NOT EXISTS timeseries (
+	run_id INTEGER NOT NULL REFERENCES runs(id),
+	detector_x INTEGER NOT NULL,
+	detector_y INTEGER NOT NULL,
+	window_start REAL NOT NULL,
+	window_end REAL NOT NULL,
+	count INTEGER NOT NULL,
+	flow REAL,
+	occupancy REAL,
+	density REAL,
+	space_mean_speed REAL
+);
+CREATE INDEX IF NOT EXISTS runs_experiment ON runs(experiment_id);
+CREATE INDEX IF NOT EXISTS metrics_name ON metrics(name);
+`
+
+func (sink *SqliteResultSink) write(result *ExperimentResult) error {
+	db, err := sql.Open("sqlite3", sink.path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return errors.Wrap(err, "creating tables")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := insertExperiment(tx, result); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertExperiment(tx *sql.Tx, result *ExperimentResult) error {
+	spec, err := json.Marshal(result.Spec)
+	if err != nil {
+		return err
+	}
+	inserted, err := tx.Exec("INSERT INTO experiments (name, started_at, spec) VALUES (?, ?, ?)",
+		result.Spec.Name, result.StartedAt.Format(time.RFC3339), string(spec))
+	if err != nil {
+		return err
+	}
+	experimentID, err := inserted.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for _, run := range result.Runs {
+		params, err := json.Marshal(run.Point.Params)
+		if err != nil {
+			return err
+		}
+		config, err := json.Marshal(run.Config)
+		if err != nil {
+			return err
+		}
+		inserted, err := tx.Exec("INSERT INTO runs (experiment_id, point, params, replication, seed, config, error) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			experimentID, run.Point.Index, string(params), run.Replication, run.Seed, string(config), run.Err)
+		if err != nil {
+			return err
+		}
+		runID, err := inserted.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for name, value := range run.Metrics {
+			if _, err := tx.Exec("INSERT INTO metrics (run_id, name, value) VALUES (?, ?, ?)", runID, name, value); err != nil {
+				return err
+			}
+		}
+		for _, report := range run.TimeSeries {
+			for _, window := range report.Windows {
+				_, err := tx.Exec("INSERT INTO timeseries (run_id, detector_x, detector_y, window_start, window_end, count, flow, occupancy, density, space_mean_speed) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+					runID, report.Location.X, report.Location.Y, window.Start, window.End, window.Count,
+					window.Flow, window.Occupancy, window.Density, window.SpaceMeanSpeed)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// RingMetrics measures a ring road between the end of its warm-up and the end of the run
+type RingMetrics struct {
+	Laps    int     `json:"laps"`  // cars that came back round onto the first cell of their lane
+	Lanes   int     `json:"lanes"` // lanes that loop
+	Cells   int     `json:"cells"` // cells cars can be on
+	Cars    int     `json:"cars"`
+	Density float64 `json:"density"` // cars per cell
+	Flow    float64 `json:"flow"`    // laps per lane per unit of time
+	Speed   float64 `json:"speed"`   // cells per unit of time, flow over density
+}
+
+// validateRing checks that a ring road has an end and room for its cars
+func (sim *GeneralLaneSimulation) validateRing() error {
+	config := sim.config
+	if config.warmUp < 0 {
+		return errors.New("the warm up cannot be negative")
+	}
+	if !config.ringRoad {
+		return nil
+	}
+	if config.ringDuration <= config.warmUp {
+		return errors.New("a ring road needs a ringDuration longer than its warm up, it never drains")
+	}
+	for _, street := range sim.streets {
+		if cells := len(sim.ringCells(street)); street.numCars > cells {
+			return fmt.Errorf("street %s has %d cars but only %d cells to start them on", street.name(), street.numCars, cells)
+		}
+	}
+	return nil
+}
+
+// ringCells are the cells of the street's lanes outside intersections, in order along the street
+func (sim *GeneralLaneSimulation) ringCells(street *Street) []*StatefulLocation {
+	cells := make([]*StatefulLocation, 0)
+	low, high := street.span()
+	for index := low; index <= high; index++ {
+		for _, loc := range sim.streetLanesAtIndex(street, index, AllLocationTypes) {
+			if loc.getLocationState() == LaneLoc {
+				cells = append(cells, loc)
+			}
+		}
+	}
+	return cells
+}
+
+// fillRing starts a ring road with the cars of every in root spread at random over the free cells of their street
+func (sim *GeneralLaneSimulation) fillRing() {
+	for _, street := range sim.streets {
+		cells := make([]*StatefulLocation, 0)
+		for _, loc := range sim.ringCells(street) {
+			if loc.noCars() {
+				cells = append(cells, loc)
+			}
+		}
+		order := sim.random.arrivals.Perm(len(cells))
+		for _, i := range order {
+			car := street.InRoot.pickCar(sim.random.arrivals)
+			if car == nil {
+				break
+			}
+			cells[i].addCar(car)
+			sim.enterTrip(car, street)
+			sim.MoveSmartCarInLane(car, cells[i])
+		}
+	}
+}
+
+// wrapsAround tells whether a car at the end of the street goes back to its start, and the cell it goes to
+func (sim *GeneralLaneSimulation) wrapsAround(street *Street, x int, y int) (int, int, bool) {
+	if !sim.config.ringRoad || !street.exit {
+		return 0, 0, false
+	}
+	if street.Direction.isHorizontal() {
+		return x, street.First, true
+	}
+	return street.First, y, true
+}
+
+// countLap counts a car going round, once the warm up is over
+func (sim *GeneralLaneSimulation) countLap() {
+	sim.runningSimulationLock.Lock()
+	defer sim.runningSimulationLock.Unlock()
+	if sim.scheduler.Now() >= sim.config.warmUp {
+		sim.ringLaps++
+	}
+}
+
+// endWarmUp starts the measurements
+func (sim *GeneralLaneSimulation) endWarmUp() {
+	sim.installDetectors()
+}
+
+// ringMetrics is safe to call once the simulation stopped
+func (sim *GeneralLaneSimulation) ringMetrics() RingMetrics {
+	metrics := RingMetrics{}
+	if !sim.config.ringRoad {
+		return metrics
+	}
+	sim.runningSimulationLock.Lock()
+	metrics.Laps = sim.ringLaps
+	measured := sim.simulatedTime - sim.config.warmUp
+	sim.runningSimulationLock.Unlock()
+	cells := map[*StatefulLocation]bool{}
+	for _, street := range sim.streets {
+		if street.exit {
+			metrics.Lanes += len(street.Lanes)
+		}
+		low, high := street.span()
+		for index := low; index <= high; index++ {
+			for _, loc := range sim.streetLanesAtIndex(street, index, AllLocationTypes) {
+				cells[loc] = true
+			}
+		}
+	}
+	for loc := range cells {
+		metrics.Cells++
+		metrics.Cars += len(loc.sortedCars())
+	}
+	if metrics.Cells > 0 {
+		metrics.Density = float64(metrics.Cars) / float64(metrics.Cells)
+	}
+	if metrics.Lanes > 0 && measured > 0 {
+		metrics.Flow = float64(metrics.Laps) / float64(metrics.Lanes) / measured
+	}
+	if metrics.Density > 0 {
+		metrics.Speed = metrics.Flow / metrics.Density
+	}
+	return metrics
+}
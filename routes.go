@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// streetTurn is a street a car can turn onto from another one
+type streetTurn struct {
+	to   *Street
+	at   int // cell along the street the car leaves where it can first turn
+	onto int // cell along the street it turns onto where it lands
+}
+
+// routeNode is a car on a street, index cells along it
+type routeNode struct {
+	street *Street
+	index  int
+}
+
+// RouteMetrics counts how the cars with a destination did, over the cars that left the grid
+type RouteMetrics struct {
+	NumRouted  int     `json:"numRouted"`
+	NumReached int     `json:"numReached"` // left through the street they were headed for
+	Reroutes   float64 `json:"reroutes"`   // route changes per routed car
+}
+
+// loadRoutes checks the OD matrix and finds where cars can turn from one street onto another
+func (sim *GeneralLaneSimulation) loadRoutes() error {
+	config := sim.config
+	if len(config.odMatrix) == 0 {
+		return nil
+	}
+	if config.congestionCost < 0 {
+		return errors.New("the congestion cost cannot be negative")
+	}
+	for origin, row := range config.odMatrix {
+		street := sim.streetNamed(origin)
+		if street == nil || !street.entry {
+			return fmt.Errorf("OD matrix origin %s is not an entry street", origin)
+		}
+		total := 0.0
+		for destination, weight := range row {
+			if street := sim.streetNamed(destination); street == nil || !street.exit {
+				return fmt.Errorf("OD matrix destination %s is not an exit street", destination)
+			}
+			if weight < 0 {
+				return fmt.Errorf("OD matrix weight from %s to %s is negative", origin, destination)
+			}
+			total += weight
+		}
+		if total == 0 {
+			return fmt.Errorf("OD matrix row of %s has no weight", origin)
+		}
+	}
+
+	sim.routeTurns = map[*Street][]streetTurn{}
+	for _, street := range sim.streets {
+		seen := map[*Street]bool{}
+		step := 1
+		if street.First > street.Last {
+			step = -1
+		}
+		for index := street.First; index != street.Last+step; index += step {
+			for _, loc := range sim.streetLanesAtIndex(street, index, AllLocationTypes) {
+				if loc.getLocationState() != Intersection {
+					continue
+				}
+				for _, target := range []Direction{street.Direction.left(), street.Direction.right()} {
+					if !sim.turnsOnto(target, loc.X, loc.Y) {
+						continue
+					}
+					to := sim.streetAt(target, loc.X, loc.Y)
+					if to == street || seen[to] {
+						continue
+					}
+					seen[to] = true
+					sim.routeTurns[street] = append(sim.routeTurns[street], streetTurn{to: to, at: index, onto: indexAlong(target, loc.X, loc.Y)})
+				}
+			}
+		}
+	}
+
+	for _, street := range sim.streets {
+		for _, car := range street.InRoot.sortedCars() {
+			sim.assignDestination(car, street)
+		}
+	}
+	return nil
+}
+
+func (sim *GeneralLaneSimulation) streetNamed(name string) *Street {
+	for _, street := range sim.streets {
+		if street.name() == name {
+			return street
+		}
+	}
+	return nil
+}
+
+// assignDestination draws where a car coming in through the street goes from its row of the OD matrix and plans its
+// route there. Cars from an entry without a row have no destination and drive by the turn ratios
+func (sim *GeneralLaneSimulation) assignDestination(car *SmartCar, street *Street) {
+	row := sim.config.odMatrix[street.name()]
+	if len(row) == 0 {
+		return
+	}
+	destinations := make([]string, 0, len(row))
+	total := 0.0
+	for destination, weight := range row {
+		destinations = append(destinations, destination)
+		total += weight
+	}
+	sort.Strings(destinations) // the map has no order
+	pick := sim.random.routes.UniformRand() * total
+	destination := destinations[len(destinations)-1]
+	for _, name := range destinations {
+		if pick < row[name] {
+			destination = name
+			break
+		}
+		pick -= row[name]
+	}
+	route := routeNames(sim.shortestRoute(street, street.First, sim.streetNamed(destination)))
+
+	car.smartCarLock.Lock()
+	defer car.smartCarLock.Unlock()
+	car.trip.Origin = street.name()
+	car.trip.Destination = destination
+	car.trip.Route = route
+}
+
+func routeNames(route []*Street) []string {
+	names := make([]string, 0, len(route))
+	for _, street := range route {
+		names = append(names, street.name())
+	}
+	return names
+}
+
+// routeMovement is the movement that keeps the car on its route at the intersection starting at loc. Cars without a
+// destination, or that cannot follow their route from here, leave it to chooseMovement.
+// Routes are planned again when the car left its route, and at every intersection with dynamic routing
+func (sim *GeneralLaneSimulation) routeMovement(car *SmartCar, loc *StatefulLocation) (Movement, bool) {
+	car.smartCarLock.Lock()
+	direction := car.Direction
+	destination := car.trip.Destination
+	route := car.trip.Route
+	car.smartCarLock.Unlock()
+	if destination == "" {
+		return Straight, false
+	}
+	street := sim.streetAt(direction, loc.X, loc.Y)
+	if street == nil {
+		return Straight, false
+	}
+
+	current := -1
+	for i, name := range route {
+		if name == street.name() {
+			current = i
+			break
+		}
+	}
+	if current >= 0 {
+		route = route[current:]
+	}
+	if current < 0 || sim.config.dynamicRouting {
+		planned := routeNames(sim.shortestRoute(street, indexAlong(direction, loc.X, loc.Y), sim.streetNamed(destination)))
+		if !sameRoute(planned, route) {
+			car.smartCarLock.Lock()
+			car.trip.Reroutes++
+			car.smartCarLock.Unlock()
+		}
+		route = planned
+	}
+	car.smartCarLock.Lock()
+	car.trip.Route = route
+	car.smartCarLock.Unlock()
+
+	if len(route) < 2 {
+		return Straight, len(route) == 1 && sim.canGoStraight(loc, direction)
+	}
+	next := sim.streetNamed(route[1])
+	if next == street.next {
+		return Straight, true
+	}
+	dx, dy := direction.step()
+	for x, y := loc.X, loc.Y; sim.inGrid(x, y) && sim.Locations[x][y].getLocationState() == Intersection; x, y = x+dx, y+dy {
+		if sim.turnsOnto(direction.left(), x, y) && sim.streetAt(direction.left(), x, y) == next {
+			return LeftTurn, true
+		}
+		if sim.turnsOnto(direction.right(), x, y) && sim.streetAt(direction.right(), x, y) == next {
+			return RightTurn, true
+		}
+	}
+	return Straight, sim.canGoStraight(loc, direction) // it turns at a later intersection
+}
+
+func sameRoute(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// shortestRoute is the cheapest list of streets from index along street to leaving through destination, nil if it
+// cannot be reached. A route costs the cells driven, plus congestionCost for every car per lane on them with dynamic
+// routing
+func (sim *GeneralLaneSimulation) shortestRoute(street *Street, index int, destination *Street) []*Street {
+	if destination == nil {
+		return nil
+	}
+	var occupancy map[*Street][]float64
+	if sim.config.dynamicRouting {
+		occupancy = sim.laneOccupancy()
+	}
+	segment := func(street *Street, from int, to int) float64 {
+		cost := float64(abs(to - from))
+		if occupancy == nil {
+			return cost
+		}
+		low, high := from, to
+		if low > high {
+			low, high = high, low
+		}
+		for i := low; i <= high; i++ {
+			cost += sim.config.congestionCost * occupancy[street][i]
+		}
+		return cost
+	}
+
+	start := routeNode{street, index}
+	cost := map[routeNode]float64{start: 0}
+	prev := map[routeNode]routeNode{}
+	done := map[routeNode]bool{}
+	open := []routeNode{start}
+	var best routeNode
+	bestCost := math.Inf(1)
+	for len(open) > 0 {
+		cheapest := 0
+		for i := range open {
+			if cost[open[i]] < cost[open[cheapest]] {
+				cheapest = i
+			}
+		}
+		node := open[cheapest]
+		open = append(open[:cheapest], open[cheapest+1:]...)
+		if done[node] {
+			continue
+		}
+		done[node] = true
+		if cost[node] >= bestCost {
+			break
+		}
+		if node.street == destination {
+			if total := cost[node] + segment(node.street, node.index, node.street.Last); total < bestCost {
+				best, bestCost = node, total
+			}
+		}
+		reach := func(next routeNode, total float64) {
+			if old, ok := cost[next]; done[next] || ok && old <= total {
+				return
+			}
+			cost[next] = total
+			prev[next] = node
+			open = append(open, next)
+		}
+		for _, turn := range sim.routeTurns[node.street] {
+			if (turn.at-node.index)*(node.street.Last-node.street.First) >= 0 { // ahead of the car
+				reach(routeNode{turn.to, turn.onto}, cost[node]+segment(node.street, node.index, turn.at)+1)
+			}
+		}
+		if next := node.street.next; next != nil {
+			reach(routeNode{next, next.First}, cost[node]+segment(node.street, node.index, node.street.Last)+1)
+		}
+	}
+	if math.IsInf(bestCost, 1) {
+		return nil
+	}
+	route := []*Street{best.street}
+	for node := best; node != start; {
+		node = prev[node]
+		route = append([]*Street{node.street}, route...)
+	}
+	return route
+}
+
+// laneOccupancy is the share of the lanes of every street taken at each cell along it
+func (sim *GeneralLaneSimulation) laneOccupancy() map[*Street][]float64 {
+	occupancy := map[*Street][]float64{}
+	for _, street := range sim.streets {
+		cells := make([]float64, sim.laneLength(street.Direction))
+		low, high := street.span()
+		for index := low; index <= high; index++ {
+			for _, loc := range sim.streetLanesAtIndex(street, index, AllLocationTypes) {
+				if !loc.isEmpty() {
+					cells[index] += 1 / float64(len(street.Lanes))
+				}
+			}
+		}
+		occupancy[street] = cells
+	}
+	return occupancy
+}
+
+// routeMetrics is safe to call once the simulation stopped
+func (sim *GeneralLaneSimulation) routeMetrics() RouteMetrics {
+	metrics := RouteMetrics{}
+	reroutes := 0
+	for _, record := range sim.tripRecords() {
+		trip := record.Trip
+		if trip.Destination == "" || !trip.Exited || trip.Removed {
+			continue
+		}
+		metrics.NumRouted++
+		reroutes += trip.Reroutes
+		if trip.ExitStreet == trip.Destination {
+			metrics.NumReached++
+		}
+	}
+	if metrics.NumRouted > 0 {
+		metrics.Reroutes = float64(reroutes) / float64(metrics.NumRouted)
+	}
+	return metrics
+}
@@ -2,156 +2,92 @@ package main
 
 import (
 	"fmt"
-	"time"
 )
 
-func RunExperiments() {
-	fmt.Println("Started experiment")
-	runExperimentCarDistributionTypeExperiment()
-	varyingAccidentProbExperiment()
-	intersectionProbabilityExperiment()
-	laneAccidentProb()
-
-	fmt.Println("Completed experiment")
-}
-
-func laneAccidentProb() {
-	fmt.Println("Test varying lane Accident Prob")
-	config := DefaultGeneralLaneConfig()
-	config.accidentScaling = false
-	config.accidentProb = 0
-	config.numVerticalCars = 10
-	config.numHorizontalCars = 10
-	config.numVerticalLanes = 1
-	config.numHorizontalLanes = 1
-	config.carMovementP = 1
-	config.accidentProb = 0
-	config.reSampleSpeedEveryClk = false
-
-	laneSwitchProb := []float64{0, 0.25, 0.5, 0.75, 1}
-	for _, prob := range laneSwitchProb {
-		config.probSwitchingLanes = prob
-		fmt.Println("lane switch probability: ", prob)
-		lanes := []int{1, 2, 5, 10}
-		for _, lane := range lanes {
-			fmt.Println("Lane size of: ", lane)
-			config.numVerticalLanes = lane
-			config.numHorizontalLanes = lane
-			runExperiment(config)
-		}
-
-	}
-
+// reportExperiments are the experiment files of the four experiments we defined in the report
+var reportExperiments = []string{
+	"experiments/car_distribution_type.yaml",
+	"experiments/varying_accident_prob.yaml",
+	"experiments/intersection_accident_prob.yaml",
+	"experiments/lane_accident_prob.yaml",
 }
 
-func intersectionProbabilityExperiment() {
-	fmt.Println("Test varying intersection accident probabilties")
-	config := DefaultGeneralLaneConfig()
-	config.accidentScaling = false
-	config.accidentProb = 0
-	config.numVerticalCars = 10
-	config.numHorizontalCars = 10
-	config.numVerticalLanes = 1
-	config.numHorizontalLanes = 1
-	config.carMovementP = 1
-	config.accidentProb = 0
-	config.reSampleSpeedEveryClk = false
-
-	intersectionAccidentProb := []float64{0, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1}
-	lambas := []float64{0, 0.5, 1, 2, 5}
-	for _, lambda := range lambas {
-		config.carClock = lambda
-		for _, prob := range intersectionAccidentProb {
-			config.intersectionAccidentProb = prob
-			fmt.Println("Accident probability: ", prob)
-			runExperiment(config)
-		}
-	}
-	println("Now with accident scaling")
-
-	config.accidentScaling = true
-	for _, lambda := range lambas {
-		config.carClock = lambda
-		for _, prob := range intersectionAccidentProb {
-			config.intersectionAccidentProb = prob
-			fmt.Println("Accident probability: ", prob)
-			runExperiment(config)
+// RunExperiments runs the experiment files of the report one after the other on GOMAXPROCS workers. The paths are
+// relative to the root of the repository
+func RunExperiments() error {
+	fmt.Println("Started experiment")
+	for _, path := range reportExperiments {
+		if err := RunExperimentFile(path, 0, 0, false, nil); err != nil {
+			return err
 		}
+		fmt.Println()
 	}
+	fmt.Println("Completed experiment")
+	return nil
 }
 
-func varyingAccidentProbExperiment() {
-	fmt.Println("Test varying accident probabilities")
-	config := DefaultGeneralLaneConfig()
-	config.accidentScaling = false
-	config.accidentProb = 0
-	config.numVerticalCars = 10
-	config.numHorizontalCars = 10
-	config.numVerticalLanes = 1
-	config.numHorizontalLanes = 1
-	config.reSampleSpeedEveryClk = true
-	config.carMovementP = 1
-	accidentProbs := []float64{0, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1}
-	for _, prob := range accidentProbs {
-		config.accidentProb = prob
-		fmt.Println("Accident probability: ", prob)
-		runExperiment(config)
-
-	}
-}
-func runExperimentCarDistributionTypeExperiment() {
-	fmt.Println("Test running time with different car simulations")
-	config := DefaultGeneralLaneConfig()
-	config.accidentScaling = false
-	config.accidentProb = 0
-	config.numVerticalCars = 10
-	config.numHorizontalCars = 10
-	config.numVerticalLanes = 1
-	config.numHorizontalLanes = 1
-	config.reSampleSpeedEveryClk = true
-	config.carMovementP = 1
-	accidentProbs := []float64{0, 0.25, 0.5, 0.75, 1}
-	for _, prob := range accidentProbs {
-		config.accidentProb = prob
-		fmt.Println("Accident probability: ", prob)
-		for i := 0; i <= 4; i++ {
-			config.CarDistributionType = convertToCarDistributionType(i)
-			fmt.Println("Car Distribution Type", i)
-			runExperiment(config)
+// RunFundamentalDiagram sweeps the arrival rate and the number of cars on a single horizontal lane, with a loop detector
+// on every location of the lane. Every aggregation window gives one flow-density point, printed as csv next to the
+// flow a TASEP with the same hopping rate would have at that density
+func RunFundamentalDiagram(alphas []float64, numCars []int, window float64) error {
+	fmt.Println("inAlpha,numHorizontalCars,windowStart,windowEnd,density,flow,spaceMeanSpeed,tasepFlow")
+	for _, alpha := range alphas {
+		for _, cars := range numCars {
+			config := DefaultGeneralLaneConfig()
+			config.numHorizontalLanes = 1
+			config.numVerticalLanes = 1
+			config.numVerticalCars = 0 // keeps the crossing lane empty
+			config.numHorizontalCars = cars
+			config.inAlpha = alpha
+			config.detectorWindow = window
+			row, _ := medianBasedRange(config.sizeOfLane, config.numHorizontalLanes) // like horizontalIndexRange
+			for j := 0; j < config.sizeOfLane; j++ {
+				config.detectors = append(config.detectors, LocationRef{X: row, Y: j})
+			}
+
+			simulation, err := initMultiLaneSimulation(config)
+			if err != nil {
+				return err
+			}
+			runHeadless(simulation)
+
+			rate := config.carClock * config.carMovementP
+			reports := simulation.detectorReports()
+			if len(reports) == 0 {
+				return fmt.Errorf("no detector measured inAlpha %g with %d cars", alpha, cars)
+			}
+			for i := range reports[0].Windows {
+				var density, flow, numLeft, dwell float64
+				for _, report := range reports {
+					measured := report.Windows[i]
+					density += measured.Density
+					flow += measured.Flow
+					if measured.SpaceMeanSpeed > 0 {
+						numLeft++
+						dwell += 1 / measured.SpaceMeanSpeed
+					}
+				}
+				density /= float64(len(reports))
+				flow /= float64(len(reports))
+				speed := 0.0
+				if dwell > 0 {
+					speed = numLeft / dwell
+				}
+				start, end := reports[0].Windows[i].Start, reports[0].Windows[i].End
+				fmt.Printf("%g,%d,%.3f,%.3f,%.4f,%.4f,%.4f,%.4f\n",
+					alpha, cars, start, end, density, flow, speed, tasepFlow(rate, density))
+			}
 		}
 	}
+	return nil
 }
 
-func runExperiment(config *GeneralLaneSimulationConfig) {
-	fmt.Println("start run simulation")
-
-	simulation, err := initMultiLaneSimulation(config)
+// runHeadless runs the simulation to the end without drawing it
+func runHeadless(simulation *GeneralLaneSimulation) {
 	simulation.setRunningSimulation(true)
-	if err != nil {
-		// TODO handle this
-	}
-	start := time.Now()
-
-	go RunGeneralSimulation(simulation)
-	for {
-		if !simulation.isRunningSimulation() {
-			fmt.Println("General Lane Simulation completed")
-			t := time.Now()
-
-			elapsed := t.Sub(start)
-			fmt.Println("Time Elapsed ", elapsed)
-
-			simulation.runningSimulationLock.Lock()
-			fmt.Println("Num Accidents ", simulation.numAccidents)
-			simulation.runningSimulationLock.Unlock()
-			return
+	go func() {
+		for range simulation.drawUpdateChan {
 		}
-
-		select {
-		case <-simulation.drawUpdateChan:
-			fmt.Println(simulation)
-			break
-		}
-		time.Sleep(1000)
-	}
+	}()
+	RunGeneralSimulation(simulation)
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+)
+
+// SimEventType identifies which clock of the general simulation fired
+type SimEventType int
+
+const (
+	carInEvent SimEventType = iota
+	carOutEvent
+	carClockEvent
+	accidentEvent
+	parkingEvent
+	slowCarEvent
+	warmUpEvent
+	closureStartEvent
+	closureEndEvent
+	signalEvent
+	emergencyEvent
+	responderEvent
+)
+
+// SimEvent is one pending occurrence on the simulated clock
+type SimEvent struct {
+	Time  float64
+	Type  SimEventType
+	seq   uint64 // breaks ties between events at the same time in scheduling order
+	index int    // position in the heap
+
+	street   *Street // whose in or out bin fires
+	car      *SmartCar
+	carLoc   *StatefulLocation
+	accident *Accident
+	parking  *Parking
+	slowCar  *SlowCar
+	closure  int // index among the closures of the config
+	signal   int // index among the signals
+	dispatch int // index among the emergency vehicles of the schedule
+}
+
+type eventQueue []*SimEvent
+
+func (queue eventQueue) Len() int { return len(queue) }
+
+func (queue eventQueue) Less(i, j int) bool {
+	if queue[i].Time == queue[j].Time {
+		return queue[i].seq < queue[j].seq
+	}
+	return queue[i].Time < queue[j].Time
+}
+
+func (queue eventQueue) Swap(i, j int) {
+	queue[i], queue[j] = queue[j], queue[i]
+	queue[i].index = i
+	queue[j].index = j
+}
+
+func (queue *eventQueue) Push(x interface{}) {
+	event := x.(*SimEvent)
+	event.index = len(*queue)
+	*queue = append(*queue, event)
+}
+
+func (queue *eventQueue) Pop() interface{} {
+	old := *queue
+	n := len(old)
+	event := old[n-1]
+	old[n-1] = nil
+	event.index = -1
+	*queue = old[:n-1]
+	return event
+}
+
+// Scheduler is a discrete event scheduler. It keeps the simulated clock and hands out events in time order
+type Scheduler struct {
+	now          float64
+	queue        eventQueue
+	nextSeq      uint64
+	numProcessed int
+}
+
+func newScheduler() *Scheduler {
+	scheduler := &Scheduler{queue: make(eventQueue, 0)}
+	heap.Init(&scheduler.queue)
+	return scheduler
+}
+
+// Now is the current simulated time
+func (scheduler *Scheduler) Now() float64 {
+	return scheduler.now
+}
+
+// schedule queues the event delay simulated seconds from now. An exponential clock with a zero or negative
+// rate never fires, so infinite or invalid delays are dropped and false is returned
+func (scheduler *Scheduler) schedule(delay float64, event *SimEvent) bool {
+	if math.IsNaN(delay) || math.IsInf(delay, 0) || delay < 0 {
+		return false
+	}
+	event.Time = scheduler.now + delay
+	event.seq = scheduler.nextSeq
+	scheduler.nextSeq++
+	heap.Push(&scheduler.queue, event)
+	return true
+}
+
+// restore puts back an event taken from a snapshot, keeping its time and order
+func (scheduler *Scheduler) restore(event *SimEvent) {
+	heap.Push(&scheduler.queue, event)
+}
+
+// cancel removes the event if it is still pending
+func (scheduler *Scheduler) cancel(event *SimEvent) {
+	if event == nil || event.index < 0 || event.index >= len(scheduler.queue) || scheduler.queue[event.index] != event {
+		return
+	}
+	heap.Remove(&scheduler.queue, event.index)
+}
+
+// advanceTo moves the clock forward without processing anything
+func (scheduler *Scheduler) advanceTo(time float64) {
+	if time > scheduler.now {
+		scheduler.now = time
+	}
+}
+
+// peek returns the next event without removing it
+func (scheduler *Scheduler) peek() *SimEvent {
+	if len(scheduler.queue) == 0 {
+		return nil
+	}
+	return scheduler.queue[0]
+}
+
+// next removes the earliest event and advances the clock to it
+func (scheduler *Scheduler) next() *SimEvent {
+	if len(scheduler.queue) == 0 {
+		return nil
+	}
+	event := heap.Pop(&scheduler.queue).(*SimEvent)
+	scheduler.now = event.Time
+	scheduler.numProcessed++
+	return event
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSchedulerOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		delays []float64 // scheduled from time 0, the event at i gets signal i
+		order  []int     // signals in the order the events come out
+	}{
+		{"by time", []float64{3, 1, 2}, []int{1, 2, 0}},
+		{"ties in scheduling order", []float64{1, 1, 1}, []int{0, 1, 2}},
+		{"ties among other times", []float64{2, 1, 2, 0, 1}, []int{3, 1, 4, 0, 2}},
+		{"zero delay", []float64{0, 5, 0}, []int{0, 2, 1}},
+		{"invalid delays dropped", []float64{1, -1, math.Inf(1), math.NaN(), 0.5}, []int{4, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheduler := newScheduler()
+			for i, delay := range test.delays {
+				scheduler.schedule(delay, &SimEvent{Type: signalEvent, signal: i})
+			}
+			for _, want := range test.order {
+				event := scheduler.next()
+				if event == nil {
+					t.Fatalf("ran out of events, wanted signal %d", want)
+				}
+				if event.signal != want {
+					t.Fatalf("got signal %d, want %d", event.signal, want)
+				}
+				if scheduler.Now() != event.Time {
+					t.Fatalf("now is %v after an event at %v", scheduler.Now(), event.Time)
+				}
+			}
+			if event := scheduler.next(); event != nil {
+				t.Fatalf("got signal %d after the last event", event.signal)
+			}
+		})
+	}
+}
+
+func TestSchedulerNow(t *testing.T) {
+	scheduler := newScheduler()
+	if scheduler.Now() != 0 {
+		t.Fatalf("a new scheduler starts at %v", scheduler.Now())
+	}
+	scheduler.schedule(2, &SimEvent{signal: 0})
+	scheduler.next()
+	scheduler.schedule(1.5, &SimEvent{signal: 1}) // delays count from now
+	cancelled := &SimEvent{signal: 2}
+	scheduler.schedule(0.5, cancelled)
+	scheduler.cancel(cancelled)
+	if event := scheduler.next(); event.signal != 1 || event.Time != 3.5 || scheduler.Now() != 3.5 {
+		t.Fatalf("got signal %d at %v, now %v, want signal 1 at 3.5", event.signal, event.Time, scheduler.Now())
+	}
+
+	scheduler.advanceTo(10)
+	scheduler.advanceTo(4) // the clock never goes back
+	if scheduler.Now() != 10 {
+		t.Fatalf("now is %v after advancing to 10", scheduler.Now())
+	}
+	if scheduler.peek() != nil {
+		t.Fatal("the cancelled event is still pending")
+	}
+}
@@ -68,6 +68,10 @@ func addRoutes(router *chi.Mux) *chi.Mux {
 	FileServer(router, "/static", http.Dir(filepath.Join(workDir, "frontend", "public")))
 	router.Get("/", index)
 	router.HandleFunc("/ws", wsHandler)
+	router.Post("/gym/reset", gymReset)
+	router.Post("/gym/step", gymStep)
+	router.Get("/gym/observe", gymObserve)
+	router.Post("/gym/close", gymClose)
 	return router
 }
 
@@ -0,0 +1,215 @@
+package main
+
+const (
+	actuatedSignals    = "actuated"    // a green lasts while cars keep coming, between the min and max green
+	maxPressureSignals = "maxPressure" // every min green the phase with the most queued cars against free room goes
+	externalSignals    = "external"    // an agent sets the phases through the gym API
+)
+
+// newSignalController makes the controller of a signal. Controllers draw no random numbers, so runs with the same
+// seed get the same arrivals whatever controls the signals
+func newSignalController(control string) signalController {
+	switch control {
+	case actuatedSignals:
+		return actuatedController{}
+	case maxPressureSignals:
+		return maxPressureController{}
+	case externalSignals:
+		return externalController{}
+	}
+	return fixedController{}
+}
+
+// actuatedController keeps the phase green while cars are on its detectors, extension by extension up to the max
+// green. Once they stopped coming, or the max green is reached, it goes to the other phase if cars wait there and
+// otherwise rests on the green it has
+type actuatedController struct{}
+
+// signalTimeTolerance keeps the rounding of the green time from leaving a max green an extension too small for the
+// clock to move
+const signalTimeTolerance = 1e-9
+
+func (actuatedController) firstGreen(signal *Signal) float64 {
+	return signal.plan.MinGreen
+}
+
+func (actuatedController) next(sim *GeneralLaneSimulation, signal *Signal) (int, float64) {
+	plan := signal.plan
+	other := (signal.phase + 1) % signalPhases
+	green := sim.scheduler.Now() - signal.since
+	if green < plan.MaxGreen-signalTimeTolerance && sim.phaseQueue(signal, signal.phase, plan.Detector) > 0 {
+		extension := plan.Extension
+		if green+extension > plan.MaxGreen {
+			extension = plan.MaxGreen - green
+		}
+		return signal.phase, extension
+	}
+	if sim.phaseQueue(signal, other, plan.Detector) > 0 {
+		return other, plan.MinGreen
+	}
+	return signal.phase, plan.Extension
+}
+
+// maxPressureController gives every min green to the phase with the highest pressure, the cars queued on its
+// approaches less the cars on the cells past the intersection they drive onto. A tie keeps the current phase
+type maxPressureController struct{}
+
+func (maxPressureController) firstGreen(signal *Signal) float64 {
+	return signal.plan.MinGreen
+}
+
+func (maxPressureController) next(sim *GeneralLaneSimulation, signal *Signal) (int, float64) {
+	best := signal.phase
+	bestPressure := sim.phasePressure(signal, best)
+	for phase := 0; phase < signalPhases; phase++ {
+		if pressure := sim.phasePressure(signal, phase); pressure > bestPressure {
+			best, bestPressure = phase, pressure
+		}
+	}
+	return best, signal.plan.MinGreen
+}
+
+// externalController shows the phase it was last asked for, each green lasting at least the min green. Until it is
+// asked for another phase it keeps the one it has
+type externalController struct{}
+
+func (externalController) firstGreen(signal *Signal) float64 {
+	return signal.plan.MinGreen
+}
+
+func (externalController) next(sim *GeneralLaneSimulation, signal *Signal) (int, float64) {
+	if signal.requested == signal.phase {
+		return signal.phase, signal.plan.Extension
+	}
+	return signal.requested, signal.plan.MinGreen
+}
+
+// requestPhase asks the signal for a phase, an external one leaves its green for it as soon as the min green allows
+func (sim *GeneralLaneSimulation) requestPhase(signal *Signal, phase int) {
+	signal.requested = phase
+	if signal.state != signalGreen || phase == signal.phase || signal.event == nil {
+		return
+	}
+	change := signal.since + signal.plan.MinGreen
+	if change < sim.scheduler.Now() {
+		change = sim.scheduler.Now()
+	}
+	if change < signal.event.Time {
+		sim.scheduler.cancel(signal.event)
+		sim.scheduleSignalChange(signal.ID, change-sim.scheduler.Now())
+	}
+}
+
+// boxApproaches lists the directions of the lanes that drive into the intersection
+func (sim *GeneralLaneSimulation) boxApproaches(box []*StatefulLocation) []Direction {
+	approaches := make([]Direction, 0)
+	for _, direction := range []Direction{Horizontal, Vertical, Westbound, Northbound} {
+		if len(sim.approachCells(box, direction, -1)) > 0 {
+			approaches = append(approaches, direction)
+		}
+	}
+	return approaches
+}
+
+// boxBounds are the first and last row and column of the intersection
+func boxBounds(box []*StatefulLocation) (int, int, int, int) {
+	minRow, maxRow, minColumn, maxColumn := box[0].X, box[0].X, box[0].Y, box[0].Y
+	for _, loc := range box {
+		if loc.X < minRow {
+			minRow = loc.X
+		}
+		if loc.X > maxRow {
+			maxRow = loc.X
+		}
+		if loc.Y < minColumn {
+			minColumn = loc.Y
+		}
+		if loc.Y > maxColumn {
+			maxColumn = loc.Y
+		}
+	}
+	return minRow, maxRow, minColumn, maxColumn
+}
+
+// approachCells are the cells of the lanes going direction through the intersection, distance cells before it when
+// distance is negative and after it when positive
+func (sim *GeneralLaneSimulation) approachCells(box []*StatefulLocation, direction Direction, distance int) []*StatefulLocation {
+	minRow, maxRow, minColumn, maxColumn := boxBounds(box)
+	first, last, low, high := minRow, maxRow, minColumn, maxColumn // along and across the lanes
+	if direction.isHorizontal() {
+		first, last, low, high = minColumn, maxColumn, minRow, maxRow
+	}
+	forward := 1
+	if direction.isReversed() { // first and last in driving order
+		first, last, forward = last, first, -1
+	}
+	index := last + distance*forward
+	if distance < 0 {
+		index = first + distance*forward
+	}
+	var lanes []*StatefulLocation
+	switch direction {
+	case Horizontal:
+		lanes = sim.getHorizontalLanesAtIndex(index, AllLocationTypes)
+	case Vertical:
+		lanes = sim.getVerticalLanesAtIndex(index, AllLocationTypes)
+	default: // the opposing lanes of two way streets
+		for _, street := range sim.streetsGoing(direction) {
+			lanes = append(lanes, sim.streetLanesAtIndex(street, index, AllLocationTypes)...)
+		}
+	}
+	cells := make([]*StatefulLocation, 0, len(lanes))
+	for _, loc := range lanes {
+		across := loc.X
+		if !direction.isHorizontal() {
+			across = loc.Y
+		}
+		if across >= low && across <= high {
+			cells = append(cells, loc)
+		}
+	}
+	return cells
+}
+
+// approachQueue counts the cars on the cells cells before the intersection on the lanes going direction into it
+func (sim *GeneralLaneSimulation) approachQueue(box []*StatefulLocation, direction Direction, cells int) int {
+	queue := 0
+	for distance := 1; distance <= cells; distance++ {
+		for _, loc := range sim.approachCells(box, direction, -distance) {
+			if !loc.isEmpty() {
+				queue++
+			}
+		}
+	}
+	return queue
+}
+
+// phaseQueue counts the cars waiting on the approaches the phase serves, within cells of the intersection
+func (sim *GeneralLaneSimulation) phaseQueue(signal *Signal, phase int, cells int) int {
+	queue := 0
+	for _, direction := range signal.approaches {
+		if serves(phase, direction) {
+			queue += sim.approachQueue(signal.cells, direction, cells)
+		}
+	}
+	return queue
+}
+
+// phasePressure is the queue of the phase within the detector less the cars on the detector cells past the
+// intersection, that the cars of the phase drive onto
+func (sim *GeneralLaneSimulation) phasePressure(signal *Signal, phase int) int {
+	pressure := sim.phaseQueue(signal, phase, signal.plan.Detector)
+	for _, direction := range signal.approaches {
+		if !serves(phase, direction) {
+			continue
+		}
+		for distance := 1; distance <= signal.plan.Detector; distance++ {
+			for _, loc := range sim.approachCells(signal.cells, direction, distance) {
+				if !loc.isEmpty() {
+					pressure--
+				}
+			}
+		}
+	}
+	return pressure
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// fixedSignals runs every signal on its plan, whatever the traffic
+const fixedSignals = "fixed"
+
+// signalPhases are the phases of every signal, phase 0 lets the east west approaches go and phase 1 the north
+// south ones
+const signalPhases = 2
+
+// defaultSignalPlan times the signals no plan of the config is for
+var defaultSignalPlan = SignalPlan{Cycle: 30, Yellow: 2, AllRed: 1, MinGreen: 5, MaxGreen: 20, Extension: 2, Detector: 3}
+
+// SignalPlan times the signal of an intersection. Each phase of a fixed signal gets its split of the green time left
+// in the cycle once every phase had its yellow and all red interval. Actuated and max pressure signals leave out the
+// cycle and the splits, a zero green, extension or detector takes the one of the default plan
+type SignalPlan struct {
+	At     *[2]int   `json:"at,omitempty"` // row and column of a cell of the intersection, every other one when missing
+	Cycle  float64   `json:"cycle"`
+	Splits []float64 `json:"splits,omitempty"` // weights of the east west and the north south green, equal when empty
+	Yellow float64   `json:"yellow"`
+	AllRed float64   `json:"allRed"`
+	Offset float64   `json:"offset"` // the signal shows all red until then, to coordinate it with its neighbours
+
+	MinGreen  float64 `json:"minGreen,omitempty"`
+	MaxGreen  float64 `json:"maxGreen,omitempty"`
+	Extension float64 `json:"extension,omitempty"` // green an actuated signal adds while cars wait for it
+	Detector  int     `json:"detector,omitempty"`  // cells before and after the intersection its queues are counted on
+}
+
+// SignalState is what a signal shows for the phase it is on
+type SignalState int
+
+const (
+	signalGreen SignalState = iota
+	signalYellow
+	signalAllRed
+)
+
+// Lights a signal shows an approach
+const (
+	greenLight  = "green"
+	yellowLight = "yellow"
+	redLight    = "red"
+)
+
+// signalController picks the phases of a signal. Once the green of the current phase ran out it gives the phase to
+// show next and how long it stays green, giving the current phase again extends its green by that time
+type signalController interface {
+	firstGreen(signal *Signal) float64 // the green of phase 0, that every signal starts on
+	next(sim *GeneralLaneSimulation, signal *Signal) (int, float64)
+}
+
+// fixedController goes through the phases in turn, each green for its split of the cycle
+type fixedController struct{}
+
+func (fixedController) firstGreen(signal *Signal) float64 {
+	return signal.plan.green(0)
+}
+
+func (fixedController) next(sim *GeneralLaneSimulation, signal *Signal) (int, float64) {
+	phase := (signal.phase + 1) % signalPhases
+	return phase, signal.plan.green(phase)
+}
+
+// Signal is the traffic light of an intersection
+type Signal struct {
+	ID         int
+	cells      []*StatefulLocation // the intersection
+	plan       SignalPlan
+	controller signalController
+
+	approaches []Direction // the directions cars drive into the intersection
+	changes    int         // times the signal went to another phase
+	requested  int         // the phase an external controller was asked for
+	event      *SimEvent   // ends the current state
+
+	preempt     int    // the phase of the emergency vehicle the signal turns green for
+	preemptedBy string // that vehicle, empty when none is near
+	preemptions int
+
+	signalLock sync.Mutex // the state is read when drawing
+	phase      int        // green or yellow, or the one turning green once the all red ends
+	next       int        // the phase after the yellow
+	state      SignalState
+	since      float64 // when the state started, an extended green keeps its start
+	nextGreen  float64 // how long the phase stays green once the all red ends
+}
+
+// SignalMetrics is how the signals held up the cars that left the grid
+type SignalMetrics struct {
+	NumSignals    int     `json:"numSignals"`
+	PhaseChanges  float64 `json:"phaseChanges"`  // per signal
+	RedLightStops int     `json:"redLightStops"` // moves a red light stopped, of every car
+	StoppedShare  float64 `json:"stoppedShare"`  // share of the cars stopped by at least one red light
+	RedLightWait  Summary `json:"redLightWait"`  // time spent waiting at red lights by the cars that were stopped
+}
+
+// JsonSignal is a signal as the frontend draws it
+type JsonSignal struct {
+	ID         int      `json:"id"`
+	Cells      [][2]int `json:"cells"`
+	Phase      int      `json:"phase"`
+	Horizontal string   `json:"horizontal"` // light of the east west approaches
+	Vertical   string   `json:"vertical"`   // light of the north south approaches
+}
+
+// withDefaults fills the greens, extension and detector left at zero from the default plan
+func (plan SignalPlan) withDefaults() SignalPlan {
+	if plan.MinGreen == 0 {
+		plan.MinGreen = defaultSignalPlan.MinGreen
+	}
+	if plan.MaxGreen == 0 {
+		plan.MaxGreen = defaultSignalPlan.MaxGreen
+	}
+	if plan.Extension == 0 {
+		plan.Extension = defaultSignalPlan.Extension
+	}
+	if plan.Detector == 0 {
+		plan.Detector = defaultSignalPlan.Detector
+	}
+	return plan
+}
+
+func (plan SignalPlan) validate(control string) error {
+	if plan.Yellow < 0 || plan.AllRed < 0 || plan.Offset < 0 {
+		return errors.New("yellow, all red and offset cannot be negative")
+	}
+	// fixed plans check them too, an emergency vehicle holds any green for an extension
+	if plan.MinGreen < 0 || plan.MaxGreen < 0 || plan.Extension < 0 || plan.Detector < 0 {
+		return errors.New("the greens, extension and detector cannot be negative")
+	}
+	if control != fixedSignals {
+		if plan.MaxGreen < plan.MinGreen {
+			return errors.New("the max green is shorter than the min green")
+		}
+		return nil
+	}
+	if plan.Cycle <= signalPhases*(plan.Yellow+plan.AllRed) {
+		return errors.New("the cycle leaves no green once every phase had its yellow and all red")
+	}
+	if len(plan.Splits) == 0 {
+		return nil
+	}
+	if len(plan.Splits) != signalPhases {
+		return errors.New("the splits need an east west and a north south share")
+	}
+	for _, split := range plan.Splits {
+		if split <= 0 {
+			return errors.New("every split has to be positive")
+		}
+	}
+	return nil
+}
+
+// green is how long the phase stays green in every cycle
+func (plan SignalPlan) green(phase int) float64 {
+	green := plan.Cycle - signalPhases*(plan.Yellow+plan.AllRed)
+	if len(plan.Splits) == 0 {
+		return green / signalPhases
+	}
+	total := 0.0
+	for _, split := range plan.Splits {
+		total += split
+	}
+	return green * plan.Splits[phase] / total
+}
+
+// serves tells whether the phase lets cars going direction go
+func serves(phase int, direction Direction) bool {
+	return (phase == 0) == direction.isHorizontal()
+}
+
+// light is what the signal shows cars going direction
+func (signal *Signal) light(direction Direction) string {
+	signal.signalLock.Lock()
+	defer signal.signalLock.Unlock()
+	if signal.state == signalAllRed || !serves(signal.phase, direction) {
+		return redLight
+	}
+	if signal.state == signalYellow {
+		return yellowLight
+	}
+	return greenLight
+}
+
+func (signal *Signal) setState(state SignalState, since float64) {
+	signal.signalLock.Lock()
+	defer signal.signalLock.Unlock()
+	signal.state = state
+	signal.since = since
+	if state == signalAllRed {
+		signal.phase = signal.next
+	}
+}
+
+// loadSignals puts a signal on every intersection, or on the signalized nodes of a network, and gives each its plan
+func (sim *GeneralLaneSimulation) loadSignals(layout gridLayout) error {
+	config := sim.config
+	sim.signalAt = map[*StatefulLocation]*Signal{}
+	sim.signals = nil
+	switch config.signalControl {
+	case "":
+		if len(config.signalPlans) > 0 {
+			return errors.New("signal plans need a signal control")
+		}
+		return nil
+	case fixedSignals, actuatedSignals, maxPressureSignals, externalSignals:
+	default:
+		return fmt.Errorf("unknown signal control %q, use %s, %s, %s or %s", config.signalControl, fixedSignals,
+			actuatedSignals, maxPressureSignals, externalSignals)
+	}
+
+	boxes := sim.intersectionBoxes()
+	if config.networkFile != "" {
+		signalCells := map[*StatefulLocation]bool{}
+		for _, cell := range layout.signals {
+			signalCells[sim.Locations[cell[0]][cell[1]]] = true
+		}
+		signalized := make([][]*StatefulLocation, 0)
+		for _, box := range boxes {
+			for _, loc := range box {
+				if signalCells[loc] {
+					signalized = append(signalized, box)
+					break
+				}
+			}
+		}
+		if len(signalized) == 0 {
+			return errors.New("the network has no signalized intersections")
+		}
+		boxes = signalized
+	}
+	for _, box := range boxes {
+		signal := &Signal{ID: len(sim.signals), cells: box, plan: defaultSignalPlan}
+		sim.signals = append(sim.signals, signal)
+		for _, loc := range box {
+			sim.signalAt[loc] = signal
+		}
+	}
+
+	planned := map[*Signal]bool{}
+	for i, plan := range config.signalPlans {
+		plan = plan.withDefaults()
+		if err := plan.validate(config.signalControl); err != nil {
+			return errors.Wrapf(err, "signal plan %d", i)
+		}
+		if plan.At == nil {
+			if planned[nil] {
+				return errors.New("there are two signal plans for every other intersection")
+			}
+			planned[nil] = true
+			for _, signal := range sim.signals {
+				if !planned[signal] {
+					signal.plan = plan
+				}
+			}
+			continue
+		}
+		row, column := plan.At[0], plan.At[1]
+		if !sim.inGrid(row, column) || sim.signalAt[sim.Locations[row][column]] == nil {
+			return fmt.Errorf("signal plan %d: there is no signal at %d,%d", i, row, column)
+		}
+		signal := sim.signalAt[sim.Locations[row][column]]
+		if planned[signal] {
+			return fmt.Errorf("signal plan %d: the signal at %d,%d already has a plan", i, row, column)
+		}
+		planned[signal] = true
+		signal.plan = plan
+	}
+	for _, signal := range sim.signals {
+		signal.controller = newSignalController(config.signalControl)
+		signal.approaches = sim.boxApproaches(signal.cells)
+		signal.state = signalAllRed
+	}
+	return nil
+}
+
+// intersectionBoxes groups the intersection cells that touch each other, in grid order
+func (sim *GeneralLaneSimulation) intersectionBoxes() [][]*StatefulLocation {
+	boxes := make([][]*StatefulLocation, 0)
+	seen := map[*StatefulLocation]bool{}
+	for _, row := range sim.Locations {
+		for _, loc := range row {
+			if seen[loc] || loc.getLocationState() != Intersection {
+				continue
+			}
+			seen[loc] = true
+			box := []*StatefulLocation{loc}
+			for i := 0; i < len(box); i++ {
+				for _, direction := range []Direction{Horizontal, Vertical, Westbound, Northbound} {
+					dx, dy := direction.step()
+					x, y := box[i].X+dx, box[i].Y+dy
+					if !sim.inGrid(x, y) {
+						continue
+					}
+					next := sim.Locations[x][y]
+					if !seen[next] && next.getLocationState() == Intersection {
+						seen[next] = true
+						box = append(box, next)
+					}
+				}
+			}
+			boxes = append(boxes, box)
+		}
+	}
+	return boxes
+}
+
+// scheduleSignals starts every signal on all red, turning to the first green at its offset
+func (sim *GeneralLaneSimulation) scheduleSignals() {
+	for i, signal := range sim.signals {
+		signal.next = 0
+		signal.setState(signalAllRed, sim.scheduler.Now())
+		signal.nextGreen = signal.controller.firstGreen(signal)
+		sim.scheduleSignalChange(i, signal.plan.Offset)
+	}
+}
+
+// changeSignal ends the current state of signal i. A green is extended or turns yellow, a yellow turns all red and
+// an all red turns the next phase green
+func (sim *GeneralLaneSimulation) changeSignal(i int) {
+	signal := sim.signals[i]
+	now := sim.scheduler.Now()
+	var delay float64
+	switch signal.state {
+	case signalGreen:
+		phase, green := signal.controller.next(sim, signal)
+		if signal.preemptedBy != "" { // an emergency vehicle is coming, its phase stays green until it went by
+			phase, green = signal.preempt, signal.plan.Extension
+		}
+		if phase == signal.phase {
+			sim.scheduleSignalChange(i, green)
+			return
+		}
+		signal.next = phase
+		signal.nextGreen = green
+		signal.changes++
+		signal.setState(signalYellow, now)
+		delay = signal.plan.Yellow
+	case signalYellow:
+		signal.setState(signalAllRed, now)
+		delay = signal.plan.AllRed
+	default:
+		signal.setState(signalGreen, now)
+		delay = signal.nextGreen
+	}
+	sim.scheduleSignalChange(i, delay)
+	sim.notifyDraw()
+}
+
+// scheduleSignalChange schedules the end of the current state of signal i
+func (sim *GeneralLaneSimulation) scheduleSignalChange(i int, delay float64) {
+	event := &SimEvent{Type: signalEvent, signal: i}
+	sim.scheduler.schedule(delay, event)
+	sim.signals[i].event = event
+}
+
+// signalAllows tells whether a car going direction may enter the intersection at loc. Cars go on green and yellow,
+// intersections without a signal are always open
+func (sim *GeneralLaneSimulation) signalAllows(loc *StatefulLocation, direction Direction) bool {
+	signal := sim.signalAt[loc]
+	return signal == nil || signal.light(direction) != redLight
+}
+
+// tripRedLight counts a move a red light stopped, the car waits at the light until it enters the intersection
+func (sim *GeneralLaneSimulation) tripRedLight(car *SmartCar) {
+	sim.tripBlocked(car)
+	sim.startTripInterval(car, redLightInterval)
+	car.smartCarLock.Lock()
+	defer car.smartCarLock.Unlock()
+	car.trip.RedLightStops++
+}
+
+func (sim *GeneralLaneSimulation) jsonSignals() []JsonSignal {
+	signals := make([]JsonSignal, 0, len(sim.signals))
+	for _, signal := range sim.signals {
+		cells := make([][2]int, 0, len(signal.cells))
+		for _, loc := range signal.cells {
+			cells = append(cells, [2]int{loc.X, loc.Y})
+		}
+		signal.signalLock.Lock()
+		phase := signal.phase
+		signal.signalLock.Unlock()
+		signals = append(signals, JsonSignal{ID: signal.ID, Cells: cells, Phase: phase,
+			Horizontal: signal.light(Horizontal), Vertical: signal.light(Vertical)})
+	}
+	return signals
+}
+
+// signalMetrics is safe to call once the simulation stopped
+func (sim *GeneralLaneSimulation) signalMetrics() SignalMetrics {
+	metrics := SignalMetrics{NumSignals: len(sim.signals)}
+	for _, signal := range sim.signals {
+		metrics.PhaseChanges += float64(signal.changes) / float64(len(sim.signals))
+	}
+	var waits []float64
+	finished := 0
+	for _, record := range sim.tripRecords() {
+		trip := record.Trip
+		metrics.RedLightStops += trip.RedLightStops
+		if !trip.Exited || trip.Removed {
+			continue
+		}
+		finished++
+		if trip.RedLightStops > 0 {
+			waits = append(waits, trip.RedLight.Total)
+		}
+	}
+	if finished > 0 {
+		metrics.StoppedShare = float64(len(waits)) / float64(finished)
+	}
+	metrics.RedLightWait = summarize(waits)
+	return metrics
+}
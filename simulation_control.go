@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"time"
+)
+
+// SimulationControlType is an operation a viewer can apply to a running general simulation
+type SimulationControlType int
+
+const (
+	pauseControl SimulationControlType = iota
+	resumeControl
+	stepControl      // process numEvents events and pause
+	advanceToControl // process every event up to time and pause
+	speedControl     // change the playback speed multiplier
+	snapshotControl  // save the state to path and answer on reply
+	agentStepControl // set the signal phases, process every event up to time, pause and answer on reply
+)
+
+// SimulationControl is a request sent to the goroutine running the simulation
+type SimulationControl struct {
+	controlType SimulationControlType
+	numEvents   int
+	time        float64
+	speed       float64
+	path        string
+	phases      []int
+	reply       chan error
+}
+
+// sendControl queues the request for the simulation loop. Requests are dropped if nobody is running the simulation
+func (sim *GeneralLaneSimulation) sendControl(control SimulationControl) {
+	if !sim.isRunningSimulation() {
+		return
+	}
+	select {
+	case sim.controlChan <- control:
+	default:
+		log.Println("dropping simulation control, too many pending")
+	}
+}
+
+func (sim *GeneralLaneSimulation) isPaused() bool {
+	sim.runningSimulationLock.Lock()
+	defer sim.runningSimulationLock.Unlock()
+	return sim.paused
+}
+
+func (sim *GeneralLaneSimulation) setPaused(paused bool) {
+	sim.runningSimulationLock.Lock()
+	defer sim.runningSimulationLock.Unlock()
+	sim.paused = paused
+}
+
+// getSimulatedTime is safe to call from outside of the simulation loop
+func (sim *GeneralLaneSimulation) getSimulatedTime() float64 {
+	sim.runningSimulationLock.Lock()
+	defer sim.runningSimulationLock.Unlock()
+	return sim.simulatedTime
+}
+
+func (sim *GeneralLaneSimulation) setSimulatedTime(simulatedTime float64) {
+	sim.runningSimulationLock.Lock()
+	defer sim.runningSimulationLock.Unlock()
+	sim.simulatedTime = simulatedTime
+}
+
+// resetPacing anchors the wall clock to the current simulated time, so playback continues from here
+func (sim *GeneralLaneSimulation) resetPacing() {
+	sim.pacingStart = time.Now()
+	sim.pacingSimStart = sim.scheduler.Now()
+}
+
+// fastForwarding is true while stepping or advancing, those run without waiting on the wall clock
+func (sim *GeneralLaneSimulation) fastForwarding() bool {
+	return sim.stepsLeft > 0 || !math.IsInf(sim.pauseAt, 1)
+}
+
+// pause stops processing events until the next resume, step or advance
+func (sim *GeneralLaneSimulation) pause() {
+	sim.stepsLeft = 0
+	sim.pauseAt = math.Inf(1)
+	sim.setPaused(true)
+	sim.setSimulatedTime(sim.scheduler.Now())
+	sim.notifyDraw() // show the frozen state
+	if sim.pauseReply != nil {
+		sim.pauseReply <- nil
+		sim.pauseReply = nil
+	}
+}
+
+// applyControl runs on the simulation loop
+func (sim *GeneralLaneSimulation) applyControl(control SimulationControl) {
+	switch control.controlType {
+	case pauseControl:
+		log.Println("pausing simulation at", sim.scheduler.Now())
+		sim.pause()
+	case resumeControl:
+		log.Println("resuming simulation at", sim.scheduler.Now())
+		sim.stepsLeft = 0
+		sim.pauseAt = math.Inf(1)
+		sim.setPaused(false)
+		sim.resetPacing()
+	case stepControl:
+		if control.numEvents <= 0 {
+			return
+		}
+		sim.stepsLeft = control.numEvents
+		sim.pauseAt = math.Inf(1)
+		sim.setPaused(false)
+	case advanceToControl:
+		if control.time <= sim.scheduler.Now() {
+			return
+		}
+		sim.stepsLeft = 0
+		sim.pauseAt = control.time
+		sim.setPaused(false)
+	case speedControl:
+		if control.speed < 0 {
+			return
+		}
+		sim.playbackSpeed = control.speed
+		sim.resetPacing()
+	case snapshotControl:
+		var err error
+		if sim.replaying {
+			err = errors.New("a replayed trace has no simulation state to save")
+		} else {
+			log.Println("saving snapshot at", sim.scheduler.Now(), "to", control.path)
+			err = sim.saveSnapshot(control.path)
+		}
+		if control.reply != nil {
+			control.reply <- err
+		}
+	case agentStepControl:
+		for i, phase := range control.phases {
+			if i < len(sim.signals) && phase >= 0 {
+				sim.requestPhase(sim.signals[i], phase)
+			}
+		}
+		sim.stepsLeft = 0
+		sim.pauseAt = control.time
+		sim.pauseReply = control.reply
+		sim.setPaused(false)
+	}
+}
+
+// waitWhilePaused blocks the loop until a control or cancel arrives
+func (sim *GeneralLaneSimulation) waitWhilePaused() {
+	select {
+	case control := <-sim.controlChan:
+		sim.applyControl(control)
+	case <-sim.cancelSimulation:
+		sim.setRunningSimulation(false)
+	}
+}
+
+// waitForWallClock holds the event back until the wall clock catches up with it when pacing is enabled.
+// Returns false if a control or cancel came in while waiting, the loop should then look at the next event again
+func (sim *GeneralLaneSimulation) waitForWallClock(eventTime float64) bool {
+	speed := sim.playbackSpeed
+	if speed <= 0 || sim.fastForwarding() {
+		return true
+	}
+	target := sim.pacingStart.Add(time.Duration((eventTime - sim.pacingSimStart) / speed * float64(time.Second)))
+	wait := time.Until(target)
+	if wait <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(wait):
+		return true
+	case control := <-sim.controlChan:
+		sim.applyControl(control)
+		return false
+	case <-sim.cancelSimulation:
+		sim.setRunningSimulation(false)
+		return false
+	}
+}
@@ -0,0 +1,620 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const snapshotTimeout = 10 * time.Second
+
+// LocationRef points at a grid location, or at one of the roots when Root is set
+type LocationRef struct {
+	Root string `json:"root,omitempty"`
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+}
+
+type CarSnapshot struct {
+	ID           string        `json:"id"`
+	Location     LocationRef   `json:"location"`
+	Speed        float64       `json:"speed"`
+	X            int           `json:"x"`
+	Y            int           `json:"y"`
+	Direction    Direction     `json:"direction"`
+	ProbMovement float64       `json:"probMovement"`
+	CarState     SmartCarState `json:"carState"`
+	SlowingDown  bool          `json:"slowingDown"`
+	WaitingTime  float64       `json:"waitingTime"`
+	StalledLoc   *LocationRef  `json:"stalledLoc,omitempty"`
+	Trip         TripMetrics   `json:"trip"`
+
+	Movement       Movement  `json:"movement"`
+	Approach       Direction `json:"approach"`
+	MovementChosen bool      `json:"movementChosen"`
+
+	ArrivedAt float64 `json:"arrivedAt"`
+	Rested    bool    `json:"rested"`
+
+	Emergency   bool `json:"emergency,omitempty"`
+	MovingAside bool `json:"movingAside,omitempty"`
+}
+
+type AccidentSnapshot struct {
+	Loc               LocationRef   `json:"loc"`
+	PrevLocationState LocationState `json:"prevLocationState"`
+	ProbRestart       float64       `json:"probRestart"`
+	RemovalRate       float64       `json:"removalRate"`
+
+	Reported float64 `json:"reported,omitempty"`
+	Crew     int     `json:"crew,omitempty"`
+	Attended bool    `json:"attended,omitempty"`
+}
+
+type ParkingSnapshot struct {
+	PrevLoc         LocationRef `json:"prevLoc"`
+	CarID           string      `json:"carId"`
+	ParkingTimeRate float64     `json:"parkingTimeRate"`
+	ParkingLoc      LocationRef `json:"parkingLoc"`
+}
+
+type SlowCarSnapshot struct {
+	CarID        string  `json:"carId"`
+	OldSpeed     float64 `json:"oldSpeed"`
+	SlowDownRate float64 `json:"slowDownRate"`
+}
+
+// EventSnapshot is a pending event. Car clocks carry the car and where its clock was started
+type EventSnapshot struct {
+	Time      float64           `json:"time"`
+	Seq       uint64            `json:"seq"`
+	Type      SimEventType      `json:"type"`
+	Direction Direction         `json:"direction"`
+	Street    int               `json:"street"` // index among the streets going Direction, for in and out bins
+	CarID     string            `json:"carId,omitempty"`
+	CarLoc    *LocationRef      `json:"carLoc,omitempty"`
+	Accident  *AccidentSnapshot `json:"accident,omitempty"`
+	Parking   *ParkingSnapshot  `json:"parking,omitempty"`
+	SlowCar   *SlowCarSnapshot  `json:"slowCar,omitempty"`
+	Closure   int               `json:"closure,omitempty"`  // index among the closures of the config, for closure events
+	Signal    int               `json:"signal,omitempty"`   // index among the signals, for signal events
+	Dispatch  int               `json:"dispatch,omitempty"` // index among the emergency vehicles, for emergency events
+}
+
+type RandomSnapshot struct {
+	Arrivals   uint64 `json:"arrivals"`
+	CarClock   uint64 `json:"carClock"`
+	Accidents  uint64 `json:"accidents"`
+	Parking    uint64 `json:"parking"`
+	LaneChoice uint64 `json:"laneChoice"`
+	Turns      uint64 `json:"turns"`
+	Routes     uint64 `json:"routes"`
+}
+
+// SimulationSnapshot is everything needed to continue a general simulation from the point it was taken
+type SimulationSnapshot struct {
+	Config         *GeneralLaneSimulationConfig `json:"config"`
+	Time           float64                      `json:"time"`
+	NextSeq        uint64                       `json:"nextSeq"`
+	NumProcessed   int                          `json:"numProcessed"`
+	NumAccidents   int                          `json:"numAccidents"`
+	Conflicts      ConflictCounts               `json:"conflicts"`
+	Movements      ApproachMovements            `json:"movements"`
+	Priority       PriorityCounts               `json:"priority"`
+	RingLaps       int                          `json:"ringLaps"`
+	Random         RandomSnapshot               `json:"random"`
+	LocationStates [][]LocationState            `json:"locationStates"`
+	ClosedCells    []ClosedCellSnapshot         `json:"closedCells,omitempty"`
+	Signals        []SignalSnapshot             `json:"signals,omitempty"`
+	Responders     *RespondersSnapshot          `json:"responders,omitempty"`
+	Detectors      []DetectorSnapshot           `json:"detectors,omitempty"`
+	Cars           []CarSnapshot                `json:"cars"`
+	Events         []EventSnapshot              `json:"events"`
+}
+
+// SignalSnapshot is where a signal is in its phases
+type SignalSnapshot struct {
+	Phase     int         `json:"phase"`
+	Next      int         `json:"next"`
+	State     SignalState `json:"state"`
+	Since     float64     `json:"since"`
+	NextGreen float64     `json:"nextGreen"`
+	Changes   int         `json:"changes"`
+	Requested int         `json:"requested"`
+
+	Preempt     int    `json:"preempt,omitempty"`
+	PreemptedBy string `json:"preemptedBy,omitempty"`
+	Preemptions int    `json:"preemptions,omitempty"`
+}
+
+// DetectorSnapshot is a loop detector with the windows it closed and what it accumulated in the current one
+type DetectorSnapshot struct {
+	Location   LocationRef        `json:"location"`
+	NumCars    int                `json:"numCars"`
+	LastUpdate float64            `json:"lastUpdate"`
+	Arrivals   map[string]float64 `json:"arrivals"`
+	Current    DetectorWindow     `json:"current"`
+	Dwell      float64            `json:"dwell"`
+	NumLeft    int                `json:"numLeft"`
+	Windows    []DetectorWindow   `json:"windows"`
+}
+
+// RespondersSnapshot is where the crews are and the accidents waiting for them
+type RespondersSnapshot struct {
+	Crews   []CrewSnapshot     `json:"crews"`
+	Waiting []AccidentSnapshot `json:"waiting,omitempty"`
+	Stats   ResponderStats     `json:"stats"`
+}
+
+// CrewSnapshot is a crew of responders
+type CrewSnapshot struct {
+	Loc  LocationRef `json:"loc"`
+	Busy bool        `json:"busy"`
+}
+
+// ClosedCellSnapshot is a cell shut by closures, with the state it goes back to
+type ClosedCellSnapshot struct {
+	Loc               LocationRef   `json:"loc"`
+	PrevLocationState LocationState `json:"prevLocationState"`
+	Closures          int           `json:"closures"`
+}
+
+func (sim *GeneralLaneSimulation) roots() map[string]*StatefulLocation {
+	roots := map[string]*StatefulLocation{}
+	for _, street := range sim.streets {
+		roots[street.rootName(true)] = street.InRoot
+		roots[street.rootName(false)] = street.OutRoot
+	}
+	return roots
+}
+
+func (sim *GeneralLaneSimulation) locationRef(loc *StatefulLocation) LocationRef {
+	if name, ok := sim.rootNames[loc]; ok {
+		return LocationRef{Root: name, X: -1, Y: -1}
+	}
+	return LocationRef{X: loc.X, Y: loc.Y}
+}
+
+func (sim *GeneralLaneSimulation) resolveLocationRef(ref LocationRef) (*StatefulLocation, error) {
+	if ref.Root != "" {
+		root, ok := sim.roots()[ref.Root]
+		if !ok {
+			return nil, fmt.Errorf("unknown root %s", ref.Root)
+		}
+		return root, nil
+	}
+	if !sim.inGrid(ref.X, ref.Y) {
+		return nil, fmt.Errorf("location %d %d is off the grid", ref.X, ref.Y)
+	}
+	return sim.Locations[ref.X][ref.Y], nil
+}
+
+// allLocations lists the grid and the roots, every car is in exactly one of them
+func (sim *GeneralLaneSimulation) allLocations() []*StatefulLocation {
+	locs := make([]*StatefulLocation, 0)
+	for _, row := range sim.Locations {
+		locs = append(locs, row...)
+	}
+	for _, street := range sim.streets {
+		locs = append(locs, street.InRoot, street.OutRoot)
+	}
+	return locs
+}
+
+// takeSnapshot must run on the simulation loop, or before the simulation is started
+func (sim *GeneralLaneSimulation) takeSnapshot() *SimulationSnapshot {
+	snapshot := &SimulationSnapshot{
+		Config:       sim.config,
+		Time:         sim.scheduler.now,
+		NextSeq:      sim.scheduler.nextSeq,
+		NumProcessed: sim.scheduler.numProcessed,
+		Random: RandomSnapshot{
+			Arrivals:   sim.random.arrivals.source.state,
+			CarClock:   sim.random.carClock.source.state,
+			Accidents:  sim.random.accidents.source.state,
+			Parking:    sim.random.parking.source.state,
+			LaneChoice: sim.random.laneChoice.source.state,
+			Turns:      sim.random.turns.source.state,
+			Routes:     sim.random.routes.source.state,
+		},
+		Cars:   make([]CarSnapshot, 0),
+		Events: make([]EventSnapshot, 0),
+	}
+	sim.runningSimulationLock.Lock()
+	snapshot.NumAccidents = sim.numAccidents
+	snapshot.Conflicts = sim.conflicts
+	snapshot.Movements = sim.movements
+	snapshot.Priority = sim.priority
+	snapshot.RingLaps = sim.ringLaps
+	sim.runningSimulationLock.Unlock()
+
+	snapshot.LocationStates = make([][]LocationState, len(sim.Locations))
+	for i, row := range sim.Locations {
+		snapshot.LocationStates[i] = make([]LocationState, len(row))
+		for j, loc := range row {
+			snapshot.LocationStates[i][j] = loc.getLocationState()
+			if cell := sim.closedCells[loc]; cell != nil {
+				snapshot.ClosedCells = append(snapshot.ClosedCells,
+					ClosedCellSnapshot{Loc: sim.locationRef(loc), PrevLocationState: cell.prev, Closures: cell.closures})
+			}
+		}
+	}
+
+	for _, signal := range sim.signals {
+		signal.signalLock.Lock()
+		snapshot.Signals = append(snapshot.Signals, SignalSnapshot{Phase: signal.phase, Next: signal.next, State: signal.state,
+			Since: signal.since, NextGreen: signal.nextGreen, Changes: signal.changes, Requested: signal.requested,
+			Preempt: signal.preempt, PreemptedBy: signal.preemptedBy, Preemptions: signal.preemptions})
+		signal.signalLock.Unlock()
+	}
+	if len(sim.crews) > 0 {
+		responders := &RespondersSnapshot{Stats: sim.responderStats}
+		for _, crew := range sim.crews {
+			responders.Crews = append(responders.Crews, CrewSnapshot{Loc: sim.locationRef(crew.loc), Busy: crew.busy})
+		}
+		for _, accident := range sim.waitingAccidents {
+			responders.Waiting = append(responders.Waiting, sim.accidentSnapshot(accident))
+		}
+		snapshot.Responders = responders
+	}
+	for _, detector := range sim.detectors {
+		arrivals := make(map[string]float64, len(detector.arrivals))
+		for id, arrival := range detector.arrivals {
+			arrivals[id] = arrival
+		}
+		snapshot.Detectors = append(snapshot.Detectors, DetectorSnapshot{Location: detector.Location, NumCars: detector.numCars,
+			LastUpdate: detector.lastUpdate, Arrivals: arrivals, Current: detector.current, Dwell: detector.dwell,
+			NumLeft: detector.numLeft, Windows: append([]DetectorWindow(nil), detector.Windows...)})
+	}
+
+	for _, loc := range sim.allLocations() {
+		ref := sim.locationRef(loc)
+		for _, car := range loc.sortedCars() {
+			car.smartCarLock.Lock()
+			carSnapshot := CarSnapshot{
+				ID:           car.ID,
+				Location:     ref,
+				Speed:        car.Speed,
+				X:            car.X,
+				Y:            car.Y,
+				Direction:    car.Direction,
+				ProbMovement: car.probMovement,
+				CarState:     car.carState,
+				SlowingDown:  car.slowingDown,
+				WaitingTime:  car.WaitingTime,
+				Trip:         car.trip,
+
+				Movement:       car.movement,
+				Approach:       car.approach,
+				MovementChosen: car.movementChosen,
+
+				ArrivedAt: car.arrivedAt,
+				Rested:    car.rested,
+
+				Emergency:   car.Emergency,
+				MovingAside: car.movingAside,
+			}
+			car.smartCarLock.Unlock()
+			if car.stalledLoc != nil {
+				stalledRef := sim.locationRef(car.stalledLoc)
+				carSnapshot.StalledLoc = &stalledRef
+			}
+			snapshot.Cars = append(snapshot.Cars, carSnapshot)
+		}
+	}
+
+	for _, event := range sim.scheduler.queue {
+		eventSnapshot := EventSnapshot{Time: event.Time, Seq: event.seq, Type: event.Type, Closure: event.closure, Signal: event.signal,
+			Dispatch: event.dispatch}
+		if event.street != nil {
+			eventSnapshot.Direction = event.street.Direction
+			eventSnapshot.Street = event.street.Index
+		}
+		if event.car != nil {
+			eventSnapshot.CarID = event.car.ID
+		}
+		if event.carLoc != nil {
+			carLoc := sim.locationRef(event.carLoc)
+			eventSnapshot.CarLoc = &carLoc
+		}
+		if accident := event.accident; accident != nil {
+			accidentSnapshot := sim.accidentSnapshot(accident)
+			eventSnapshot.Accident = &accidentSnapshot
+		}
+		if parking := event.parking; parking != nil {
+			eventSnapshot.Parking = &ParkingSnapshot{
+				PrevLoc:         sim.locationRef(parking.prevLoc),
+				CarID:           parking.car.ID,
+				ParkingTimeRate: parking.parkingTimeRate,
+				ParkingLoc:      sim.locationRef(parking.parkingLoc),
+			}
+		}
+		if slowCar := event.slowCar; slowCar != nil {
+			eventSnapshot.SlowCar = &SlowCarSnapshot{CarID: slowCar.car.ID, OldSpeed: slowCar.oldSpeed, SlowDownRate: slowCar.slowDownRate}
+		}
+		snapshot.Events = append(snapshot.Events, eventSnapshot)
+	}
+	return snapshot
+}
+
+// saveSnapshot writes the snapshot as json. Like takeSnapshot it must run on the simulation loop
+func (sim *GeneralLaneSimulation) saveSnapshot(path string) error {
+	data, err := json.MarshalIndent(sim.takeSnapshot(), "", " ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// restoreSimulation builds a simulation that continues exactly where the snapshot was taken
+func restoreSimulation(snapshot *SimulationSnapshot) (*GeneralLaneSimulation, error) {
+	if snapshot.Config == nil {
+		return nil, errors.New("snapshot has no config")
+	}
+	snapshot.Config.traceFile = "" // the continued run starts its own trace, if any
+	sim, err := initMultiLaneSimulation(snapshot.Config)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshot.LocationStates) != len(sim.Locations) {
+		return nil, errors.New("snapshot grid does not match its config")
+	}
+
+	// Throw away the pool of cars the config created, the snapshot has its own
+	for _, loc := range sim.allLocations() {
+		loc.Cars = make(map[string]*SmartCar)
+	}
+	for i, row := range snapshot.LocationStates {
+		if len(row) != len(sim.Locations[i]) {
+			return nil, errors.New("snapshot grid does not match its config")
+		}
+		for j, state := range row {
+			sim.Locations[i][j].LocationState = state
+		}
+	}
+	for _, cellSnapshot := range snapshot.ClosedCells {
+		loc, err := sim.resolveLocationRef(cellSnapshot.Loc)
+		if err != nil {
+			return nil, err
+		}
+		sim.closedCells[loc] = &closedCell{prev: cellSnapshot.PrevLocationState, closures: cellSnapshot.Closures}
+	}
+	if len(snapshot.Signals) != len(sim.signals) {
+		return nil, errors.New("snapshot signals do not match its config")
+	}
+	for i, signalSnapshot := range snapshot.Signals {
+		signal := sim.signals[i]
+		signal.phase = signalSnapshot.Phase
+		signal.next = signalSnapshot.Next
+		signal.state = signalSnapshot.State
+		signal.since = signalSnapshot.Since
+		signal.nextGreen = signalSnapshot.NextGreen
+		signal.changes = signalSnapshot.Changes
+		signal.requested = signalSnapshot.Requested
+		signal.preempt = signalSnapshot.Preempt
+		signal.preemptedBy = signalSnapshot.PreemptedBy
+		signal.preemptions = signalSnapshot.Preemptions
+	}
+	if responders := snapshot.Responders; responders != nil {
+		if len(responders.Crews) != len(sim.crews) {
+			return nil, errors.New("snapshot responders do not match its config")
+		}
+		for i, crewSnapshot := range responders.Crews {
+			if sim.crews[i].loc, err = sim.resolveLocationRef(crewSnapshot.Loc); err != nil {
+				return nil, errors.Wrapf(err, "crew %d", i)
+			}
+			sim.crews[i].busy = crewSnapshot.Busy
+		}
+		for _, accidentSnapshot := range responders.Waiting {
+			accident, err := sim.restoreAccident(accidentSnapshot)
+			if err != nil {
+				return nil, err
+			}
+			sim.waitingAccidents = append(sim.waitingAccidents, accident)
+		}
+		sim.responderStats = responders.Stats
+	}
+	for _, detectorSnapshot := range snapshot.Detectors {
+		loc, err := sim.resolveLocationRef(detectorSnapshot.Location)
+		if err != nil {
+			return nil, errors.Wrap(err, "detector")
+		}
+		detector := &LoopDetector{Location: detectorSnapshot.Location, window: sim.config.detectorWindow, clock: sim.scheduler,
+			numCars: detectorSnapshot.NumCars, lastUpdate: detectorSnapshot.LastUpdate, arrivals: detectorSnapshot.Arrivals,
+			current: detectorSnapshot.Current, dwell: detectorSnapshot.Dwell, numLeft: detectorSnapshot.NumLeft,
+			Windows: detectorSnapshot.Windows}
+		if detector.arrivals == nil {
+			detector.arrivals = map[string]float64{}
+		}
+		loc.detector = detector
+		sim.detectors = append(sim.detectors, detector)
+	}
+
+	cars := map[string]*SmartCar{}
+	for _, carSnapshot := range snapshot.Cars {
+		loc, err := sim.resolveLocationRef(carSnapshot.Location)
+		if err != nil {
+			return nil, errors.Wrapf(err, "car %s", carSnapshot.ID)
+		}
+		car := &SmartCar{
+			ID:           carSnapshot.ID,
+			Speed:        carSnapshot.Speed,
+			X:            carSnapshot.X,
+			Y:            carSnapshot.Y,
+			Direction:    carSnapshot.Direction,
+			probMovement: carSnapshot.ProbMovement,
+			carState:     carSnapshot.CarState,
+			slowingDown:  carSnapshot.SlowingDown,
+			WaitingTime:  carSnapshot.WaitingTime,
+			trip:         carSnapshot.Trip,
+
+			movement:       carSnapshot.Movement,
+			approach:       carSnapshot.Approach,
+			movementChosen: carSnapshot.MovementChosen,
+
+			arrivedAt: carSnapshot.ArrivedAt,
+			rested:    carSnapshot.Rested,
+
+			Emergency:   carSnapshot.Emergency,
+			movingAside: carSnapshot.MovingAside,
+		}
+		if carSnapshot.StalledLoc != nil {
+			if car.stalledLoc, err = sim.resolveLocationRef(*carSnapshot.StalledLoc); err != nil {
+				return nil, errors.Wrapf(err, "car %s", carSnapshot.ID)
+			}
+		}
+		loc.Cars[car.ID] = car // keeps the car's own X and Y, cars in the roots use -1
+		cars[car.ID] = car
+	}
+
+	findCar := func(id string) (*SmartCar, error) {
+		car, ok := cars[id]
+		if !ok {
+			return nil, fmt.Errorf("event refers to unknown car %s", id)
+		}
+		return car, nil
+	}
+	for _, eventSnapshot := range snapshot.Events {
+		event := &SimEvent{Time: eventSnapshot.Time, seq: eventSnapshot.Seq, Type: eventSnapshot.Type, closure: eventSnapshot.Closure,
+			signal: eventSnapshot.Signal, dispatch: eventSnapshot.Dispatch}
+		if (event.Type == closureStartEvent || event.Type == closureEndEvent) && event.closure >= len(sim.closureCells) {
+			return nil, fmt.Errorf("event refers to unknown closure %d", event.closure)
+		}
+		if event.Type == signalEvent {
+			if event.signal >= len(sim.signals) {
+				return nil, fmt.Errorf("event refers to unknown signal %d", event.signal)
+			}
+			sim.signals[event.signal].event = event
+		}
+		if event.Type == carInEvent || event.Type == carOutEvent {
+			if event.street, err = sim.findStreet(eventSnapshot.Direction, eventSnapshot.Street); err != nil {
+				return nil, err
+			}
+		}
+		if eventSnapshot.CarID != "" {
+			if event.car, err = findCar(eventSnapshot.CarID); err != nil {
+				return nil, err
+			}
+		}
+		if eventSnapshot.CarLoc != nil {
+			if event.carLoc, err = sim.resolveLocationRef(*eventSnapshot.CarLoc); err != nil {
+				return nil, err
+			}
+		}
+		if accidentSnapshot := eventSnapshot.Accident; accidentSnapshot != nil {
+			if event.accident, err = sim.restoreAccident(*accidentSnapshot); err != nil {
+				return nil, err
+			}
+		}
+		if event.Type == responderEvent && (event.accident == nil || event.accident.crew >= len(sim.crews)) {
+			return nil, errors.New("responder event without an accident or a crew")
+		}
+		if event.Type == emergencyEvent && event.dispatch >= len(sim.dispatches) {
+			return nil, fmt.Errorf("event refers to unknown emergency vehicle %d", event.dispatch)
+		}
+		if parkingSnapshot := eventSnapshot.Parking; parkingSnapshot != nil {
+			parking := &Parking{parkingTimeRate: parkingSnapshot.ParkingTimeRate}
+			if parking.car, err = findCar(parkingSnapshot.CarID); err != nil {
+				return nil, err
+			}
+			if parking.prevLoc, err = sim.resolveLocationRef(parkingSnapshot.PrevLoc); err != nil {
+				return nil, err
+			}
+			if parking.parkingLoc, err = sim.resolveLocationRef(parkingSnapshot.ParkingLoc); err != nil {
+				return nil, err
+			}
+			event.parking = parking
+		}
+		if slowCarSnapshot := eventSnapshot.SlowCar; slowCarSnapshot != nil {
+			slowCar := &SlowCar{oldSpeed: slowCarSnapshot.OldSpeed, slowDownRate: slowCarSnapshot.SlowDownRate}
+			if slowCar.car, err = findCar(slowCarSnapshot.CarID); err != nil {
+				return nil, err
+			}
+			event.slowCar = slowCar
+		}
+		if event.Type == carClockEvent && event.car != nil {
+			event.car.clock = event
+		}
+		sim.scheduler.restore(event)
+	}
+
+	sim.scheduler.now = snapshot.Time
+	sim.scheduler.nextSeq = snapshot.NextSeq
+	sim.scheduler.numProcessed = snapshot.NumProcessed
+	sim.numAccidents = snapshot.NumAccidents
+	sim.conflicts = snapshot.Conflicts
+	sim.movements = snapshot.Movements
+	sim.priority = snapshot.Priority
+	sim.ringLaps = snapshot.RingLaps
+	if sim.config.openSystem {
+		sim.countArrived()
+	}
+	sim.countEmergencies()
+	sim.simulatedTime = snapshot.Time
+	sim.random.arrivals.source.state = snapshot.Random.Arrivals
+	sim.random.carClock.source.state = snapshot.Random.CarClock
+	sim.random.accidents.source.state = snapshot.Random.Accidents
+	sim.random.parking.source.state = snapshot.Random.Parking
+	sim.random.laneChoice.source.state = snapshot.Random.LaneChoice
+	sim.random.turns.source.state = snapshot.Random.Turns
+	sim.random.routes.source.state = snapshot.Random.Routes
+	sim.restored = true
+	return sim, nil
+}
+
+func (sim *GeneralLaneSimulation) accidentSnapshot(accident *Accident) AccidentSnapshot {
+	return AccidentSnapshot{
+		Loc:               sim.locationRef(accident.loc),
+		PrevLocationState: accident.prevLocationState,
+		ProbRestart:       accident.probRestart,
+		RemovalRate:       accident.removalRate,
+		Reported:          accident.reported,
+		Crew:              accident.crew,
+		Attended:          accident.attended,
+	}
+}
+
+func (sim *GeneralLaneSimulation) restoreAccident(accidentSnapshot AccidentSnapshot) (*Accident, error) {
+	loc, err := sim.resolveLocationRef(accidentSnapshot.Loc)
+	if err != nil {
+		return nil, err
+	}
+	return &Accident{loc: loc, resolution: Unresolved, prevLocationState: accidentSnapshot.PrevLocationState,
+		probRestart: accidentSnapshot.ProbRestart, removalRate: accidentSnapshot.RemovalRate,
+		reported: accidentSnapshot.Reported, crew: accidentSnapshot.Crew, attended: accidentSnapshot.Attended}, nil
+}
+
+// requestSnapshot asks the simulation loop to save a snapshot and waits for it to be written
+func (sim *GeneralLaneSimulation) requestSnapshot(path string) error {
+	if !sim.isRunningSimulation() {
+		return errors.New("simulation is not running")
+	}
+	reply := make(chan error, 1)
+	select {
+	case sim.controlChan <- SimulationControl{controlType: snapshotControl, path: path, reply: reply}:
+	default:
+		return errors.New("too many pending simulation controls")
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-time.After(snapshotTimeout):
+		return errors.New("timed out waiting for the simulation to save")
+	}
+}
+
+// loadSnapshot reads a snapshot written by saveSnapshot
+func loadSnapshot(path string) (*GeneralLaneSimulation, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &SimulationSnapshot{Config: DefaultGeneralLaneConfig()}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, errors.Wrap(err, "reading snapshot")
+	}
+	return restoreSimulation(snapshot)
+}
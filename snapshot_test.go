@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// pausedAt runs a new simulation of the config up to pauseAt and leaves it paused there
+func pausedAt(t *testing.T, config *GeneralLaneSimulationConfig, pauseAt float64) *GeneralLaneSimulation {
+	t.Helper()
+	sim, err := initMultiLaneSimulation(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := make(chan error, 1)
+	sim.pauseAt = pauseAt
+	sim.pauseReply = reply
+	sim.setRunningSimulation(true)
+	go func() {
+		for range sim.drawUpdateChan {
+		}
+	}()
+	done := make(chan struct{})
+	go func() {
+		RunGeneralSimulation(sim)
+		close(done)
+	}()
+	select {
+	case <-reply:
+	case <-done:
+		t.Fatalf("the simulation ended before %v", pauseAt)
+	case <-time.After(time.Minute):
+		t.Fatalf("the simulation never paused at %v", pauseAt)
+	}
+	return sim
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	grid := func(seed int64) *GeneralLaneSimulationConfig {
+		config := testConfig(seed)
+		config.horizontalStreets = []int{2, 2}
+		config.verticalStreets = []int{2, 2}
+		config.signalControl = "actuated"
+		return config
+	}
+	detectors := func(seed int64) *GeneralLaneSimulationConfig {
+		config := testConfig(seed)
+		config.detectorWindow = 5
+		for j := 0; j < config.sizeOfLane; j++ {
+			config.detectors = append(config.detectors, LocationRef{X: config.sizeOfLane / 2, Y: j})
+		}
+		return config
+	}
+	tests := []struct {
+		name    string
+		config  func(seed int64) *GeneralLaneSimulationConfig
+		pauseAt float64
+	}{
+		{"two lanes early", testConfig, 5},
+		{"two lanes late", testConfig, 40},
+		{"city grid with signals", grid, 30},
+		{"loop detectors mid window", detectors, 12},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			uninterrupted, err := initMultiLaneSimulation(test.config(11))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := runMetrics(t, uninterrupted)
+
+			paused := pausedAt(t, test.config(11), test.pauseAt)
+			data, err := json.Marshal(paused.takeSnapshot())
+			paused.cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			snapshot := &SimulationSnapshot{Config: DefaultGeneralLaneConfig()}
+			if err := json.Unmarshal(data, snapshot); err != nil {
+				t.Fatal(err)
+			}
+			restored, err := restoreSimulation(snapshot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restored.scheduler.Now() != test.pauseAt {
+				t.Fatalf("restored at %v, paused at %v", restored.scheduler.Now(), test.pauseAt)
+			}
+			got := runMetrics(t, restored)
+			for name, value := range want {
+				if got[name] != value {
+					t.Errorf("%s is %v after the restore, %v without it", name, got[name], value)
+				}
+			}
+			if want, got := uninterrupted.detectorReports(), restored.detectorReports(); !reflect.DeepEqual(got, want) {
+				t.Errorf("the detectors measured %v after the restore, %v without it", got, want)
+			}
+		})
+	}
+}
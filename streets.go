@@ -0,0 +1,492 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Street is a bundle of parallel lanes going one direction, with its own in and out roots
+type Street struct {
+	Index     int // among the streets going the same direction
+	Direction Direction
+	Lanes     []int // rows of a horizontal street, columns of a vertical one
+	First     int   // the cell along its lanes where cars come in
+	Last      int   // and where they leave
+
+	InRoot  *StatefulLocation
+	OutRoot *StatefulLocation
+	numCars int  // cars that start in the in root
+	entry   bool // cars come in from the in root, otherwise only by turning onto the street
+	exit    bool // cars leave to the out root, otherwise they go on to next or turn off the street
+	next    *Street
+
+	numArrived int // cars an open system created for the street so far
+}
+
+// isHorizontal tells whether cars going direction drive along a row
+func (direction Direction) isHorizontal() bool {
+	return direction == Horizontal || direction == Westbound
+}
+
+// isReversed tells whether cars going direction drive toward the first row or column
+func (direction Direction) isReversed() bool {
+	return direction == Westbound || direction == Northbound
+}
+
+// step is how the row and column change when a car going direction moves one cell
+func (direction Direction) step() (int, int) {
+	switch direction {
+	case Vertical:
+		return 1, 0
+	case Westbound:
+		return 0, -1
+	case Northbound:
+		return -1, 0
+	}
+	return 0, 1
+}
+
+func (direction Direction) opposite() Direction {
+	switch direction {
+	case Vertical:
+		return Northbound
+	case Westbound:
+		return Horizontal
+	case Northbound:
+		return Vertical
+	}
+	return Westbound
+}
+
+func (direction Direction) name() string {
+	switch direction {
+	case Vertical:
+		return "vertical"
+	case Westbound:
+		return "westbound"
+	case Northbound:
+		return "northbound"
+	}
+	return "horizontal"
+}
+
+func (street *Street) name() string {
+	name := street.Direction.name()
+	if street.Index > 0 {
+		name += fmt.Sprint(street.Index)
+	}
+	return name
+}
+
+// Names of the roots of the first horizontal and vertical streets
+const (
+	inHorizontalRootName  = "inHorizontal"
+	inVerticalRootName    = "inVertical"
+	outHorizontalRootName = "outHorizontal"
+	outVerticalRootName   = "outVertical"
+)
+
+// rootName is how the roots of the street are called in snapshots and traces. The first street of each direction
+// keeps the names from before there could be several
+func (street *Street) rootName(in bool) string {
+	var name string
+	switch {
+	case in && street.Direction == Horizontal:
+		name = inHorizontalRootName
+	case in && street.Direction == Vertical:
+		name = inVerticalRootName
+	case street.Direction == Horizontal:
+		name = outHorizontalRootName
+	case street.Direction == Vertical:
+		name = outVerticalRootName
+	case in:
+		name = "in" + strings.Title(street.Direction.name())
+	default:
+		name = "out" + strings.Title(street.Direction.name())
+	}
+	if street.Index > 0 {
+		name += fmt.Sprint(street.Index)
+	}
+	return name
+}
+
+// carPrefix starts the ID of every car of the street
+func (street *Street) carPrefix() string {
+	prefix := street.Direction.name()[:1] + "car"
+	if street.Index > 0 {
+		prefix += fmt.Sprint(street.Index)
+	}
+	return prefix
+}
+
+// span is the lowest and highest cell along the lanes of the street
+func (street *streetLayout) span() (int, int) {
+	if street.first > street.last {
+		return street.last, street.first
+	}
+	return street.first, street.last
+}
+
+// span is the lowest and highest cell along the lanes of the street
+func (street *Street) span() (int, int) {
+	if street.First > street.Last {
+		return street.Last, street.First
+	}
+	return street.First, street.Last
+}
+
+// contains tells whether the cell index along the lanes is part of the street
+func (street *Street) contains(index int) bool {
+	low, high := street.span()
+	return index >= low && index <= high
+}
+
+// hasLane tells whether loc is on one of the lanes of the street
+func (street *Street) hasLane(loc *StatefulLocation) bool {
+	lane := loc.Y
+	if street.Direction.isHorizontal() {
+		lane = loc.X
+	}
+	for _, streetLane := range street.Lanes {
+		if streetLane == lane {
+			return true
+		}
+	}
+	return false
+}
+
+// cellsToEnd is how many cells a car at index still drives going straight, on this street and the ones it runs into
+func (street *Street) cellsToEnd(index int) int {
+	cells := abs(street.Last - index)
+	for next := street.next; next != nil; next = next.next {
+		cells += abs(next.Last-next.First) + 1
+	}
+	return cells
+}
+
+func (street *Street) lastLane() int {
+	return street.Lanes[len(street.Lanes)-1]
+}
+
+// parkingLane is the row or column next to the street on the drivers' right, where its cars park.
+// The opposing lanes of a street come before its lanes so that side is free for both
+func (street *Street) parkingLane() int {
+	if street.Direction.isReversed() {
+		return street.Lanes[0] - 1
+	}
+	return street.lastLane() + 1
+}
+
+// gridLayout is the size of the grid and where each street goes on it, the horizontal and westbound ones first
+type gridLayout struct {
+	rows    int
+	columns int
+	streets []streetLayout
+	signals [][2]int // a cell of every intersection with signals, only for networks
+}
+
+// streetLayout is where one street goes and whether cars come in or leave through it
+type streetLayout struct {
+	direction   Direction
+	index       int
+	lanes       []int
+	first, last int // the cells along the lanes, in driving order
+	entry, exit bool
+	numCars     int
+	next        int  // the street it runs into where the number of lanes changes, -1 if none
+	drawnOnly   bool // the lanes of a crossing without cars going that way, drawn but never driven on
+}
+
+// crossingStreets makes streets that cross the whole grid, length cells long. Directions without lanes get none
+func crossingStreets(direction Direction, lanes [][]int, numCars int, length int, drawnOnly bool) []streetLayout {
+	streets := make([]streetLayout, 0)
+	for i, streetLanes := range lanes {
+		if len(streetLanes) == 0 {
+			continue
+		}
+		street := streetLayout{direction: direction, index: i, lanes: streetLanes, first: 0, last: length - 1,
+			entry: true, exit: true, numCars: numCars, next: -1, drawnOnly: drawnOnly}
+		if direction.isReversed() {
+			street.first, street.last = street.last, street.first
+		}
+		streets = append(streets, street)
+	}
+	return streets
+}
+
+// addCrossingStreets lays out the streets of a crossing or a city grid. Opposing streets take as many cars as the
+// streets they are part of
+func (layout *gridLayout) addCrossingStreets(config *GeneralLaneSimulationConfig, horizontal, vertical, westbound, northbound [][]int) {
+	single := !config.isCityGrid()
+	layout.streets = append(layout.streets, crossingStreets(Horizontal, horizontal, config.numHorizontalCars, layout.columns, single && config.numHorizontalLanes == 0)...)
+	layout.streets = append(layout.streets, crossingStreets(Vertical, vertical, config.numVerticalCars, layout.rows, single && config.numVerticalLanes == 0)...)
+	layout.streets = append(layout.streets, crossingStreets(Westbound, westbound, config.numHorizontalCars, layout.columns, single && config.numWestboundLanes == 0)...)
+	layout.streets = append(layout.streets, crossingStreets(Northbound, northbound, config.numVerticalCars, layout.rows, single && config.numNorthboundLanes == 0)...)
+}
+
+func indexRange(low int, high int) []int {
+	indexes := make([]int, 0, high-low+1)
+	for i := low; i <= high; i++ {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+// placeStreets lays streets with the given lane counts one after the other, with blockLength cells before, between and
+// after them. Each street has its opposing lanes first. It returns the lanes of every street, of its opposing lanes and
+// how far the streets reach across the grid
+func placeStreets(lanes []int, opposingLanes []int, blockLength int) ([][]int, [][]int, int) {
+	placed := make([][]int, 0, len(lanes))
+	opposing := make([][]int, 0, len(lanes))
+	next := blockLength
+	for i, numLanes := range lanes {
+		numOpposing := 0
+		if i < len(opposingLanes) {
+			numOpposing = opposingLanes[i]
+		}
+		opposing = append(opposing, indexRange(next, next+numOpposing-1))
+		next += numOpposing
+		placed = append(placed, indexRange(next, next+numLanes-1))
+		next += numLanes + blockLength
+	}
+	return placed, opposing, next
+}
+
+// opposingRange puts numLanes lanes right before the lanes starting at low
+func opposingRange(low int, numLanes int) ([][]int, error) {
+	if low-numLanes < 0 {
+		return nil, errors.New("The opposing lanes do not fit next to the lanes")
+	}
+	return [][]int{indexRange(low-numLanes, low-1)}, nil
+}
+
+// isCityGrid tells whether the config asks for several streets instead of a single crossing
+func (config *GeneralLaneSimulationConfig) isCityGrid() bool {
+	return len(config.horizontalStreets) > 0 || len(config.verticalStreets) > 0
+}
+
+// layout works out where the streets go. A single crossing is a sizeOfLane square with the lanes in the middle,
+// a city grid or a network gets as big as its streets need
+func (sim *GeneralLaneSimulation) layout() (gridLayout, error) {
+	config := sim.config
+	if config.networkFile != "" {
+		var network *Network
+		var err error
+		if isOSMFile(config.networkFile) {
+			network, _, err = importOSM(config.networkFile, config.osmCellLength)
+		} else {
+			network, err = loadNetwork(config.networkFile)
+		}
+		if err != nil {
+			return gridLayout{}, err
+		}
+		return networkGridLayout(network, config)
+	}
+	if !config.isCityGrid() {
+		if config.sizeOfLane-2 <= 0 {
+			return gridLayout{}, errors.New("The lane must be 2 spots")
+		}
+		if !(config.sizeOfLane > config.numVerticalLanes && config.sizeOfLane > config.numHorizontalLanes) {
+			return gridLayout{}, errors.New("The number of vertical/horizontal lanes cannot be more than size of lane")
+		}
+		// the middle rows and columns are lanes even without a street on them
+		layout := gridLayout{rows: config.sizeOfLane, columns: config.sizeOfLane}
+		horizontal := [][]int{indexRange(sim.horizontalIndexRange())}
+		vertical := [][]int{indexRange(sim.verticalIndexRange())}
+		westbound, err := opposingRange(horizontal[0][0], config.numWestboundLanes)
+		if err != nil {
+			return gridLayout{}, err
+		}
+		northbound, err := opposingRange(vertical[0][0], config.numNorthboundLanes)
+		if err != nil {
+			return gridLayout{}, err
+		}
+		layout.addCrossingStreets(config, horizontal, vertical, westbound, northbound)
+		return layout, nil
+	}
+
+	if config.blockLength < 1 {
+		return gridLayout{}, errors.New("streets must be at least one block apart")
+	}
+	// a direction without a list of streets has the single street of the crossing, if it has lanes
+	horizontalStreets := config.horizontalStreets
+	if len(horizontalStreets) == 0 && config.numHorizontalLanes > 0 {
+		horizontalStreets = []int{config.numHorizontalLanes}
+	}
+	verticalStreets := config.verticalStreets
+	if len(verticalStreets) == 0 && config.numVerticalLanes > 0 {
+		verticalStreets = []int{config.numVerticalLanes}
+	}
+	for _, numLanes := range append(append([]int{}, horizontalStreets...), verticalStreets...) {
+		if numLanes < 1 {
+			return gridLayout{}, errors.New("every street needs at least one lane")
+		}
+	}
+	westboundStreets := config.westboundStreets
+	if len(westboundStreets) == 0 && len(config.horizontalStreets) == 0 {
+		westboundStreets = []int{config.numWestboundLanes}
+	}
+	northboundStreets := config.northboundStreets
+	if len(northboundStreets) == 0 && len(config.verticalStreets) == 0 {
+		northboundStreets = []int{config.numNorthboundLanes}
+	}
+	if len(westboundStreets) > len(horizontalStreets) || len(northboundStreets) > len(verticalStreets) {
+		return gridLayout{}, errors.New("there are opposing lanes for more streets than there are")
+	}
+	for _, numLanes := range append(append([]int{}, westboundStreets...), northboundStreets...) {
+		if numLanes < 0 {
+			return gridLayout{}, errors.New("a street cannot have a negative number of opposing lanes")
+		}
+	}
+	layout := gridLayout{}
+	horizontal, westbound, rows := placeStreets(horizontalStreets, westboundStreets, config.blockLength)
+	vertical, northbound, columns := placeStreets(verticalStreets, northboundStreets, config.blockLength)
+	layout.rows, layout.columns = rows, columns
+	layout.addCrossingStreets(config, horizontal, vertical, westbound, northbound)
+	return layout, nil
+}
+
+// buildStreets creates the streets of the layout with their roots
+func (sim *GeneralLaneSimulation) buildStreets(layout gridLayout) {
+	config := sim.config
+	sim.streets = make([]*Street, 0)
+	sim.rowStreets = make([][]*Street, layout.rows)
+	sim.columnStreets = make([][]*Street, layout.columns)
+
+	built := make([]*Street, len(layout.streets))
+	for i, streetLayout := range layout.streets {
+		if streetLayout.drawnOnly {
+			continue
+		}
+		direction := streetLayout.direction
+		street := &Street{Index: streetLayout.index, Direction: direction, Lanes: streetLayout.lanes, First: streetLayout.first,
+			Last: streetLayout.last, numCars: streetLayout.numCars, entry: streetLayout.entry, exit: streetLayout.exit}
+		if config.openSystem {
+			street.numCars = 0 // cars are made as they arrive
+		}
+		street.InRoot = &StatefulLocation{Cars: make(map[string]*SmartCar, 0), locationLock: sync.Mutex{}}
+		street.InRoot.addNCars(sim.random.carClock,
+			street.carPrefix(),
+			street.numCars,
+			direction,
+			config.carMovementP,
+			config.CarDistributionType,
+			config.carSpeedUniformEndRange,
+			config.carClock,
+			config.removeUnlikelyEvents,
+			config.unlikelyCutoff)
+		street.OutRoot = &StatefulLocation{Cars: make(map[string]*SmartCar, 0), X: -1, Y: -1}
+		for _, lane := range street.Lanes {
+			if direction.isHorizontal() {
+				sim.rowStreets[lane] = append(sim.rowStreets[lane], street)
+			} else {
+				sim.columnStreets[lane] = append(sim.columnStreets[lane], street)
+			}
+		}
+		sim.streets = append(sim.streets, street)
+		built[i] = street
+		if direction.isReversed() {
+			sim.twoWay = true
+		}
+	}
+	for i, streetLayout := range layout.streets {
+		if streetLayout.next >= 0 {
+			built[i].next = built[streetLayout.next]
+		}
+	}
+	sim.rootNames = make(map[*StatefulLocation]string, 2*len(sim.streets))
+	for name, root := range sim.roots() {
+		sim.rootNames[root] = name
+	}
+}
+
+// streetsGoing lists the streets of one direction in order
+func (sim *GeneralLaneSimulation) streetsGoing(direction Direction) []*Street {
+	streets := make([]*Street, 0)
+	for _, street := range sim.streets {
+		if street.Direction == direction {
+			streets = append(streets, street)
+		}
+	}
+	return streets
+}
+
+func (sim *GeneralLaneSimulation) findStreet(direction Direction, index int) (*Street, error) {
+	for _, street := range sim.streets {
+		if street.Direction == direction && street.Index == index {
+			return street, nil
+		}
+	}
+	return nil, fmt.Errorf("no street %d going %d", index, direction)
+}
+
+// streetAt is the street a car going direction at x, y drives on, nil off the streets
+func (sim *GeneralLaneSimulation) streetAt(direction Direction, x int, y int) *Street {
+	if !sim.inGrid(x, y) {
+		return nil
+	}
+	streets := sim.columnStreets[y]
+	if direction.isHorizontal() {
+		streets = sim.rowStreets[x]
+	}
+	for _, street := range streets {
+		if street.contains(indexAlong(direction, x, y)) {
+			return street
+		}
+	}
+	return nil
+}
+
+func (sim *GeneralLaneSimulation) numRows() int {
+	return len(sim.Locations)
+}
+
+func (sim *GeneralLaneSimulation) numColumns() int {
+	if len(sim.Locations) == 0 {
+		return 0
+	}
+	return len(sim.Locations[0])
+}
+
+func (sim *GeneralLaneSimulation) inGrid(x int, y int) bool {
+	return isInBounds(x, sim.numRows()) && isInBounds(y, sim.numColumns())
+}
+
+// laneLength is how many cells a car going direction crosses
+func (sim *GeneralLaneSimulation) laneLength(direction Direction) int {
+	if direction.isHorizontal() {
+		return sim.numColumns()
+	}
+	return sim.numRows()
+}
+
+// indexAlong is how far along a street going direction the location x, y is
+func indexAlong(direction Direction, x int, y int) int {
+	if direction.isHorizontal() {
+		return y
+	}
+	return x
+}
+
+// streetLanesAtIndex gives the location of every lane of the street index cells along it
+func (sim *GeneralLaneSimulation) streetLanesAtIndex(street *Street, index int, checkLocationType CheckLocationType) []*StatefulLocation {
+	openLanes := make([]*StatefulLocation, 0)
+	if !street.contains(index) {
+		return openLanes
+	}
+	for _, lane := range street.Lanes {
+		var loc *StatefulLocation
+		if street.Direction.isHorizontal() {
+			loc = sim.Locations[lane][index]
+		} else {
+			loc = sim.Locations[index][lane]
+		}
+		if loc.shouldCheckForMovingIn(checkLocationType) {
+			openLanes = append(openLanes, loc)
+		}
+	}
+	return openLanes
+}
@@ -1,22 +1,65 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
-func RunTerminalMultiLaneSimulation() {
-	config := DefaultGeneralLaneConfig()
+const terminalControlsHelp = "controls: p pause, r resume, s [n] step n events, a <time> advance to time, + faster, - slower, w <file> save snapshot, q quit"
 
-	simulation, err := initMultiLaneSimulation(config)
-	simulation.runningSimulation = true
+// RunTerminalMultiLaneSimulation prints the general simulation, continuing from the snapshot at restorePath if it is set.
+// Every event is written to tracePath when it is set
+func RunTerminalMultiLaneSimulation(restorePath string, tracePath string) {
+	var simulation *GeneralLaneSimulation
+	var err error
+	if restorePath != "" {
+		simulation, err = loadSnapshot(restorePath)
+	} else {
+		config := DefaultGeneralLaneConfig()
+		config.wallClockSpeed = 1
+		simulation, err = initMultiLaneSimulation(config)
+	}
 	if err != nil {
 		panic(err)
 	}
+	simulation.config.traceFile = tracePath
+	if simulation.playbackSpeed == 0 {
+		simulation.playbackSpeed = 1
+	}
+	simulation.runningSimulation = true
 	go RunGeneralSimulation(simulation)
+	watchTerminalSimulation(simulation)
+	fmt.Println("MultiLane completed")
+	fmt.Print(simulation.tripSummary())
+	for _, report := range simulation.detectorReports() {
+		fmt.Print(report)
+	}
+}
+
+// RunTerminalReplay prints a trace recorded from an earlier run without simulating it again
+func RunTerminalReplay(tracePath string, speed float64) {
+	simulation, replay, err := loadReplay(tracePath)
+	if err != nil {
+		panic(err)
+	}
+	simulation.playbackSpeed = speed
+	simulation.runningSimulation = true
+	go RunTraceReplay(simulation, replay)
+	watchTerminalSimulation(simulation)
+	fmt.Println("Replay completed")
+}
+
+// watchTerminalSimulation prints every frame and reads controls from stdin until the simulation stops
+func watchTerminalSimulation(simulation *GeneralLaneSimulation) {
+	go readTerminalControls(simulation, os.Stdin)
+	fmt.Println(terminalControlsHelp)
 	for {
-		if !simulation.runningSimulation {
-			fmt.Println("MultiLane completed")
+		if !simulation.isRunningSimulation() {
 			return
 		}
 		select {
@@ -27,6 +70,68 @@ func RunTerminalMultiLaneSimulation() {
 	}
 }
 
+// readTerminalControls turns lines typed into the terminal into simulation controls
+func readTerminalControls(simulation *GeneralLaneSimulation, input io.Reader) {
+	speed := simulation.playbackSpeed
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "p":
+			simulation.sendControl(SimulationControl{controlType: pauseControl})
+		case "r":
+			simulation.sendControl(SimulationControl{controlType: resumeControl})
+		case "s":
+			numEvents := 1
+			if len(fields) > 1 {
+				if n, err := strconv.Atoi(fields[1]); err == nil {
+					numEvents = n
+				}
+			}
+			simulation.sendControl(SimulationControl{controlType: stepControl, numEvents: numEvents})
+		case "a":
+			if len(fields) < 2 {
+				fmt.Println(terminalControlsHelp)
+				break
+			}
+			simulatedTime, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				fmt.Println(terminalControlsHelp)
+				break
+			}
+			simulation.sendControl(SimulationControl{controlType: advanceToControl, time: simulatedTime})
+		case "+", "-":
+			if speed == 0 {
+				speed = 1
+			} else if fields[0] == "+" {
+				speed *= 2
+			} else {
+				speed /= 2
+			}
+			fmt.Println("speed", speed)
+			simulation.sendControl(SimulationControl{controlType: speedControl, speed: speed})
+		case "w":
+			if len(fields) < 2 {
+				fmt.Println(terminalControlsHelp)
+				break
+			}
+			if err := simulation.requestSnapshot(fields[1]); err != nil {
+				fmt.Println("snapshot failed:", err)
+			} else {
+				fmt.Println("snapshot saved to", fields[1])
+			}
+		case "q":
+			simulation.cancel()
+			return
+		default:
+			fmt.Println(terminalControlsHelp)
+		}
+	}
+}
+
 // RunTerminalSingleLaneSimulation simulates and prints the movement through a single lane
 func RunTerminalSingleLaneSimulation(action bool) {
 	simulation := initSingleLaneSimulation(10)
@@ -45,7 +150,6 @@ func RunTerminalSingleLaneSimulation(action bool) {
 				break
 			}
 		}
-		return
 	}
 
 	RenderTerminalFPS(simulation)
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Types of events in a trace
+const (
+	traceStart           = "start" // first line, carries the grid and where every car starts
+	traceEnd             = "end"
+	traceCarArrived      = "carArrived" // an open system made the car at the entry
+	traceCarPlaced       = "carPlaced"
+	traceCarMoved        = "carMoved"
+	traceCarExited       = "carExited"
+	traceBlocked         = "blocked"
+	traceAccident        = "accident"
+	traceAccidentCleared = "accidentCleared"
+	traceCarRemoved      = "carRemoved"
+	traceParked          = "parked"
+	traceUnparked        = "unparked"
+	tracePullOver        = "policePullOver"
+	traceSlowDown        = "slowDown"
+	traceSpeedRestored   = "speedRestored"
+	traceLocationState   = "locationState"
+	traceTurned          = "turned" // the cause is the movement
+)
+
+// Causes attached to trace events
+const (
+	causeCarAhead      = "carAhead"
+	causeAccidentAhead = "accidentAhead"
+	causeIntersection  = "intersection"
+	causeLane          = "lane"
+	causeLaneSwitch    = "laneSwitch"
+	causePedestrian    = "pedestrian"
+	causeCrossWalk     = "crossWalk"
+	causePolice        = "police"
+	causeResolved      = "resolved"
+	causeRemoved       = "removed"
+	causeRingLap       = "ringLap" // a car back on the first cell of a ring road lane
+	causeClosure       = "closure"
+	causeRedLight      = "redLight"
+	causeStopSign      = "stopSign" // the car comes to rest before the intersection
+	causeYield         = "yield"    // the car gives way to another
+)
+
+// TraceHeader describes the simulation a trace came from, enough to redraw it without the engine
+type TraceHeader struct {
+	Config         *GeneralLaneSimulationConfig `json:"config"`
+	LocationStates [][]LocationState            `json:"locationStates"`
+	Cars           map[string]LocationRef       `json:"cars"`
+}
+
+// TraceEvent is one line of a trace
+type TraceEvent struct {
+	Time   float64        `json:"time"`
+	Type   string         `json:"type"`
+	CarID  string         `json:"carId,omitempty"`
+	From   *LocationRef   `json:"from,omitempty"`
+	To     *LocationRef   `json:"to,omitempty"`
+	Cause  string         `json:"cause,omitempty"`
+	State  *LocationState `json:"state,omitempty"`
+	Header *TraceHeader   `json:"header,omitempty"`
+}
+
+func (ref LocationRef) String() string {
+	if ref.Root != "" {
+		return ref.Root
+	}
+	return fmt.Sprintf("%d,%d", ref.X, ref.Y)
+}
+
+func (event TraceEvent) String() string {
+	s := fmt.Sprintf("%.4f %s", event.Time, event.Type)
+	if event.CarID != "" {
+		s += " " + event.CarID
+	}
+	if event.From != nil {
+		s += " from " + event.From.String()
+	}
+	if event.To != nil {
+		s += " to " + event.To.String()
+	}
+	if event.State != nil {
+		s += fmt.Sprintf(" state %d", *event.State)
+	}
+	if event.Cause != "" {
+		s += " (" + event.Cause + ")"
+	}
+	return s
+}
+
+// TraceWriter writes trace events as JSON Lines
+type TraceWriter struct {
+	file    *os.File
+	buffer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+func newTraceWriter(path string) (*TraceWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	buffer := bufio.NewWriter(file)
+	return &TraceWriter{file: file, buffer: buffer, encoder: json.NewEncoder(buffer)}, nil
+}
+
+func (writer *TraceWriter) write(event TraceEvent) error {
+	return writer.encoder.Encode(event)
+}
+
+func (writer *TraceWriter) close() error {
+	if err := writer.buffer.Flush(); err != nil {
+		writer.file.Close()
+		return err
+	}
+	return writer.file.Close()
+}
+
+// openTrace starts the trace file from the config, if there is one, with a header describing the current state
+func (sim *GeneralLaneSimulation) openTrace() error {
+	if sim.config.traceFile == "" {
+		return nil
+	}
+	writer, err := newTraceWriter(sim.config.traceFile)
+	if err != nil {
+		return err
+	}
+	sim.tracer = writer
+
+	header := &TraceHeader{Config: sim.config, Cars: map[string]LocationRef{}}
+	header.LocationStates = make([][]LocationState, len(sim.Locations))
+	for i, row := range sim.Locations {
+		header.LocationStates[i] = make([]LocationState, len(row))
+		for j, loc := range row {
+			header.LocationStates[i][j] = loc.getLocationState()
+		}
+	}
+	for _, loc := range sim.allLocations() {
+		for _, car := range loc.sortedCars() {
+			header.Cars[car.ID] = sim.locationRef(loc)
+		}
+	}
+	return sim.tracer.write(TraceEvent{Time: sim.scheduler.Now(), Type: traceStart, Header: header})
+}
+
+func (sim *GeneralLaneSimulation) closeTrace() {
+	if sim.tracer == nil {
+		return
+	}
+	sim.trace(TraceEvent{Type: traceEnd})
+	if err := sim.tracer.close(); err != nil {
+		log.Println("closing trace", err)
+	}
+	sim.tracer = nil
+}
+
+// trace records the event at the current simulated time
+func (sim *GeneralLaneSimulation) trace(event TraceEvent) {
+	if sim.tracer == nil {
+		return
+	}
+	event.Time = sim.scheduler.Now()
+	if err := sim.tracer.write(event); err != nil {
+		log.Println("writing trace", err)
+		sim.tracer.close()
+		sim.tracer = nil
+	}
+}
+
+// traceCar records an event for a car going from one location to another, either may be nil
+func (sim *GeneralLaneSimulation) traceCar(eventType string, car *SmartCar, from *StatefulLocation, to *StatefulLocation, cause string) {
+	if sim.tracer == nil {
+		return
+	}
+	event := TraceEvent{Type: eventType, CarID: car.ID, Cause: cause}
+	if from != nil {
+		ref := sim.locationRef(from)
+		event.From = &ref
+	}
+	if to != nil {
+		ref := sim.locationRef(to)
+		event.To = &ref
+	}
+	sim.trace(event)
+}
+
+// changeLocationState sets the state and records it in the trace
+func (sim *GeneralLaneSimulation) changeLocationState(loc *StatefulLocation, state LocationState) {
+	loc.setLocationState(state)
+	if sim.tracer == nil {
+		return
+	}
+	ref := sim.locationRef(loc)
+	sim.trace(TraceEvent{Type: traceLocationState, To: &ref, State: &state})
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// TimeInterval adds up the simulated time between each start and the following stop
+type TimeInterval struct {
+	Total float64 `json:"total"`
+	Since float64 `json:"since"`
+	Open  bool    `json:"open"`
+}
+
+func (interval *TimeInterval) start(now float64) {
+	if interval.Open {
+		return
+	}
+	interval.Since = now
+	interval.Open = true
+}
+
+func (interval *TimeInterval) stop(now float64) {
+	if !interval.Open {
+		return
+	}
+	interval.Total += now - interval.Since
+	interval.Open = false
+}
+
+// TripMetrics is what happened to a car between entering the grid and leaving it
+type TripMetrics struct {
+	ArrivalTime  float64   `json:"arrivalTime"` // joined the entry queue of an open system, 0 for cars of the pool
+	Entered      bool      `json:"entered"`
+	EntryTime    float64   `json:"entryTime"`
+	Exited       bool      `json:"exited"`
+	ExitTime     float64   `json:"exitTime"`
+	Removed      bool      `json:"removed"`      // taken off the grid after an accident instead of driving out
+	FreeFlowTime float64   `json:"freeFlowTime"` // expected time to drive its path on empty lanes at the speed the car entered with
+	Approach     Direction `json:"approach"`     // the direction the car entered going, before any turn
+	PathLength   int       `json:"pathLength"`   // cells from the entry to the exit, longer or shorter after a turn
+	Turns        int       `json:"turns"`
+	BlockedMoves int       `json:"blockedMoves"`
+
+	Origin      string   `json:"origin,omitempty"`      // the entry street of a car drawn from the OD matrix
+	Destination string   `json:"destination,omitempty"` // the exit street it heads for
+	Route       []string `json:"route,omitempty"`       // the streets it means to drive on, from the one it is on
+	Reroutes    int      `json:"reroutes,omitempty"`
+	ExitStreet  string   `json:"exitStreet,omitempty"`
+
+	ClosureMerges int `json:"closureMerges,omitempty"` // lane changes forced by a closure
+	ClosureBlocks int `json:"closureBlocks,omitempty"` // moves a closure stopped
+
+	RedLightStops int          `json:"redLightStops,omitempty"` // moves a red light stopped
+	RedLight      TimeInterval `json:"redLight"`                // from first stopping at a red light to entering the intersection
+
+	Parked   TimeInterval `json:"parked"`
+	Slowed   TimeInterval `json:"slowed"` // at a crosswalk or pulled over by police
+	Accident TimeInterval `json:"accident"`
+
+	AccidentAhead TimeInterval `json:"accidentAhead"` // stuck behind an accident cell
+}
+
+func (trip *TripMetrics) travelTime() float64 {
+	return trip.ExitTime - trip.EntryTime
+}
+
+// queueTime is how long the car waited to get onto the grid
+func (trip *TripMetrics) queueTime() float64 {
+	return trip.EntryTime - trip.ArrivalTime
+}
+
+// delay is the time lost compared to crossing an empty lane
+func (trip *TripMetrics) delay() float64 {
+	return trip.travelTime() - trip.FreeFlowTime
+}
+
+// TripRecord is the trip of one car, for exporting
+type TripRecord struct {
+	CarID     string      `json:"carId"`
+	Direction Direction   `json:"direction"`
+	Emergency bool        `json:"emergency,omitempty"`
+	Trip      TripMetrics `json:"trip"`
+}
+
+// Summary describes the distribution of one metric over the cars
+type Summary struct {
+	Count  int     `json:"count"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	P95    float64 `json:"p95"`
+	Max    float64 `json:"max"`
+}
+
+func summarize(values []float64) Summary {
+	if len(values) == 0 {
+		return Summary{}
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	return Summary{
+		Count:  len(sorted),
+		Mean:   stat.Mean(sorted, nil),
+		Median: stat.Quantile(0.5, stat.Empirical, sorted, nil),
+		P95:    stat.Quantile(0.95, stat.Empirical, sorted, nil),
+		Max:    sorted[len(sorted)-1],
+	}
+}
+
+func (summary Summary) String() string {
+	return fmt.Sprintf("mean %.3f median %.3f p95 %.3f max %.3f (n=%d)", summary.Mean, summary.Median, summary.P95, summary.Max, summary.Count)
+}
+
+// DirectionTripSummary summarizes the finished trips going one direction.
+// Cars removed after an accident count towards everything except travel time and delay
+type DirectionTripSummary struct {
+	NumFinished  int     `json:"numFinished"`
+	NumRemoved   int     `json:"numRemoved"`
+	TravelTime   Summary `json:"travelTime"`
+	Delay        Summary `json:"delay"`
+	QueueTime    Summary `json:"queueTime"`
+	BlockedMoves Summary `json:"blockedMoves"`
+	ParkedTime   Summary `json:"parkedTime"`
+	SlowedTime   Summary `json:"slowedTime"`
+	AccidentTime Summary `json:"accidentTime"`
+}
+
+// TripSummary has the trip metrics of a run split by direction
+type TripSummary struct {
+	Horizontal DirectionTripSummary `json:"horizontal"`
+	Vertical   DirectionTripSummary `json:"vertical"`
+	Westbound  DirectionTripSummary `json:"westbound"`
+	Northbound DirectionTripSummary `json:"northbound"`
+}
+
+func summarizeTrips(records []TripRecord, direction Direction) DirectionTripSummary {
+	var travelTimes, delays, queueTimes, blockedMoves, parkedTimes, slowedTimes, accidentTimes []float64
+	summary := DirectionTripSummary{}
+	for _, record := range records {
+		trip := record.Trip
+		if record.Direction != direction || !trip.Exited || record.Emergency {
+			continue
+		}
+		summary.NumFinished++
+		queueTimes = append(queueTimes, trip.queueTime())
+		if trip.Removed {
+			summary.NumRemoved++
+		} else {
+			travelTimes = append(travelTimes, trip.travelTime())
+			delays = append(delays, trip.delay())
+		}
+		blockedMoves = append(blockedMoves, float64(trip.BlockedMoves))
+		parkedTimes = append(parkedTimes, trip.Parked.Total)
+		slowedTimes = append(slowedTimes, trip.Slowed.Total)
+		accidentTimes = append(accidentTimes, trip.Accident.Total)
+	}
+	summary.TravelTime = summarize(travelTimes)
+	summary.Delay = summarize(delays)
+	summary.QueueTime = summarize(queueTimes)
+	summary.BlockedMoves = summarize(blockedMoves)
+	summary.ParkedTime = summarize(parkedTimes)
+	summary.SlowedTime = summarize(slowedTimes)
+	summary.AccidentTime = summarize(accidentTimes)
+	return summary
+}
+
+func (summary DirectionTripSummary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "  finished %d removed %d\n", summary.NumFinished, summary.NumRemoved)
+	fmt.Fprintf(&b, "  travel time   %s\n", summary.TravelTime)
+	fmt.Fprintf(&b, "  delay         %s\n", summary.Delay)
+	fmt.Fprintf(&b, "  queue time    %s\n", summary.QueueTime)
+	fmt.Fprintf(&b, "  blocked moves %s\n", summary.BlockedMoves)
+	fmt.Fprintf(&b, "  parked time   %s\n", summary.ParkedTime)
+	fmt.Fprintf(&b, "  slowed time   %s\n", summary.SlowedTime)
+	fmt.Fprintf(&b, "  accident time %s\n", summary.AccidentTime)
+	return b.String()
+}
+
+func (summary TripSummary) String() string {
+	s := "horizontal\n" + summary.Horizontal.String() + "vertical\n" + summary.Vertical.String()
+	if summary.Westbound.NumFinished > 0 { // only two way streets have them
+		s += "westbound\n" + summary.Westbound.String()
+	}
+	if summary.Northbound.NumFinished > 0 {
+		s += "northbound\n" + summary.Northbound.String()
+	}
+	return s
+}
+
+// tripRecords lists the trip of every car ordered by ID. Safe to call once the simulation stopped
+func (sim *GeneralLaneSimulation) tripRecords() []TripRecord {
+	records := make([]TripRecord, 0)
+	for _, loc := range sim.allLocations() {
+		for _, car := range loc.sortedCars() {
+			car.smartCarLock.Lock()
+			direction := car.Direction
+			if car.trip.Entered {
+				direction = car.trip.Approach
+			}
+			records = append(records, TripRecord{CarID: car.ID, Direction: direction, Emergency: car.Emergency, Trip: car.trip})
+			car.smartCarLock.Unlock()
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CarID < records[j].CarID
+	})
+	return records
+}
+
+func (sim *GeneralLaneSimulation) tripSummary() TripSummary {
+	records := sim.tripRecords()
+	return TripSummary{
+		Horizontal: summarizeTrips(records, Horizontal),
+		Vertical:   summarizeTrips(records, Vertical),
+		Westbound:  summarizeTrips(records, Westbound),
+		Northbound: summarizeTrips(records, Northbound),
+	}
+}
+
+// enterTrip starts the trip of a car placed on the first cell of the street
+func (sim *GeneralLaneSimulation) enterTrip(car *SmartCar, street *Street) {
+	car.smartCarLock.Lock()
+	defer car.smartCarLock.Unlock()
+	car.trip.Entered = true
+	car.trip.EntryTime = sim.scheduler.Now()
+	car.trip.Approach = car.Direction
+	car.trip.PathLength = street.cellsToEnd(street.First) + 1
+	if rate := car.Speed * car.probMovement; rate > 0 {
+		car.trip.FreeFlowTime = float64(car.trip.PathLength) / rate
+	}
+}
+
+// exitTrip ends the trip, closing whatever the car was still doing. Cars taken off the grid have no street
+func (sim *GeneralLaneSimulation) exitTrip(car *SmartCar, street *Street, removed bool) {
+	if car.Emergency {
+		sim.emergencyExited(car)
+	}
+	now := sim.scheduler.Now()
+	car.smartCarLock.Lock()
+	defer car.smartCarLock.Unlock()
+	if street != nil {
+		car.trip.ExitStreet = street.name()
+	}
+	car.trip.Exited = true
+	car.trip.ExitTime = now
+	car.trip.Removed = removed
+	car.trip.Parked.stop(now)
+	car.trip.Slowed.stop(now)
+	car.trip.Accident.stop(now)
+	car.trip.RedLight.stop(now)
+	car.trip.AccidentAhead.stop(now)
+}
+
+// tripTurned stretches the free flow time to the new path of a car that just turned. It had cellsBefore cells
+// left to its exit before the turn and has cellsAfter now
+func (sim *GeneralLaneSimulation) tripTurned(car *SmartCar, cellsBefore int, cellsAfter int) {
+	car.smartCarLock.Lock()
+	defer car.smartCarLock.Unlock()
+	car.trip.Turns++
+	if car.trip.PathLength > 0 {
+		pathLength := car.trip.PathLength - cellsBefore + cellsAfter
+		car.trip.FreeFlowTime *= float64(pathLength) / float64(car.trip.PathLength)
+		car.trip.PathLength = pathLength
+	}
+}
+
+// tripBlocked counts a move attempt that could not happen
+func (sim *GeneralLaneSimulation) tripBlocked(car *SmartCar) {
+	car.smartCarLock.Lock()
+	defer car.smartCarLock.Unlock()
+	car.trip.BlockedMoves++
+}
+
+// tripInterval picks one of the timed parts of the trip
+type tripInterval func(trip *TripMetrics) *TimeInterval
+
+func parkedInterval(trip *TripMetrics) *TimeInterval   { return &trip.Parked }
+func slowedInterval(trip *TripMetrics) *TimeInterval   { return &trip.Slowed }
+func accidentInterval(trip *TripMetrics) *TimeInterval { return &trip.Accident }
+func redLightInterval(trip *TripMetrics) *TimeInterval { return &trip.RedLight }
+
+// accidentAheadInterval runs while the car waits behind an accident cell, it stops on the car's next move
+func accidentAheadInterval(trip *TripMetrics) *TimeInterval { return &trip.AccidentAhead }
+
+func (sim *GeneralLaneSimulation) startTripInterval(car *SmartCar, interval tripInterval) {
+	car.smartCarLock.Lock()
+	defer car.smartCarLock.Unlock()
+	if car.trip.Exited {
+		return
+	}
+	interval(&car.trip).start(sim.scheduler.Now())
+}
+
+func (sim *GeneralLaneSimulation) stopTripInterval(car *SmartCar, interval tripInterval) {
+	car.smartCarLock.Lock()
+	defer car.smartCarLock.Unlock()
+	interval(&car.trip).stop(sim.scheduler.Now())
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Movement is what a car does at an intersection
+type Movement int
+
+const (
+	Straight Movement = iota
+	LeftTurn
+	RightTurn
+)
+
+func (movement Movement) name() string {
+	switch movement {
+	case LeftTurn:
+		return "left"
+	case RightTurn:
+		return "right"
+	}
+	return "straight"
+}
+
+// left is the direction a car going direction drives after turning left
+func (direction Direction) left() Direction {
+	switch direction {
+	case Vertical:
+		return Horizontal
+	case Westbound:
+		return Vertical
+	case Northbound:
+		return Westbound
+	}
+	return Northbound
+}
+
+// right is the direction a car going direction drives after turning right
+func (direction Direction) right() Direction {
+	return direction.left().opposite()
+}
+
+// after is the direction a car going direction drives once it made the movement
+func (direction Direction) after(movement Movement) Direction {
+	switch movement {
+	case LeftTurn:
+		return direction.left()
+	case RightTurn:
+		return direction.right()
+	}
+	return direction
+}
+
+// MovementCounts is how many cars coming from one approach made each movement
+type MovementCounts struct {
+	Left     int `json:"left"`
+	Straight int `json:"straight"`
+	Right    int `json:"right"`
+}
+
+func (counts *MovementCounts) count(movement Movement) {
+	switch movement {
+	case LeftTurn:
+		counts.Left++
+	case RightTurn:
+		counts.Right++
+	default:
+		counts.Straight++
+	}
+}
+
+// ApproachMovements counts the movements made at every intersection, by the direction the cars came from
+type ApproachMovements struct {
+	Horizontal MovementCounts `json:"horizontal"`
+	Vertical   MovementCounts `json:"vertical"`
+	Westbound  MovementCounts `json:"westbound"`
+	Northbound MovementCounts `json:"northbound"`
+}
+
+func (movements *ApproachMovements) approach(direction Direction) *MovementCounts {
+	switch direction {
+	case Vertical:
+		return &movements.Vertical
+	case Westbound:
+		return &movements.Westbound
+	case Northbound:
+		return &movements.Northbound
+	}
+	return &movements.Horizontal
+}
+
+// turnRatios are the weights of turning left, going straight and turning right for cars going direction
+func (config *GeneralLaneSimulationConfig) turnRatios(direction Direction) []float64 {
+	switch direction {
+	case Vertical:
+		return config.verticalTurnRatios
+	case Westbound:
+		return config.westboundTurnRatios
+	case Northbound:
+		return config.northboundTurnRatios
+	}
+	return config.horizontalTurnRatios
+}
+
+func (config *GeneralLaneSimulationConfig) validateTurnRatios() error {
+	for _, direction := range []Direction{Horizontal, Vertical, Westbound, Northbound} {
+		ratios := config.turnRatios(direction)
+		if len(ratios) == 0 {
+			continue
+		}
+		if len(ratios) != 3 {
+			return errors.Errorf("%s turn ratios need a left, straight and right share", direction.name())
+		}
+		total := 0.0
+		for _, ratio := range ratios {
+			if ratio < 0 {
+				return errors.Errorf("%s turn ratios cannot be negative", direction.name())
+			}
+			total += ratio
+		}
+		if total == 0 {
+			return errors.Errorf("%s turn ratios are all zero", direction.name())
+		}
+	}
+	return nil
+}
+
+// turnsAvailable tells which turns a car going direction can make in the intersection starting at loc,
+// a car can only turn onto a street going the new direction
+func (sim *GeneralLaneSimulation) turnsAvailable(loc *StatefulLocation, direction Direction) (bool, bool) {
+	left, right := false, false
+	dx, dy := direction.step()
+	for x, y := loc.X, loc.Y; sim.inGrid(x, y) && sim.Locations[x][y].getLocationState() == Intersection; x, y = x+dx, y+dy {
+		left = left || sim.turnsOnto(direction.left(), x, y)
+		right = right || sim.turnsOnto(direction.right(), x, y)
+	}
+	return left, right
+}
+
+// turnsOnto tells whether x, y is on a lane going direction that carries on past the intersection
+func (sim *GeneralLaneSimulation) turnsOnto(direction Direction, x int, y int) bool {
+	street := sim.streetAt(direction, x, y)
+	return street != nil && street.Direction == direction && sim.canGoStraight(sim.Locations[x][y], direction)
+}
+
+// canGoStraight tells whether the street of a car going direction carries on past the intersection starting at loc
+func (sim *GeneralLaneSimulation) canGoStraight(loc *StatefulLocation, direction Direction) bool {
+	street := sim.streetAt(direction, loc.X, loc.Y)
+	if street == nil {
+		return false
+	}
+	dx, dy := direction.step()
+	x, y := loc.X, loc.Y
+	for sim.inGrid(x, y) && sim.Locations[x][y].getLocationState() == Intersection {
+		x, y = x+dx, y+dy
+	}
+	return street.contains(indexAlong(direction, x, y)) || street.exit || street.next != nil
+}
+
+// chooseMovement picks what the car does at the intersection starting at loc, from the turn ratios of its direction.
+// Turns there is no street for are left out, and where the street ends the car has to turn either way.
+// Without turn ratios every car that can goes straight and no random number is drawn. Cars with a destination
+// follow their route instead
+func (sim *GeneralLaneSimulation) chooseMovement(car *SmartCar, loc *StatefulLocation) Movement {
+	if movement, ok := sim.routeMovement(car, loc); ok {
+		return movement
+	}
+	straight := sim.canGoStraight(loc, car.Direction)
+	ratios := sim.config.turnRatios(car.Direction)
+	if len(ratios) == 0 {
+		if straight {
+			return Straight
+		}
+		ratios = []float64{1, 0, 1}
+	}
+	canTurnLeft, canTurnRight := sim.turnsAvailable(loc, car.Direction)
+	weights := []float64{0, 0, 0}
+	if straight {
+		weights[1] = ratios[1]
+	}
+	if canTurnLeft {
+		weights[0] = ratios[0]
+	}
+	if canTurnRight {
+		weights[2] = ratios[2]
+	}
+	total := weights[0] + weights[1] + weights[2]
+	if total == 0 && !straight && (canTurnLeft || canTurnRight) { // the ratios only allow going straight
+		weights[0], weights[2] = boolWeight(canTurnLeft), boolWeight(canTurnRight)
+		total = weights[0] + weights[2]
+	}
+	if total == 0 {
+		return Straight
+	}
+	pick := sim.random.turns.UniformRand() * total
+	if pick < weights[0] {
+		return LeftTurn
+	}
+	if pick < weights[0]+weights[1] {
+		return Straight
+	}
+	return RightTurn
+}
+
+// turnsLeftAcross tells whether car is turning left in front of other, that comes from the opposite approach
+func (car *SmartCar) turnsLeftAcross(other *SmartCar) bool {
+	if !car.movementChosen || car.movement != LeftTurn {
+		return false
+	}
+	otherApproach := other.Direction
+	if other.movementChosen {
+		otherApproach = other.approach
+	}
+	return otherApproach == car.approach.opposite()
+}
+
+// turnIfNeeded turns the car at x, y onto the street it is heading for, once it reaches one of its lanes.
+// It returns the direction the car goes from here
+func (sim *GeneralLaneSimulation) turnIfNeeded(car *SmartCar, x int, y int) Direction {
+	car.smartCarLock.Lock()
+	direction := car.Direction
+	target := car.approach.after(car.movement)
+	chosen := car.movementChosen
+	car.smartCarLock.Unlock()
+	if !chosen || target == direction || !sim.turnsOnto(target, x, y) {
+		return direction
+	}
+
+	loc := sim.Locations[x][y]
+	cellsBefore := sim.streetAt(direction, x, y).cellsToEnd(indexAlong(direction, x, y))
+	cellsAfter := sim.streetAt(target, x, y).cellsToEnd(indexAlong(target, x, y))
+	car.smartCarLock.Lock()
+	movement := car.movement
+	car.Direction = target
+	car.smartCarLock.Unlock()
+	sim.tripTurned(car, cellsBefore, cellsAfter)
+	sim.traceCar(traceTurned, car, loc, nil, movement.name())
+	return target
+}
+
+func boolWeight(ok bool) float64 {
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// canClearIntersection tells whether the car can drive through the intersection starting at loc without stopping
+// inside it, following its turn if it makes one. Cars from four approaches that enter an intersection they cannot
+// leave end up blocking each other for good. Anything that is not an intersection can always be entered
+func (sim *GeneralLaneSimulation) canClearIntersection(loc *StatefulLocation, direction Direction, movement Movement) bool {
+	target := direction.after(movement)
+	for x, y := loc.X, loc.Y; sim.inGrid(x, y); {
+		next := sim.Locations[x][y]
+		if next.getLocationState() != Intersection {
+			return next == loc || next.isEmpty()
+		}
+		if !next.noCars() {
+			return false
+		}
+		if direction != target && sim.turnsOnto(target, x, y) {
+			direction = target
+		}
+		dx, dy := direction.step()
+		x, y = x+dx, y+dy
+	}
+	return true
+}
@@ -97,15 +97,64 @@ func (user *User) runSimulation(config *GeneralLaneSimulationConfig) {
 		return
 	}
 	simulation, err := initMultiLaneSimulation(config)
-	simulation.setRunningSimulation(true)
 	if err != nil {
-		// TODO handle this
+		user.log(err.Error())
+		return
+	}
+	user.watchSimulation(simulation)
+}
+
+// runSnapshot continues a simulation saved with saveSnapshot
+func (user *User) runSnapshot(path string) {
+	fmt.Println("start run snapshot", path)
+
+	if user.isRunningSimulation() {
+		return
+	}
+	simulation, err := loadSnapshot(path)
+	if err != nil {
+		user.log(err.Error())
+		return
 	}
+	if simulation.playbackSpeed == 0 {
+		simulation.playbackSpeed = 1 // the viewer plays the simulation back in real time
+	}
+	user.watchSimulation(simulation)
+}
+
+// runReplay plays back a trace recorded with traceFile
+func (user *User) runReplay(path string) {
+	fmt.Println("start replay", path)
+
+	if user.isRunningSimulation() {
+		return
+	}
+	simulation, replay, err := loadReplay(path)
+	if err != nil {
+		user.log(err.Error())
+		return
+	}
+	simulation.playbackSpeed = 1 // the viewer plays the trace back in real time
+	user.watch(simulation, func() {
+		RunTraceReplay(simulation, replay)
+	})
+}
+
+// watchSimulation runs the simulation and sends every frame to the client
+func (user *User) watchSimulation(simulation *GeneralLaneSimulation) {
+	user.watch(simulation, func() {
+		RunGeneralSimulation(simulation)
+	})
+}
+
+// watch starts run in the background and sends every frame of the simulation to the client
+func (user *User) watch(simulation *GeneralLaneSimulation, run func()) {
+	simulation.setRunningSimulation(true)
 	user.simulation = simulation
 	user.sendUpdatedSimulation()
 	start := time.Now()
 
-	go RunGeneralSimulation(simulation)
+	go run()
 	for {
 		if !simulation.isRunningSimulation() {
 			fmt.Println("General Lane Simulation completed")
@@ -118,8 +167,16 @@ func (user *User) runSimulation(config *GeneralLaneSimulationConfig) {
 			fmt.Println("Num Accidents ", simulation.numAccidents)
 			simulation.runningSimulationLock.Unlock()
 
+			if !simulation.replaying { // a replay only moves cars around, it has no trips
+				summary := simulation.tripSummary()
+				fmt.Print(summary)
+				user.sendTripSummary(summary)
+				if len(simulation.detectors) > 0 {
+					user.sendDetectorReports(simulation.detectorReports())
+				}
+			}
 			user.sendCompletedSimulation() // only send completed if already running
-			user.simulation.setRunningSimulation(false)
+			simulation.setRunningSimulation(false)
 			return
 		}
 		select {
@@ -131,6 +188,21 @@ func (user *User) runSimulation(config *GeneralLaneSimulationConfig) {
 	}
 }
 
+// sendSnapshotSaved tells the client where the snapshot went, or why it failed
+func (user *User) sendSnapshotSaved(file string, err error) error {
+	data := map[string]string{"file": file}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	message := Message{Event: snapshotSaved, Data: data}
+	marshalledMessage, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	user.write(marshalledMessage)
+	return nil
+}
+
 //
 //func (user *User) runSingleSimulation() {
 //	if user.runningSimulation {
@@ -159,7 +231,6 @@ func (user *User) sendCompletedSimulation() (error) {
 	message := Message{Event: completedSimulation, Data: "Completed"}
 	marshalledMessage, err := json.Marshal(message)
 	if err != nil {
-		panic(err)
 		return err
 		// TODO handle marshall err
 	}
@@ -167,6 +238,28 @@ func (user *User) sendCompletedSimulation() (error) {
 	return nil
 }
 
+// sendTripSummary sends the trip metrics of the finished simulation
+func (user *User) sendTripSummary(summary TripSummary) error {
+	message := Message{Event: tripSummary, Data: summary}
+	marshalledMessage, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	user.write(marshalledMessage)
+	return nil
+}
+
+// sendDetectorReports sends what every loop detector measured
+func (user *User) sendDetectorReports(reports []DetectorReport) error {
+	message := Message{Event: detectorReport, Data: reports}
+	marshalledMessage, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	user.write(marshalledMessage)
+	return nil
+}
+
 func (user *User) sendUpdatedSimulation() (error) {
 	if !user.simulation.isRunningSimulation() {
 		return nil
@@ -175,7 +268,6 @@ func (user *User) sendUpdatedSimulation() (error) {
 	message := Message{Event: simulationUpdate, Data: jsonRes}
 	marshalledMessage, err := json.Marshal(message)
 	if err != nil {
-		panic(err)
 		return err
 		// TODO handle marshall err
 	}
@@ -276,7 +368,7 @@ func (user *User) close() {
 	}
 
 	if user.isRunningSimulation() {
-		user.simulation.cancelSimulation <- true
+		user.simulation.cancel()
 		user.simulation.setRunningSimulation(false)
 		user.simulation = nil
 	}
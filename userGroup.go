@@ -1,11 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"time"
@@ -54,6 +56,11 @@ var (
 	userGroup *UserGroup
 )
 
+const snapshotDir = "snapshots"
+const traceDir = "traces"
+const networkDir = "networks"
+const demandDir = "demand"
+
 func init() {
 	userGroup = newUserGroup()
 	if os.Getenv("DEBUG") != "" {
@@ -61,6 +68,14 @@ func init() {
 	}
 	userGroup.AddEventHandler("startSimulation", startSimulationEvent)
 	userGroup.AddEventHandler("cancelSimulation", cancelSimulation)
+	userGroup.AddEventHandler("pauseSimulation", pauseSimulation)
+	userGroup.AddEventHandler("resumeSimulation", resumeSimulation)
+	userGroup.AddEventHandler("stepSimulation", stepSimulation)
+	userGroup.AddEventHandler("advanceSimulation", advanceSimulation)
+	userGroup.AddEventHandler("setSimulationSpeed", setSimulationSpeed)
+	userGroup.AddEventHandler("saveSnapshot", saveSnapshot)
+	userGroup.AddEventHandler("loadSnapshot", loadSnapshotEvent)
+	userGroup.AddEventHandler("replaySimulation", replaySimulationEvent)
 	rand.Seed(time.Now().Unix())
 }
 
@@ -88,7 +103,160 @@ func cancelSimulation(conn *websocket.Conn, data interface{}) {
 	if !user.isRunningSimulation() {
 		return
 	}
-	user.simulation.cancelSimulation <- true
+	user.simulation.cancel()
+}
+
+// controlData reads a number the client sent under data either as a string or as a number
+func controlData(data interface{}, key string) (float64, bool) {
+	wrapper, ok := data.(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	m, ok := wrapper["data"].(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	switch value := m[key].(type) {
+	case float64:
+		return value, true
+	case string:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, false
+		}
+		return parsed, true
+	}
+	return 0, false
+}
+
+func sendSimulationControl(conn *websocket.Conn, control SimulationControl) {
+	user, exists := userGroup.connUserMap[conn]
+	if !exists {
+		return
+	}
+	if !user.isRunningSimulation() {
+		return
+	}
+	user.simulation.sendControl(control)
+}
+
+func pauseSimulation(conn *websocket.Conn, data interface{}) {
+	fmt.Println("pausing current simulation")
+	sendSimulationControl(conn, SimulationControl{controlType: pauseControl})
+}
+
+func resumeSimulation(conn *websocket.Conn, data interface{}) {
+	fmt.Println("resuming current simulation")
+	sendSimulationControl(conn, SimulationControl{controlType: resumeControl})
+}
+
+func stepSimulation(conn *websocket.Conn, data interface{}) {
+	numEvents := 1
+	if value, ok := controlData(data, "numEvents"); ok {
+		numEvents = int(value)
+	}
+	fmt.Println("stepping current simulation by", numEvents)
+	sendSimulationControl(conn, SimulationControl{controlType: stepControl, numEvents: numEvents})
+}
+
+func advanceSimulation(conn *websocket.Conn, data interface{}) {
+	simulatedTime, ok := controlData(data, "time")
+	if !ok {
+		return
+	}
+	fmt.Println("advancing current simulation to", simulatedTime)
+	sendSimulationControl(conn, SimulationControl{controlType: advanceToControl, time: simulatedTime})
+}
+
+func setSimulationSpeed(conn *websocket.Conn, data interface{}) {
+	speed, ok := controlData(data, "speed")
+	if !ok {
+		return
+	}
+	fmt.Println("setting simulation speed to", speed)
+	sendSimulationControl(conn, SimulationControl{controlType: speedControl, speed: speed})
+}
+
+// snapshotPath keeps snapshots from the websocket inside the snapshot directory
+func snapshotPath(data interface{}) (string, bool) {
+	wrapper, ok := data.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	m, ok := wrapper["data"].(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	file, ok := m["file"].(string)
+	if !ok {
+		return "", false
+	}
+	return pathInDir(snapshotDir, file)
+}
+
+// pathInDir drops any directories the client sent, so files only ever land in dir
+func pathInDir(dir string, file string) (string, bool) {
+	if filepath.Base(file) == "." || filepath.Base(file) == string(filepath.Separator) {
+		return "", false
+	}
+	return filepath.Join(dir, filepath.Base(file)), true
+}
+
+func saveSnapshot(conn *websocket.Conn, data interface{}) {
+	user, exists := userGroup.connUserMap[conn]
+	if !exists {
+		return
+	}
+	if !user.isRunningSimulation() {
+		return
+	}
+	path, ok := snapshotPath(data)
+	if !ok {
+		return
+	}
+	fmt.Println("saving snapshot to", path)
+	err := os.MkdirAll(snapshotDir, 0755)
+	if err == nil {
+		err = user.simulation.requestSnapshot(path)
+	}
+	user.sendSnapshotSaved(filepath.Base(path), err)
+}
+
+func loadSnapshotEvent(conn *websocket.Conn, data interface{}) {
+	user, exists := userGroup.connUserMap[conn]
+	if !exists {
+		return
+	}
+	path, ok := snapshotPath(data)
+	if !ok {
+		return
+	}
+	user.runSnapshot(path)
+}
+
+// replaySimulationEvent plays a trace from the trace directory back to the client
+func replaySimulationEvent(conn *websocket.Conn, data interface{}) {
+	user, exists := userGroup.connUserMap[conn]
+	if !exists {
+		return
+	}
+	wrapper, ok := data.(map[string]interface{})
+	if !ok {
+		return
+	}
+	m, ok := wrapper["data"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	file, ok := m["file"].(string)
+	if !ok {
+		return
+	}
+	path, ok := pathInDir(traceDir, file)
+	if !ok {
+		return
+	}
+	user.runReplay(path)
 }
 
 func startSimulationEvent(conn *websocket.Conn, data interface{}) {
@@ -99,6 +267,7 @@ func startSimulationEvent(conn *websocket.Conn, data interface{}) {
 		return
 	}
 	config := DefaultGeneralLaneConfig()
+	config.wallClockSpeed = 1 // the viewer plays the simulation back in real time
 
 	m := data.(map[string]interface{})["data"].(map[string]interface{})
 	//fmt.Println(m)
@@ -342,6 +511,332 @@ func startSimulationEvent(conn *websocket.Conn, data interface{}) {
 		config.unlikelyCutoff = unlikelyCutoff
 	}
 
+	if wallClockSpeed, ok := m["wallClockSpeed"].(string); ok {
+		wallClockSpeed, err := strconv.ParseFloat(wallClockSpeed, 64)
+		if err != nil {
+			return
+		}
+		config.wallClockSpeed = wallClockSpeed
+	}
+
+	if seed, ok := m["seed"].(string); ok {
+		seed, err := strconv.ParseInt(seed, 10, 64)
+		if err != nil {
+			return
+		}
+		config.seed = seed
+	}
+
+	if detectors, ok := m["detectors"].(string); ok {
+		locations, err := parseDetectorLocations(detectors)
+		if err != nil {
+			return
+		}
+		config.detectors = locations
+	}
+
+	if detectorWindow, ok := m["detectorWindow"].(string); ok {
+		detectorWindow, err := strconv.ParseFloat(detectorWindow, 64)
+		if err != nil {
+			return
+		}
+		config.detectorWindow = detectorWindow
+	}
+
+	if horizontalStreets, ok := m["horizontalStreets"].(string); ok && horizontalStreets != "" {
+		lanes, err := parseIntList(horizontalStreets)
+		if err != nil {
+			return
+		}
+		config.horizontalStreets = lanes
+	}
+
+	if verticalStreets, ok := m["verticalStreets"].(string); ok && verticalStreets != "" {
+		lanes, err := parseIntList(verticalStreets)
+		if err != nil {
+			return
+		}
+		config.verticalStreets = lanes
+	}
+
+	if westboundStreets, ok := m["westboundStreets"].(string); ok && westboundStreets != "" {
+		lanes, err := parseIntList(westboundStreets)
+		if err != nil {
+			return
+		}
+		config.westboundStreets = lanes
+	}
+
+	if northboundStreets, ok := m["northboundStreets"].(string); ok && northboundStreets != "" {
+		lanes, err := parseIntList(northboundStreets)
+		if err != nil {
+			return
+		}
+		config.northboundStreets = lanes
+	}
+
+	if numWestboundLanes, ok := m["numWestboundLanes"].(string); ok {
+		numWestboundLanes, err := strconv.Atoi(numWestboundLanes)
+		if err != nil {
+			return
+		}
+		config.numWestboundLanes = numWestboundLanes
+	}
+
+	if numNorthboundLanes, ok := m["numNorthboundLanes"].(string); ok {
+		numNorthboundLanes, err := strconv.Atoi(numNorthboundLanes)
+		if err != nil {
+			return
+		}
+		config.numNorthboundLanes = numNorthboundLanes
+	}
+
+	if horizontalTurnRatios, ok := m["horizontalTurnRatios"].(string); ok && horizontalTurnRatios != "" {
+		ratios, err := parseFloatList(horizontalTurnRatios)
+		if err != nil {
+			return
+		}
+		config.horizontalTurnRatios = ratios
+	}
+
+	if verticalTurnRatios, ok := m["verticalTurnRatios"].(string); ok && verticalTurnRatios != "" {
+		ratios, err := parseFloatList(verticalTurnRatios)
+		if err != nil {
+			return
+		}
+		config.verticalTurnRatios = ratios
+	}
+
+	if westboundTurnRatios, ok := m["westboundTurnRatios"].(string); ok && westboundTurnRatios != "" {
+		ratios, err := parseFloatList(westboundTurnRatios)
+		if err != nil {
+			return
+		}
+		config.westboundTurnRatios = ratios
+	}
+
+	if northboundTurnRatios, ok := m["northboundTurnRatios"].(string); ok && northboundTurnRatios != "" {
+		ratios, err := parseFloatList(northboundTurnRatios)
+		if err != nil {
+			return
+		}
+		config.northboundTurnRatios = ratios
+	}
+
+	if blockLength, ok := m["blockLength"].(string); ok {
+		blockLength, err := strconv.Atoi(blockLength)
+		if err != nil {
+			return
+		}
+		config.blockLength = blockLength
+	}
+
+	if networkFile, ok := m["networkFile"].(string); ok && networkFile != "" {
+		path, ok := pathInDir(networkDir, networkFile)
+		if !ok {
+			return
+		}
+		config.networkFile = path
+	}
+
+	if osmCellLength, ok := m["osmCellLength"].(string); ok && osmCellLength != "" {
+		osmCellLength, err := strconv.ParseFloat(osmCellLength, 64)
+		if err != nil {
+			return
+		}
+		config.osmCellLength = osmCellLength
+	}
+
+	if ringRoad, ok := m["ringRoad"].(bool); ok {
+		config.ringRoad = ringRoad
+	}
+
+	if ringDuration, ok := m["ringDuration"].(string); ok && ringDuration != "" {
+		ringDuration, err := strconv.ParseFloat(ringDuration, 64)
+		if err != nil {
+			return
+		}
+		config.ringDuration = ringDuration
+	}
+
+	if warmUp, ok := m["warmUp"].(string); ok && warmUp != "" {
+		warmUp, err := strconv.ParseFloat(warmUp, 64)
+		if err != nil {
+			return
+		}
+		config.warmUp = warmUp
+	}
+
+	if openSystem, ok := m["openSystem"].(bool); ok {
+		config.openSystem = openSystem
+	}
+
+	if arrivalProfile, ok := m["arrivalProfile"].(string); ok && arrivalProfile != "" {
+		profile, err := parseArrivalProfile(arrivalProfile)
+		if err != nil {
+			return
+		}
+		config.arrivalProfile = profile
+	}
+
+	if demandProfiles, ok := m["demandProfiles"].(string); ok && demandProfiles != "" {
+		var profiles []DemandProfile
+		if err := json.Unmarshal([]byte(demandProfiles), &profiles); err != nil {
+			return
+		}
+		for i, profile := range profiles {
+			if profile.File == "" {
+				continue
+			}
+			path, ok := pathInDir(demandDir, profile.File)
+			if !ok {
+				return
+			}
+			profiles[i].File = path
+		}
+		config.demandProfiles = profiles
+	}
+
+	if odMatrix, ok := m["odMatrix"].(string); ok && odMatrix != "" {
+		var matrix map[string]map[string]float64
+		if err := json.Unmarshal([]byte(odMatrix), &matrix); err != nil {
+			return
+		}
+		config.odMatrix = matrix
+	}
+
+	if dynamicRouting, ok := m["dynamicRouting"].(bool); ok {
+		config.dynamicRouting = dynamicRouting
+	}
+
+	if congestionCost, ok := m["congestionCost"].(string); ok && congestionCost != "" {
+		congestionCost, err := strconv.ParseFloat(congestionCost, 64)
+		if err != nil {
+			return
+		}
+		config.congestionCost = congestionCost
+	}
+
+	if closures, ok := m["closures"].(string); ok && closures != "" {
+		var parsed []Closure
+		if err := json.Unmarshal([]byte(closures), &parsed); err != nil {
+			return
+		}
+		config.closures = parsed
+	}
+
+	if mergeDistance, ok := m["mergeDistance"].(string); ok && mergeDistance != "" {
+		mergeDistance, err := strconv.Atoi(mergeDistance)
+		if err != nil {
+			return
+		}
+		config.mergeDistance = mergeDistance
+	}
+
+	if signalControl, ok := m["signalControl"].(string); ok {
+		config.signalControl = signalControl
+	}
+
+	if signalPlans, ok := m["signalPlans"].(string); ok && signalPlans != "" {
+		var parsed []SignalPlan
+		if err := json.Unmarshal([]byte(signalPlans), &parsed); err != nil {
+			return
+		}
+		config.signalPlans = parsed
+	}
+
+	if intersectionControl, ok := m["intersectionControl"].(string); ok {
+		config.intersectionControl = intersectionControl
+	}
+
+	if majorRoad, ok := m["majorRoad"].(string); ok && majorRoad != "" {
+		config.majorRoad = majorRoad
+	}
+
+	if yieldGap, ok := m["yieldGap"].(string); ok && yieldGap != "" {
+		yieldGap, err := strconv.Atoi(yieldGap)
+		if err != nil {
+			return
+		}
+		config.yieldGap = yieldGap
+	}
+
+	if emergencyVehicles, ok := m["emergencyVehicles"].(string); ok && emergencyVehicles != "" {
+		var parsed []EmergencyDispatch
+		if err := json.Unmarshal([]byte(emergencyVehicles), &parsed); err != nil {
+			return
+		}
+		config.emergencyVehicles = parsed
+	}
+
+	if emergencySpeed, ok := m["emergencySpeed"].(string); ok && emergencySpeed != "" {
+		emergencySpeed, err := strconv.ParseFloat(emergencySpeed, 64)
+		if err != nil {
+			return
+		}
+		config.emergencySpeed = emergencySpeed
+	}
+
+	if preemptDistance, ok := m["preemptDistance"].(string); ok && preemptDistance != "" {
+		preemptDistance, err := strconv.Atoi(preemptDistance)
+		if err != nil {
+			return
+		}
+		config.preemptDistance = preemptDistance
+	}
+
+	if responders, ok := m["responders"].(string); ok && responders != "" {
+		responders, err := strconv.Atoi(responders)
+		if err != nil {
+			return
+		}
+		config.responders = responders
+	}
+
+	if responderStations, ok := m["responderStations"].(string); ok && responderStations != "" {
+		var parsed [][2]int
+		if err := json.Unmarshal([]byte(responderStations), &parsed); err != nil {
+			return
+		}
+		config.responderStations = parsed
+	}
+
+	if responderSpeed, ok := m["responderSpeed"].(string); ok && responderSpeed != "" {
+		responderSpeed, err := strconv.ParseFloat(responderSpeed, 64)
+		if err != nil {
+			return
+		}
+		config.responderSpeed = responderSpeed
+	}
+
+	if horizon, ok := m["horizon"].(string); ok && horizon != "" {
+		horizon, err := strconv.ParseFloat(horizon, 64)
+		if err != nil {
+			return
+		}
+		config.horizon = horizon
+	}
+
+	if maxExits, ok := m["maxExits"].(string); ok && maxExits != "" {
+		maxExits, err := strconv.Atoi(maxExits)
+		if err != nil {
+			return
+		}
+		config.maxExits = maxExits
+	}
+
+	if traceFile, ok := m["traceFile"].(string); ok && traceFile != "" {
+		path, ok := pathInDir(traceDir, traceFile)
+		if !ok {
+			return
+		}
+		if err := os.MkdirAll(traceDir, 0755); err != nil {
+			user.log(err.Error())
+			return
+		}
+		config.traceFile = path
+	}
+
 	user.runSimulation(config)
 
 }
@@ -4,10 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/go-chi/chi"
-	"github.com/gonum/stat/distuv"
-	distuv2 "gonum.org/v1/gonum/stat/distuv"
+	"gonum.org/v1/gonum/stat/distuv"
 	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -70,8 +70,8 @@ func RightPad2Len(s string, padStr string, overallLen int) string {
 	return retStr[:overallLen]
 }
 
-func getExpRand(rate float64, cutoff float64, removeUnlikelyEvents bool) float64 {
-	var exponential = distuv.Exponential{Rate: rate}
+func getExpRand(stream *RandomStream, rate float64, cutoff float64, removeUnlikelyEvents bool) float64 {
+	var exponential = distuv.Exponential{Rate: rate, Src: stream.source}
 
 	movementTime := exponential.Rand()
 	if !removeUnlikelyEvents {
@@ -90,8 +90,8 @@ func getExpRand(rate float64, cutoff float64, removeUnlikelyEvents bool) float64
 	return movementTime
 }
 
-func getPoissonRand(lambda float64, cutoff float64, removeUnlikelyEvents bool) float64 {
-	var poisson = distuv2.Poisson{Lambda: lambda}
+func getPoissonRand(stream *RandomStream, lambda float64, cutoff float64, removeUnlikelyEvents bool) float64 {
+	var poisson = distuv.Poisson{Lambda: lambda, Src: stream.source}
 
 	movementTime := poisson.Rand()
 	if !removeUnlikelyEvents {
@@ -108,3 +108,29 @@ func getPoissonRand(lambda float64, cutoff float64, removeUnlikelyEvents bool) f
 	}
 	return movementTime
 }
+
+// parseFloatList reads numbers separated by commas
+func parseFloatList(s string) ([]float64, error) {
+	values := make([]float64, 0)
+	for _, item := range strings.Split(s, ",") {
+		value, err := strconv.ParseFloat(strings.TrimSpace(item), 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// parseIntList reads integers separated by commas
+func parseIntList(s string) ([]int, error) {
+	values := make([]int, 0)
+	for _, item := range strings.Split(s, ",") {
+		value, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}